@@ -0,0 +1,278 @@
+// Package app wires the configured storage backend into the API controller.
+// Both the main server and the web server build their API through it.
+package app
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hiteshchoudhary/mongodb/config"
+	"github.com/hiteshchoudhary/mongodb/controller"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type App struct {
+	Config config.Config
+	API    *controller.Controller
+
+	// Client and DB are nil when running on the in-memory store.
+	Client *mongo.Client
+	DB     *mongo.Database
+
+	// stopWorkers stops the background workers and waits for them.
+	stopWorkers func()
+}
+
+func New(cfg config.Config) (*App, error) {
+	a := &App{Config: cfg}
+	opts, err := authOptions(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	var provider controller.MetadataProvider
+	if cfg.Enrichment.Dataset != "" {
+		if provider, err = controller.LoadDataset(cfg.Enrichment.Dataset); err != nil {
+			return nil, err
+		}
+	}
+	var stores controller.Stores
+	switch cfg.Store {
+	case config.StoreMemory:
+		log.Println("Using in-memory storage; data will not survive a restart")
+		stores = controller.Stores{
+			Courses:         controller.NewMemoryCourseStore(),
+			Movies:          controller.NewMemoryMovieStore(),
+			Watches:         controller.NewMemoryWatchStore(),
+			APIKeys:         controller.NewMemoryAPIKeyStore(),
+			Audit:           controller.NewMemoryAuditStore(),
+			Reviews:         controller.NewMemoryReviewStore(),
+			Playlists:       controller.NewMemoryPlaylistStore(),
+			Picks:           controller.NewMemoryPickStore(),
+			Confirmations:   controller.NewMemoryConfirmationStore(),
+			CourseRevisions: controller.NewMemoryCourseRevisionStore(),
+		}
+	case config.StoreMongo:
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Mongo.ConnectTimeout))
+		defer cancel()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+		}
+		if err := client.Ping(ctx, nil); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+		}
+		log.Println("Successfully connected to MongoDB")
+		a.Client = client
+		a.DB = client.Database(cfg.Mongo.Database)
+		// Index builds and backfills scan whole collections, so they get
+		// their own, longer deadline.
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(cfg.Mongo.SetupTimeout))
+		defer cancel()
+		courses := controller.NewMongoCourseStore(a.DB.Collection(cfg.Mongo.CoursesCollection))
+		if err := courses.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create course indexes: %w", err)
+		}
+		apiKeys := controller.NewMongoAPIKeyStore(a.DB.Collection(cfg.Mongo.APIKeysCollection))
+		if err := apiKeys.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create API key indexes: %w", err)
+		}
+		watches := controller.NewMongoWatchStore(
+			a.DB.Collection(cfg.Mongo.WatchesCollection),
+			a.DB.Collection(cfg.Mongo.WatchEventsCollection),
+		)
+		if err := watches.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create watch state indexes: %w", err)
+		}
+		movies := controller.NewMongoMovieStore(a.DB.Collection(cfg.Mongo.MoviesCollection))
+		if err := movies.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create movie indexes: %w", err)
+		}
+		reviews := controller.NewMongoReviewStore(a.DB.Collection(cfg.Mongo.ReviewsCollection))
+		if err := reviews.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create review indexes: %w", err)
+		}
+		playlists := controller.NewMongoPlaylistStore(a.DB.Collection(cfg.Mongo.PlaylistsCollection))
+		if err := playlists.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create playlist indexes: %w", err)
+		}
+		picks := controller.NewMongoPickStore(a.DB.Collection(cfg.Mongo.PicksCollection))
+		if err := picks.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create pick indexes: %w", err)
+		}
+		confirmations := controller.NewMongoConfirmationStore(a.DB.Collection(cfg.Mongo.ConfirmationsCollection))
+		if err := confirmations.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create confirmation indexes: %w", err)
+		}
+		revisions := controller.NewMongoCourseRevisionStore(a.DB.Collection(cfg.Mongo.CourseRevisionsCollection))
+		if err := revisions.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create course revision indexes: %w", err)
+		}
+		audit := controller.NewMongoAuditStore(a.DB.Collection(cfg.Mongo.AuditCollection))
+		if err := audit.EnsureIndexes(ctx); err != nil {
+			client.Disconnect(context.Background())
+			return nil, fmt.Errorf("failed to create audit indexes: %w", err)
+		}
+		if err := migrateWatchedFlags(ctx, a.DB.Collection(cfg.Mongo.MoviesCollection), watches, cfg.Mongo.LegacyWatchedOwner); err != nil {
+			client.Disconnect(context.Background())
+			return nil, err
+		}
+		stores = controller.Stores{
+			Courses:         courses,
+			Movies:          movies,
+			Watches:         watches,
+			APIKeys:         apiKeys,
+			Audit:           audit,
+			Reviews:         reviews,
+			Playlists:       playlists,
+			Picks:           picks,
+			Confirmations:   confirmations,
+			CourseRevisions: revisions,
+		}
+	default:
+		return nil, fmt.Errorf("unknown store %q", cfg.Store)
+	}
+	if provider != nil {
+		opts.Enricher = controller.NewEnricher(stores.Movies, provider, controller.EnricherOptions{
+			Workers:      cfg.Enrichment.Workers,
+			MaxAttempts:  cfg.Enrichment.MaxAttempts,
+			RetryDelay:   time.Duration(cfg.Enrichment.RetryDelay),
+			PollInterval: time.Duration(cfg.Enrichment.PollInterval),
+		})
+		a.startWorkers(opts.Enricher.Run)
+		log.Printf("Enriching movie metadata from %s with %d workers", cfg.Enrichment.Dataset, cfg.Enrichment.Workers)
+	}
+	opts.RequireIfMatch = cfg.Server.RequireIfMatch
+	opts.TrashRetention = time.Duration(cfg.Trash.Retention)
+	if opts.TrashRetention > 0 {
+		purger := controller.NewPurger(stores, opts.TrashRetention, time.Duration(cfg.Trash.PurgeInterval))
+		a.startWorkers(purger.Run)
+	}
+	a.API = controller.New(stores, opts)
+	return a, nil
+}
+
+// startWorkers runs run in the background until Close, alongside any
+// workers already started.
+func (a *App) startWorkers(run func(context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+	stopOthers := a.stopWorkers
+	a.stopWorkers = func() {
+		cancel()
+		<-done
+		if stopOthers != nil {
+			stopOthers()
+		}
+	}
+}
+
+// migrateWatchedFlags moves movies flagged with the legacy global watched
+// field onto the configured owner's watchlist, or warns when no owner is
+// configured and flagged movies remain.
+func migrateWatchedFlags(ctx context.Context, movies *mongo.Collection, watches controller.WatchStore, owner string) error {
+	if owner == "" {
+		n, err := controller.CountWatchedFlags(ctx, movies)
+		if err != nil {
+			return fmt.Errorf("failed to count legacy watched flags: %w", err)
+		}
+		if n > 0 {
+			log.Printf("%d movies still carry the legacy watched flag; set mongo.legacyWatchedOwner to move them to a user's watchlist", n)
+		}
+		return nil
+	}
+	n, err := controller.MigrateWatchedFlags(ctx, movies, watches, owner, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to migrate legacy watched flags: %w", err)
+	}
+	if n > 0 {
+		log.Printf("Moved %d legacy watched movies to %s's watchlist", n, owner)
+	}
+	return nil
+}
+
+// authOptions turns the auth configuration into controller options,
+// loading the RS256 public key if one is configured.
+func authOptions(cfg config.AuthConfig) (controller.Options, error) {
+	opts := controller.Options{AnonymousReads: cfg.AnonymousReads}
+	if cfg.BootstrapKey != "" {
+		opts.BootstrapKeyHash = controller.HashAPIKey(cfg.BootstrapKey)
+		log.Println("Bootstrap API key is enabled; unset it once stored keys exist")
+	}
+	if cfg.JWTSecret != "" || cfg.JWTPublicKeyFile != "" {
+		opts.JWT = &controller.JWTVerifier{Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience}
+		if cfg.JWTSecret != "" {
+			opts.JWT.HMACSecret = []byte(cfg.JWTSecret)
+		}
+		if cfg.JWTPublicKeyFile != "" {
+			key, err := loadRSAPublicKey(cfg.JWTPublicKeyFile)
+			if err != nil {
+				return controller.Options{}, err
+			}
+			opts.JWT.RSAPublicKey = key
+		}
+	}
+	return opts, nil
+}
+
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("JWT public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT public key %s: no PEM block found", path)
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("JWT public key %s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("JWT public key %s: not an RSA key", path)
+	}
+	return key, nil
+}
+
+// Collection returns the named collection, or nil on the in-memory store.
+func (a *App) Collection(name string) *mongo.Collection {
+	if a.DB == nil {
+		return nil
+	}
+	return a.DB.Collection(name)
+}
+
+func (a *App) Close(ctx context.Context) error {
+	if a.stopWorkers != nil {
+		a.stopWorkers()
+		a.stopWorkers = nil
+	}
+	if a.Client == nil {
+		return nil
+	}
+	return a.Client.Disconnect(ctx)
+}
@@ -0,0 +1,526 @@
+// Package config loads the server configuration. Values are layered, each
+// layer overriding the previous one: built-in defaults, an optional JSON
+// config file, environment variables and finally command-line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StoreMongo  = "mongo"
+	StoreMemory = "memory"
+)
+
+type Config struct {
+	Store  string       `json:"store"`
+	Server ServerConfig `json:"server"`
+	Mongo  MongoConfig  `json:"mongo"`
+	Auth   AuthConfig   `json:"auth"`
+	// Enrichment fills in missing movie metadata in the background.
+	Enrichment EnrichmentConfig `json:"enrichment"`
+	Trash      TrashConfig      `json:"trash"`
+}
+
+type ServerConfig struct {
+	Addr string `json:"addr"`
+	// RequireIfMatch makes PUT, PATCH and DELETE of courses and movies
+	// fail unless they send If-Match, so no client can overwrite a change
+	// it has not seen.
+	RequireIfMatch bool `json:"requireIfMatch"`
+}
+
+type MongoConfig struct {
+	URI                string `json:"uri"`
+	Database           string `json:"database"`
+	ChaptersCollection string `json:"chaptersCollection"`
+	CoursesCollection  string `json:"coursesCollection"`
+	MoviesCollection   string `json:"moviesCollection"`
+	WatchesCollection  string `json:"watchesCollection"`
+	// WatchEventsCollection holds the append-only log of every viewing.
+	WatchEventsCollection string `json:"watchEventsCollection"`
+	APIKeysCollection     string `json:"apiKeysCollection"`
+	AuditCollection       string `json:"auditCollection"`
+	ReviewsCollection     string `json:"reviewsCollection"`
+	PlaylistsCollection   string `json:"playlistsCollection"`
+	PicksCollection       string `json:"picksCollection"`
+	// ConfirmationsCollection holds the short-lived tokens that confirm
+	// bulk deletes.
+	ConfirmationsCollection string `json:"confirmationsCollection"`
+	// CourseRevisionsCollection holds the revision history of courses.
+	CourseRevisionsCollection string   `json:"courseRevisionsCollection"`
+	ConnectTimeout            Duration `json:"connectTimeout"`
+	// SetupTimeout bounds building indexes and migrating data at
+	// startup, which takes longer than connecting on large collections.
+	SetupTimeout Duration `json:"setupTimeout"`
+	// LegacyWatchedOwner is the subject whose watchlist receives movies
+	// still flagged with the old global watched field. Until it is set,
+	// those flags are left in place.
+	LegacyWatchedOwner string `json:"legacyWatchedOwner"`
+}
+
+// AuthConfig controls how API callers authenticate. JWTs are accepted when
+// JWTSecret (HS256) or JWTPublicKeyFile (RS256, PEM) is set.
+type AuthConfig struct {
+	JWTSecret        string `json:"jwtSecret"`
+	JWTPublicKeyFile string `json:"jwtPublicKeyFile"`
+	JWTIssuer        string `json:"jwtIssuer"`
+	JWTAudience      string `json:"jwtAudience"`
+	// BootstrapKey is an API key accepted without being stored, used to
+	// create the first stored key. Leave it unset once real keys exist.
+	BootstrapKey   string `json:"bootstrapKey"`
+	AnonymousReads bool   `json:"anonymousReads"`
+}
+
+// EnrichmentConfig controls the movie metadata enrichment workers. They
+// run only when Dataset is set.
+type EnrichmentConfig struct {
+	// Dataset is the path of a JSON or CSV metadata file.
+	Dataset     string `json:"dataset"`
+	Workers     int    `json:"workers"`
+	MaxAttempts int    `json:"maxAttempts"`
+	// RetryDelay is the wait after the first failed lookup; it doubles
+	// with each further attempt.
+	RetryDelay   Duration `json:"retryDelay"`
+	PollInterval Duration `json:"pollInterval"`
+}
+
+// TrashConfig controls how long deleted movies and courses can be
+// restored before they are purged for good.
+type TrashConfig struct {
+	// Retention is how long items stay in the trash; zero keeps them
+	// until restored.
+	Retention Duration `json:"retention"`
+	// PurgeInterval is how often expired items are looked for.
+	PurgeInterval Duration `json:"purgeInterval"`
+}
+
+// Duration is a time.Duration that reads and writes as a Go duration
+// string ("10s") in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func Default() Config {
+	return Config{
+		Store: StoreMongo,
+		Server: ServerConfig{
+			Addr: ":4000",
+		},
+		Mongo: MongoConfig{
+			URI:                       "mongodb://localhost:27017",
+			Database:                  "go_app",
+			ChaptersCollection:        "chapters",
+			CoursesCollection:         "courses",
+			MoviesCollection:          "movies",
+			WatchesCollection:         "watches",
+			WatchEventsCollection:     "watch_events",
+			APIKeysCollection:         "api_keys",
+			AuditCollection:           "audit",
+			ReviewsCollection:         "reviews",
+			PlaylistsCollection:       "playlists",
+			PicksCollection:           "picks",
+			ConfirmationsCollection:   "confirmations",
+			CourseRevisionsCollection: "course_revisions",
+			ConnectTimeout:            Duration(10 * time.Second),
+			SetupTimeout:              Duration(5 * time.Minute),
+		},
+		Enrichment: EnrichmentConfig{
+			Workers:      2,
+			MaxAttempts:  5,
+			RetryDelay:   Duration(30 * time.Second),
+			PollInterval: Duration(time.Minute),
+		},
+		Trash: TrashConfig{
+			Retention:     Duration(30 * 24 * time.Hour),
+			PurgeInterval: Duration(time.Hour),
+		},
+	}
+}
+
+// Load builds the effective configuration from args (without the program
+// name) and the process environment, then validates it.
+func Load(args []string) (Config, error) {
+	return load(args, os.LookupEnv)
+}
+
+func load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+
+	var flagCfg Config
+	var configFile string
+	var connectTimeout, setupTimeout, retryDelay, pollInterval, retention, purgeInterval time.Duration
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&configFile, "config", "", "path to a JSON config file (env CONFIG_FILE)")
+	fs.StringVar(&flagCfg.Store, "store", "", "storage backend: mongo or memory (env STORE_BACKEND)")
+	fs.StringVar(&flagCfg.Server.Addr, "addr", "", "HTTP listen address (env SERVER_ADDR)")
+	fs.BoolVar(&flagCfg.Server.RequireIfMatch, "require-if-match", false, "require If-Match on PUT, PATCH and DELETE of courses and movies (env SERVER_REQUIRE_IF_MATCH)")
+	fs.StringVar(&flagCfg.Mongo.URI, "mongo-uri", "", "MongoDB connection string (env MONGO_URI)")
+	fs.StringVar(&flagCfg.Mongo.Database, "mongo-database", "", "MongoDB database name (env MONGO_DATABASE)")
+	fs.StringVar(&flagCfg.Mongo.ChaptersCollection, "chapters-collection", "", "chapters collection name (env MONGO_CHAPTERS_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.CoursesCollection, "courses-collection", "", "courses collection name (env MONGO_COURSES_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.MoviesCollection, "movies-collection", "", "movies collection name (env MONGO_MOVIES_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.WatchesCollection, "watches-collection", "", "watch state collection name (env MONGO_WATCHES_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.WatchEventsCollection, "watch-events-collection", "", "watch event log collection name (env MONGO_WATCH_EVENTS_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.LegacyWatchedOwner, "legacy-watched-owner", "", "subject that inherits movies flagged watched before per-user watchlists (env MONGO_LEGACY_WATCHED_OWNER)")
+	fs.StringVar(&flagCfg.Mongo.APIKeysCollection, "api-keys-collection", "", "API keys collection name (env MONGO_API_KEYS_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.AuditCollection, "audit-collection", "", "audit trail collection name (env MONGO_AUDIT_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.ReviewsCollection, "reviews-collection", "", "movie reviews collection name (env MONGO_REVIEWS_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.PlaylistsCollection, "playlists-collection", "", "playlists and watch-next queues collection name (env MONGO_PLAYLISTS_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.PicksCollection, "picks-collection", "", "random movie picks collection name (env MONGO_PICKS_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.ConfirmationsCollection, "confirmations-collection", "", "bulk action confirmation tokens collection name (env MONGO_CONFIRMATIONS_COLLECTION)")
+	fs.StringVar(&flagCfg.Mongo.CourseRevisionsCollection, "course-revisions-collection", "", "course revision history collection name (env MONGO_COURSE_REVISIONS_COLLECTION)")
+	fs.DurationVar(&connectTimeout, "mongo-connect-timeout", 0, "timeout for connecting to MongoDB (env MONGO_CONNECT_TIMEOUT)")
+	fs.DurationVar(&setupTimeout, "mongo-setup-timeout", 0, "timeout for building indexes and migrating data at startup (env MONGO_SETUP_TIMEOUT)")
+	fs.StringVar(&flagCfg.Auth.JWTPublicKeyFile, "jwt-public-key-file", "", "PEM RSA public key for RS256 tokens (env AUTH_JWT_PUBLIC_KEY_FILE)")
+	fs.StringVar(&flagCfg.Auth.JWTIssuer, "jwt-issuer", "", "required JWT iss claim (env AUTH_JWT_ISSUER)")
+	fs.StringVar(&flagCfg.Auth.JWTAudience, "jwt-audience", "", "required JWT aud claim (env AUTH_JWT_AUDIENCE)")
+	fs.BoolVar(&flagCfg.Auth.AnonymousReads, "anonymous-reads", false, "allow GET requests without credentials (env AUTH_ANONYMOUS_READS)")
+	fs.StringVar(&flagCfg.Enrichment.Dataset, "enrichment-dataset", "", "JSON or CSV movie metadata file; enables enrichment (env ENRICHMENT_DATASET)")
+	fs.IntVar(&flagCfg.Enrichment.Workers, "enrichment-workers", 0, "concurrent metadata lookups (env ENRICHMENT_WORKERS)")
+	fs.IntVar(&flagCfg.Enrichment.MaxAttempts, "enrichment-max-attempts", 0, "lookups per movie before giving up (env ENRICHMENT_MAX_ATTEMPTS)")
+	fs.DurationVar(&retryDelay, "enrichment-retry-delay", 0, "wait after the first failed lookup, doubling each attempt (env ENRICHMENT_RETRY_DELAY)")
+	fs.DurationVar(&pollInterval, "enrichment-poll-interval", 0, "how often idle workers check for due retries (env ENRICHMENT_POLL_INTERVAL)")
+	fs.DurationVar(&retention, "trash-retention", 0, "how long deleted items can be restored; 0 keeps them (env TRASH_RETENTION)")
+	fs.DurationVar(&purgeInterval, "trash-purge-interval", 0, "how often expired trash is purged (env TRASH_PURGE_INTERVAL)")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	flagCfg.Mongo.ConnectTimeout = Duration(connectTimeout)
+	flagCfg.Mongo.SetupTimeout = Duration(setupTimeout)
+	flagCfg.Enrichment.RetryDelay = Duration(retryDelay)
+	flagCfg.Enrichment.PollInterval = Duration(pollInterval)
+	flagCfg.Trash.Retention = Duration(retention)
+	flagCfg.Trash.PurgeInterval = Duration(purgeInterval)
+
+	if configFile == "" {
+		configFile, _ = lookupEnv("CONFIG_FILE")
+	}
+	if configFile != "" {
+		if err := loadFile(&cfg, configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := applyEnv(&cfg, lookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "store":
+			cfg.Store = flagCfg.Store
+		case "addr":
+			cfg.Server.Addr = flagCfg.Server.Addr
+		case "require-if-match":
+			cfg.Server.RequireIfMatch = flagCfg.Server.RequireIfMatch
+		case "mongo-uri":
+			cfg.Mongo.URI = flagCfg.Mongo.URI
+		case "mongo-database":
+			cfg.Mongo.Database = flagCfg.Mongo.Database
+		case "chapters-collection":
+			cfg.Mongo.ChaptersCollection = flagCfg.Mongo.ChaptersCollection
+		case "courses-collection":
+			cfg.Mongo.CoursesCollection = flagCfg.Mongo.CoursesCollection
+		case "movies-collection":
+			cfg.Mongo.MoviesCollection = flagCfg.Mongo.MoviesCollection
+		case "watches-collection":
+			cfg.Mongo.WatchesCollection = flagCfg.Mongo.WatchesCollection
+		case "watch-events-collection":
+			cfg.Mongo.WatchEventsCollection = flagCfg.Mongo.WatchEventsCollection
+		case "legacy-watched-owner":
+			cfg.Mongo.LegacyWatchedOwner = flagCfg.Mongo.LegacyWatchedOwner
+		case "api-keys-collection":
+			cfg.Mongo.APIKeysCollection = flagCfg.Mongo.APIKeysCollection
+		case "audit-collection":
+			cfg.Mongo.AuditCollection = flagCfg.Mongo.AuditCollection
+		case "reviews-collection":
+			cfg.Mongo.ReviewsCollection = flagCfg.Mongo.ReviewsCollection
+		case "playlists-collection":
+			cfg.Mongo.PlaylistsCollection = flagCfg.Mongo.PlaylistsCollection
+		case "picks-collection":
+			cfg.Mongo.PicksCollection = flagCfg.Mongo.PicksCollection
+		case "confirmations-collection":
+			cfg.Mongo.ConfirmationsCollection = flagCfg.Mongo.ConfirmationsCollection
+		case "course-revisions-collection":
+			cfg.Mongo.CourseRevisionsCollection = flagCfg.Mongo.CourseRevisionsCollection
+		case "mongo-connect-timeout":
+			cfg.Mongo.ConnectTimeout = flagCfg.Mongo.ConnectTimeout
+		case "mongo-setup-timeout":
+			cfg.Mongo.SetupTimeout = flagCfg.Mongo.SetupTimeout
+		case "jwt-public-key-file":
+			cfg.Auth.JWTPublicKeyFile = flagCfg.Auth.JWTPublicKeyFile
+		case "jwt-issuer":
+			cfg.Auth.JWTIssuer = flagCfg.Auth.JWTIssuer
+		case "jwt-audience":
+			cfg.Auth.JWTAudience = flagCfg.Auth.JWTAudience
+		case "anonymous-reads":
+			cfg.Auth.AnonymousReads = flagCfg.Auth.AnonymousReads
+		case "enrichment-dataset":
+			cfg.Enrichment.Dataset = flagCfg.Enrichment.Dataset
+		case "enrichment-workers":
+			cfg.Enrichment.Workers = flagCfg.Enrichment.Workers
+		case "enrichment-max-attempts":
+			cfg.Enrichment.MaxAttempts = flagCfg.Enrichment.MaxAttempts
+		case "enrichment-retry-delay":
+			cfg.Enrichment.RetryDelay = flagCfg.Enrichment.RetryDelay
+		case "enrichment-poll-interval":
+			cfg.Enrichment.PollInterval = flagCfg.Enrichment.PollInterval
+		case "trash-retention":
+			cfg.Trash.Retention = flagCfg.Trash.Retention
+		case "trash-purge-interval":
+			cfg.Trash.PurgeInterval = flagCfg.Trash.PurgeInterval
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func applyEnv(cfg *Config, lookupEnv func(string) (string, bool)) error {
+	vars := map[string]*string{
+		"STORE_BACKEND":                     &cfg.Store,
+		"SERVER_ADDR":                       &cfg.Server.Addr,
+		"MONGO_URI":                         &cfg.Mongo.URI,
+		"MONGO_DATABASE":                    &cfg.Mongo.Database,
+		"MONGO_CHAPTERS_COLLECTION":         &cfg.Mongo.ChaptersCollection,
+		"MONGO_COURSES_COLLECTION":          &cfg.Mongo.CoursesCollection,
+		"MONGO_MOVIES_COLLECTION":           &cfg.Mongo.MoviesCollection,
+		"MONGO_WATCHES_COLLECTION":          &cfg.Mongo.WatchesCollection,
+		"MONGO_WATCH_EVENTS_COLLECTION":     &cfg.Mongo.WatchEventsCollection,
+		"MONGO_LEGACY_WATCHED_OWNER":        &cfg.Mongo.LegacyWatchedOwner,
+		"MONGO_API_KEYS_COLLECTION":         &cfg.Mongo.APIKeysCollection,
+		"MONGO_AUDIT_COLLECTION":            &cfg.Mongo.AuditCollection,
+		"MONGO_REVIEWS_COLLECTION":          &cfg.Mongo.ReviewsCollection,
+		"MONGO_PLAYLISTS_COLLECTION":        &cfg.Mongo.PlaylistsCollection,
+		"MONGO_PICKS_COLLECTION":            &cfg.Mongo.PicksCollection,
+		"MONGO_CONFIRMATIONS_COLLECTION":    &cfg.Mongo.ConfirmationsCollection,
+		"MONGO_COURSE_REVISIONS_COLLECTION": &cfg.Mongo.CourseRevisionsCollection,
+		"AUTH_JWT_SECRET":                   &cfg.Auth.JWTSecret,
+		"AUTH_JWT_PUBLIC_KEY_FILE":          &cfg.Auth.JWTPublicKeyFile,
+		"AUTH_JWT_ISSUER":                   &cfg.Auth.JWTIssuer,
+		"AUTH_JWT_AUDIENCE":                 &cfg.Auth.JWTAudience,
+		"AUTH_BOOTSTRAP_KEY":                &cfg.Auth.BootstrapKey,
+		"ENRICHMENT_DATASET":                &cfg.Enrichment.Dataset,
+	}
+	for name, dst := range vars {
+		if v, ok := lookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	durations := map[string]*Duration{
+		"MONGO_CONNECT_TIMEOUT":    &cfg.Mongo.ConnectTimeout,
+		"MONGO_SETUP_TIMEOUT":      &cfg.Mongo.SetupTimeout,
+		"ENRICHMENT_RETRY_DELAY":   &cfg.Enrichment.RetryDelay,
+		"ENRICHMENT_POLL_INTERVAL": &cfg.Enrichment.PollInterval,
+		"TRASH_RETENTION":          &cfg.Trash.Retention,
+		"TRASH_PURGE_INTERVAL":     &cfg.Trash.PurgeInterval,
+	}
+	for name, dst := range durations {
+		if v, ok := lookupEnv(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = Duration(d)
+		}
+	}
+	ints := map[string]*int{
+		"ENRICHMENT_WORKERS":      &cfg.Enrichment.Workers,
+		"ENRICHMENT_MAX_ATTEMPTS": &cfg.Enrichment.MaxAttempts,
+	}
+	for name, dst := range ints {
+		if v, ok := lookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = n
+		}
+	}
+	bools := map[string]*bool{
+		"SERVER_REQUIRE_IF_MATCH": &cfg.Server.RequireIfMatch,
+		"AUTH_ANONYMOUS_READS":    &cfg.Auth.AnonymousReads,
+	}
+	for name, dst := range bools {
+		if v, ok := lookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = b
+		}
+	}
+	return nil
+}
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (c Config) Validate() error {
+	var problems []string
+	switch c.Store {
+	case StoreMongo, StoreMemory:
+	default:
+		problems = append(problems, fmt.Sprintf("store: must be %q or %q, got %q", StoreMongo, StoreMemory, c.Store))
+	}
+	if _, port, err := net.SplitHostPort(c.Server.Addr); err != nil || port == "" {
+		problems = append(problems, fmt.Sprintf("server.addr: must be host:port or :port, got %q", c.Server.Addr))
+	}
+	if c.Store == StoreMongo {
+		if c.Mongo.URI == "" {
+			problems = append(problems, "mongo.uri: required when store is mongo")
+		} else if u, err := url.Parse(c.Mongo.URI); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+			problems = append(problems, "mongo.uri: must be a mongodb:// or mongodb+srv:// connection string")
+		}
+		if c.Mongo.Database == "" {
+			problems = append(problems, "mongo.database: required when store is mongo")
+		}
+		collections := []string{
+			c.Mongo.ChaptersCollection, c.Mongo.CoursesCollection, c.Mongo.MoviesCollection,
+			c.Mongo.WatchesCollection, c.Mongo.WatchEventsCollection, c.Mongo.APIKeysCollection,
+			c.Mongo.AuditCollection, c.Mongo.ReviewsCollection, c.Mongo.PlaylistsCollection,
+			c.Mongo.PicksCollection,
+			c.Mongo.ConfirmationsCollection, c.Mongo.CourseRevisionsCollection,
+		}
+		for _, name := range collections {
+			if name == "" {
+				problems = append(problems, "mongo: collection names must not be empty")
+				break
+			}
+		}
+		if c.Mongo.ConnectTimeout <= 0 {
+			problems = append(problems, "mongo.connectTimeout: must be positive")
+		}
+		if c.Mongo.SetupTimeout <= 0 {
+			problems = append(problems, "mongo.setupTimeout: must be positive")
+		}
+	}
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < 32 {
+		problems = append(problems, "auth.jwtSecret: must be at least 32 bytes for HS256")
+	}
+	if c.Auth.BootstrapKey != "" && len(c.Auth.BootstrapKey) < 24 {
+		problems = append(problems, "auth.bootstrapKey: must be at least 24 characters")
+	}
+	if c.Enrichment.Workers < 1 {
+		problems = append(problems, "enrichment.workers: must be at least 1")
+	}
+	if c.Enrichment.MaxAttempts < 1 {
+		problems = append(problems, "enrichment.maxAttempts: must be at least 1")
+	}
+	if c.Enrichment.RetryDelay <= 0 {
+		problems = append(problems, "enrichment.retryDelay: must be positive")
+	}
+	if c.Enrichment.PollInterval <= 0 {
+		problems = append(problems, "enrichment.pollInterval: must be positive")
+	}
+	if c.Trash.Retention < 0 {
+		problems = append(problems, "trash.retention: must not be negative")
+	}
+	if c.Trash.PurgeInterval <= 0 {
+		problems = append(problems, "trash.purgeInterval: must be positive")
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Redacted returns a copy of c that is safe to log: credentials in the Mongo
+// connection string and auth secrets are masked.
+func (c Config) Redacted() Config {
+	c.Mongo.URI = redactURI(c.Mongo.URI)
+	c.Auth.JWTSecret = redactSecret(c.Auth.JWTSecret)
+	c.Auth.BootstrapKey = redactSecret(c.Auth.BootstrapKey)
+	return c
+}
+
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "xxxxx"
+}
+
+func redactURI(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		if raw == "" {
+			return ""
+		}
+		return "[unparseable, redacted]"
+	}
+	q := u.Query()
+	changed := false
+	for key := range q {
+		lower := strings.ToLower(key)
+		if strings.Contains(lower, "password") || strings.Contains(lower, "secret") {
+			q.Set(key, "xxxxx")
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// String renders the redacted configuration as JSON.
+func (c Config) String() string {
+	b, err := json.MarshalIndent(c.Redacted(), "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", c.Redacted())
+	}
+	return string(b)
+}
+
+// IsHelp reports whether err came from -h or -help on the command line.
+func IsHelp(err error) bool {
+	return errors.Is(err, flag.ErrHelp)
+}
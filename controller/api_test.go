@@ -0,0 +1,83 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+	"github.com/hiteshchoudhary/mongodb/router"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The handler tests run the full router over memory stores, calling it as
+// the bootstrap admin unless a test sends another key.
+
+const bootstrapKey = "bootstrap-key-0123456789abcdef"
+
+// memoryStores returns an empty memory store for everything.
+func memoryStores() controller.Stores {
+	return controller.Stores{
+		Courses:         controller.NewMemoryCourseStore(),
+		Movies:          controller.NewMemoryMovieStore(),
+		Watches:         controller.NewMemoryWatchStore(),
+		APIKeys:         controller.NewMemoryAPIKeyStore(),
+		Audit:           controller.NewMemoryAuditStore(),
+		Reviews:         controller.NewMemoryReviewStore(),
+		Playlists:       controller.NewMemoryPlaylistStore(),
+		Picks:           controller.NewMemoryPickStore(),
+		Confirmations:   controller.NewMemoryConfirmationStore(),
+		CourseRevisions: controller.NewMemoryCourseRevisionStore(),
+	}
+}
+
+func newTestAPI(stores controller.Stores) http.Handler {
+	return newTestAPIWith(stores, controller.Options{})
+}
+
+// newTestAPIWith is newTestAPI with options; the bootstrap key is set.
+func newTestAPIWith(stores controller.Stores, opts controller.Options) http.Handler {
+	opts.BootstrapKeyHash = controller.HashAPIKey(bootstrapKey)
+	return router.Router(controller.New(stores, opts))
+}
+
+// fixedID returns a fixed ObjectID, so seeded documents sort predictably.
+func fixedID(n int) primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(fmt.Sprintf("%024x", n))
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// request sends a JSON request as the bootstrap admin. header holds extra
+// name, value pairs, which may replace the defaults.
+func request(h http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", bootstrapKey)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+// deref shows an optional string, such as a missing link, in messages.
+func deref(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKey is a stored API key. Only the SHA-256 hash of the secret is kept;
+// the secret itself is shown once, when the key is created.
+type APIKey struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	Subject   string             `json:"subject" bson:"subject"`
+	Role      Role               `json:"role" bson:"role"`
+	Prefix    string             `json:"prefix" bson:"prefix"`
+	Hash      string             `json:"-" bson:"hash"`
+	CreatedBy string             `json:"createdBy" bson:"createdBy"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	RevokedAt *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// APIKeyStore is the persistence boundary for API keys.
+type APIKeyStore interface {
+	Create(ctx context.Context, key APIKey) error
+	// FindByHash returns the key with the given secret hash, revoked or not.
+	FindByHash(ctx context.Context, hash string) (APIKey, error)
+	List(ctx context.Context) ([]APIKey, error)
+	// Revoke marks an active key as revoked at the given time. Keys that do
+	// not exist or are already revoked return ErrNotFound.
+	Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (APIKey, error)
+}
+
+type MongoAPIKeyStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoAPIKeyStore(coll *mongo.Collection) *MongoAPIKeyStore {
+	return &MongoAPIKeyStore{coll: coll}
+}
+
+// EnsureIndexes creates the unique index key lookups rely on.
+func (s *MongoAPIKeyStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (s *MongoAPIKeyStore) Create(ctx context.Context, key APIKey) error {
+	_, err := s.coll.InsertOne(ctx, key)
+	return err
+}
+
+func (s *MongoAPIKeyStore) FindByHash(ctx context.Context, hash string) (APIKey, error) {
+	var key APIKey
+	err := s.coll.FindOne(ctx, bson.M{"hash": hash}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return APIKey{}, ErrNotFound
+	}
+	return key, err
+}
+
+func (s *MongoAPIKeyStore) List(ctx context.Context) ([]APIKey, error) {
+	cursor, err := s.coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	keys := []APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *MongoAPIKeyStore) Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (APIKey, error) {
+	var key APIKey
+	err := s.coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": at}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return APIKey{}, ErrNotFound
+	}
+	return key, err
+}
+
+// MemoryAPIKeyStore keeps API keys in process memory and is safe for
+// concurrent use.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys []APIKey
+}
+
+func NewMemoryAPIKeyStore(seed ...APIKey) *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: append([]APIKey(nil), seed...)}
+}
+
+func (s *MemoryAPIKeyStore) Create(ctx context.Context, key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.keys {
+		if existing.Hash == key.Hash {
+			return errors.New("duplicate API key hash")
+		}
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) FindByHash(ctx context.Context, hash string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrNotFound
+}
+
+func (s *MemoryAPIKeyStore) List(ctx context.Context) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]APIKey{}, s.keys...), nil
+}
+
+func (s *MemoryAPIKeyStore) Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, key := range s.keys {
+		if key.ID == id && key.RevokedAt == nil {
+			s.keys[i].RevokedAt = &at
+			return s.keys[i], nil
+		}
+	}
+	return APIKey{}, ErrNotFound
+}
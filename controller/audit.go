@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Verbs of audited mutations.
+const (
+	VerbCreate  = "create"
+	VerbUpdate  = "update"
+	VerbPatch   = "patch"
+	VerbDelete  = "delete"
+	VerbRestore = "restore"
+	// VerbRestoreRevision puts a course back as it was in a revision.
+	VerbRestoreRevision = "restore_revision"
+	VerbImport          = "import"
+	VerbMerge           = "merge"
+	VerbEnrich          = "enrich"
+	VerbMark            = "mark"
+	VerbUnmark          = "unmark"
+	VerbAddEntry        = "add_entry"
+	VerbRemoveEntry     = "remove_entry"
+	VerbMoveEntry       = "move_entry"
+	VerbRevoke          = "revoke"
+)
+
+// FieldChange is one field a mutation changed, named as it is stored.
+// Fields of embedded documents are named with dots, as in author.fullname;
+// arrays are compared whole. Before is absent for a field the mutation
+// added, and After for one it removed.
+type FieldChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After  interface{} `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// storedFields flattens doc, as the stores would write it, into a map from
+// dotted field names to values. A nil doc, or nil pointer, has no fields.
+func storedFields(doc interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if v := reflect.ValueOf(doc); !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return fields, nil
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var d primitive.D
+	if err := bson.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+	flattenFields("", d, fields)
+	return fields, nil
+}
+
+func flattenFields(prefix string, d primitive.D, fields map[string]interface{}) {
+	for _, e := range d {
+		if sub, ok := e.Value.(primitive.D); ok && len(sub) > 0 {
+			flattenFields(prefix+e.Key+".", sub, fields)
+			continue
+		}
+		fields[prefix+e.Key] = plainValue(e.Value)
+	}
+}
+
+// plainValue converts decoded BSON into values that encode the same way
+// as JSON and BSON.
+func plainValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case primitive.D:
+		m := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			m[e.Key] = plainValue(e.Value)
+		}
+		return m
+	case primitive.A:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			values[i] = plainValue(value)
+		}
+		return values
+	case primitive.DateTime:
+		return v.Time().UTC()
+	}
+	return v
+}
+
+// diffFields returns the fields that differ between before and after,
+// sorted by name. Either may be nil.
+func diffFields(before, after interface{}) ([]FieldChange, error) {
+	old, err := storedFields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := storedFields(after)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(old)+len(updated))
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range updated {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	changes := []FieldChange{}
+	for _, name := range names {
+		if !reflect.DeepEqual(old[name], updated[name]) {
+			changes = append(changes, FieldChange{Field: name, Before: old[name], After: updated[name]})
+		}
+	}
+	return changes, nil
+}
+
+// requestActor names the caller of r, or "anonymous".
+func requestActor(r *http.Request) string {
+	principal, _ := PrincipalFromContext(r.Context())
+	if principal.Subject == "" {
+		return "anonymous"
+	}
+	return principal.Subject
+}
+
+// record completes event with the caller, route and request ID of r and
+// writes it to the audit trail. A failure to record it is logged, not
+// returned.
+func (c *Controller) record(r *http.Request, event AuditEvent) AuditEvent {
+	principal, _ := PrincipalFromContext(r.Context())
+	event.Actor = requestActor(r)
+	event.Route = r.URL.Path
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			event.Route = tmpl
+		}
+	}
+	event.ID = primitive.NewObjectID()
+	event.Time = time.Now().UTC()
+	event.Role = principal.Role
+	event.Method = r.Method
+	event.Path = r.URL.Path
+	event.RequestID = RequestIDFromContext(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if err := c.Audit.Record(ctx, event); err != nil {
+		log.Printf("failed to record audit event: %v", err)
+	}
+	return event
+}
+
+// audit records an action taken in r that is not a change to one resource.
+func (c *Controller) audit(r *http.Request, action, detail string) AuditEvent {
+	return c.record(r, AuditEvent{Action: action, Detail: detail})
+}
+
+// auditChange records a mutation of one resource with the fields it
+// changed. before is nil for a create and after is nil for a delete.
+func (c *Controller) auditChange(r *http.Request, resource, verb, id string, before, after interface{}) {
+	changes, err := diffFields(before, after)
+	if err != nil {
+		log.Printf("Failed to diff %s %s for the audit trail: %v", resource, id, err)
+	}
+	c.record(r, AuditEvent{
+		Action:     resource + "." + verb,
+		Resource:   resource,
+		ResourceID: id,
+		Changes:    changes,
+	})
+}
+
+// auditResource records a mutation of a resource, or of many when id is
+// empty, that is described rather than diffed.
+func (c *Controller) auditResource(r *http.Request, resource, verb, id, detail string) {
+	c.record(r, AuditEvent{
+		Action:     resource + "." + verb,
+		Resource:   resource,
+		ResourceID: id,
+		Detail:     detail,
+	})
+}
+
+var auditSortFields = map[string]bool{"time": true}
+
+// GetAuditLog lists the audit trail, newest first unless ?sort= says
+// otherwise. ?resource=, ?resourceId=, ?actor=, ?action= and ?requestId=
+// filter on those fields, and ?since= and ?until= on the time.
+func (c *Controller) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	opts, err := parseListOptions(q, auditSortFields)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	if usesCursor(q) {
+		writeError(w, r, invalidQuery(fmt.Errorf("the audit log is paged with offset, not cursor")))
+		return
+	}
+	if len(opts.Sort) == 0 {
+		opts.Sort = []SortField{{Field: "time", Desc: true}, {Field: "_id", Desc: true}}
+	}
+	filter := AuditFilter{
+		Resource:   q.Get("resource"),
+		ResourceID: q.Get("resourceId"),
+		Actor:      q.Get("actor"),
+		Action:     q.Get("action"),
+		RequestID:  q.Get("requestId"),
+	}
+	if filter.Since, err = parseTimeParam(q, "since"); err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	if filter.Until, err = parseTimeParam(q, "until"); err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	if filter.Since != nil && filter.Until != nil && !filter.Until.After(*filter.Since) {
+		writeError(w, r, invalidQuery(fmt.Errorf("until must be after since")))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	events, total, err := c.Audit.List(ctx, filter, opts)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch audit log", err))
+		return
+	}
+	json.NewEncoder(w).Encode(listResponse(r, "Audit log retrieved successfully", events, total, opts))
+}
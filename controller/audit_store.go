@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Audit actions.
+const (
+	AuditAccessDenied = "access.denied"
+	// Confirmation tokens for bulk actions are issued with a preview, then
+	// used to run the action or rejected as unknown, expired, already used
+	// or issued for another action or caller.
+	AuditConfirmationIssued   = "confirmation.issued"
+	AuditConfirmationUsed     = "confirmation.used"
+	AuditConfirmationRejected = "confirmation.rejected"
+	// Confirmed bulk actions record their outcome under their own name.
+	AuditMoviesDeletedAll = "movie.delete_all"
+)
+
+// Audited resources. Mutations are recorded with the action
+// <resource>.<verb>, e.g. course.update.
+const (
+	ResourceCourse   = "course"
+	ResourceMovie    = "movie"
+	ResourceWatch    = "watch"
+	ResourceReview   = "review"
+	ResourcePlaylist = "playlist"
+	ResourceAPIKey   = "apikey"
+)
+
+// AuditEvent records a security-relevant action taken by or against a
+// caller. Route is the route template, e.g. /api/course/{id}.
+type AuditEvent struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	Time      time.Time          `json:"time" bson:"time"`
+	Action    string             `json:"action" bson:"action"`
+	Actor     string             `json:"actor" bson:"actor"`
+	Role      Role               `json:"role,omitempty" bson:"role,omitempty"`
+	Method    string             `json:"method" bson:"method"`
+	Route     string             `json:"route" bson:"route"`
+	Path      string             `json:"path" bson:"path"`
+	RequestID string             `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	Detail    string             `json:"detail,omitempty" bson:"detail,omitempty"`
+	// Resource and ResourceID name what a mutation changed, and Changes
+	// lists the fields it changed.
+	Resource   string        `json:"resource,omitempty" bson:"resource,omitempty"`
+	ResourceID string        `json:"resourceId,omitempty" bson:"resourceId,omitempty"`
+	Changes    []FieldChange `json:"changes,omitempty" bson:"changes,omitempty"`
+}
+
+// AuditFilter selects audit events. Zero fields mean no constraint; Since
+// is inclusive and Until exclusive.
+type AuditFilter struct {
+	Resource   string
+	ResourceID string
+	Actor      string
+	Action     string
+	RequestID  string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+func (f AuditFilter) matches(event AuditEvent) bool {
+	return (f.Resource == "" || event.Resource == f.Resource) &&
+		(f.ResourceID == "" || event.ResourceID == f.ResourceID) &&
+		(f.Actor == "" || event.Actor == f.Actor) &&
+		(f.Action == "" || event.Action == f.Action) &&
+		(f.RequestID == "" || event.RequestID == f.RequestID) &&
+		(f.Since == nil || !event.Time.Before(*f.Since)) &&
+		(f.Until == nil || event.Time.Before(*f.Until))
+}
+
+// AuditStore is the persistence boundary for the audit trail. Events are
+// append-only.
+type AuditStore interface {
+	Record(ctx context.Context, event AuditEvent) error
+	// List returns one page of the events matching filter and the number
+	// that match.
+	List(ctx context.Context, filter AuditFilter, opts ListOptions) ([]AuditEvent, int64, error)
+}
+
+type MongoAuditStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoAuditStore(coll *mongo.Collection) *MongoAuditStore {
+	return &MongoAuditStore{coll: coll}
+}
+
+// EnsureIndexes creates the indexes behind the audit log's filters.
+func (s *MongoAuditStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "resource", Value: 1}, {Key: "resourceId", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "time", Value: -1}}},
+	})
+	return err
+}
+
+func (s *MongoAuditStore) Record(ctx context.Context, event AuditEvent) error {
+	_, err := s.coll.InsertOne(ctx, event)
+	return err
+}
+
+func auditFilterDoc(f AuditFilter) bson.M {
+	doc := bson.M{}
+	for field, value := range map[string]string{
+		"resource":   f.Resource,
+		"resourceId": f.ResourceID,
+		"actor":      f.Actor,
+		"action":     f.Action,
+		"requestId":  f.RequestID,
+	} {
+		if value != "" {
+			doc[field] = value
+		}
+	}
+	if f.Since != nil || f.Until != nil {
+		window := bson.M{}
+		if f.Since != nil {
+			window["$gte"] = *f.Since
+		}
+		if f.Until != nil {
+			window["$lt"] = *f.Until
+		}
+		doc["time"] = window
+	}
+	return doc
+}
+
+func (s *MongoAuditStore) List(ctx context.Context, filter AuditFilter, opts ListOptions) ([]AuditEvent, int64, error) {
+	doc := auditFilterDoc(filter)
+	total, err := s.coll.CountDocuments(ctx, doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.coll.Find(ctx, pageFilter(doc, opts), findOptions(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+	events := []AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// MemoryAuditStore keeps audit events in process memory and is safe for
+// concurrent use.
+type MemoryAuditStore struct {
+	mu     sync.RWMutex
+	events []AuditEvent
+}
+
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{}
+}
+
+func (s *MemoryAuditStore) Record(ctx context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func auditField(event AuditEvent, name string) interface{} {
+	switch name {
+	case "_id":
+		return event.ID
+	case "time":
+		return event.Time
+	}
+	return nil
+}
+
+func (s *MemoryAuditStore) List(ctx context.Context, filter AuditFilter, opts ListOptions) ([]AuditEvent, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := []AuditEvent{}
+	for _, event := range s.events {
+		if filter.matches(event) {
+			events = append(events, event)
+		}
+	}
+	total := int64(len(events))
+	return sortAndPage(events, opts, auditField), total, nil
+}
@@ -0,0 +1,130 @@
+package controller_test
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+type auditPage struct {
+	Data  []controller.AuditEvent
+	Total int
+}
+
+// changeNames lists changes as field:before->after, with _ for a missing
+// value. The _id, which is random, is left out.
+func changeNames(changes []controller.FieldChange) []string {
+	names := []string{}
+	for _, change := range changes {
+		if change.Field != "_id" {
+			names = append(names, change.Field+":"+changeValue(change.Before)+"->"+changeValue(change.After))
+		}
+	}
+	return names
+}
+
+func changeValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "_"
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprint(v)
+}
+
+func TestAuditTrailOfCourseChanges(t *testing.T) {
+	h := newTestAPI(memoryStores())
+	patch := []string{"Content-Type", "application/merge-patch+json"}
+	steps := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		header    []string
+		requestID string
+		action    string
+		changes   []string
+	}{
+		{name: "create", method: "POST", path: "/api/course", body: `{"courseid":"go-101","coursename":"Go","price":10,"author":{"fullname":"Ann"}}`,
+			requestID: "req-create", action: "course.create",
+			changes: []string{`author.fullname:_->"Ann"`, `author.website:_->""`, `courseid:_->"go-101"`, `coursename:_->"Go"`, "price:_->10", "version:_->1"}},
+		{name: "replace", method: "PUT", path: "/api/course/go-101", body: `{"coursename":"Go","price":20,"author":{"fullname":"Ann"}}`,
+			requestID: "req-replace", action: "course.update",
+			changes: []string{"price:10->20", "version:1->2"}},
+		{name: "patch a nested field", method: "PATCH", path: "/api/course/go-101", body: `{"author":{"website":"https://ann.example"}}`, header: patch,
+			requestID: "req-patch", action: "course.patch",
+			changes: []string{`author.website:""->"https://ann.example"`, "version:2->3"}},
+		{name: "delete", method: "DELETE", path: "/api/course/go-101",
+			requestID: "req-delete", action: "course.delete"},
+	}
+	for _, step := range steps {
+		header := append([]string{controller.RequestIDHeader, step.requestID}, step.header...)
+		rec := request(h, step.method, step.path, step.body, header...)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", step.name, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get(controller.RequestIDHeader); got != step.requestID {
+			t.Errorf("%s: %s %q, want %q", step.name, controller.RequestIDHeader, got, step.requestID)
+		}
+		var page auditPage
+		decode(t, request(h, "GET", "/api/audit?requestId="+step.requestID, ""), &page)
+		if len(page.Data) != 1 {
+			t.Fatalf("%s: %d audit events for the request, want 1", step.name, len(page.Data))
+		}
+		event := page.Data[0]
+		if event.Action != step.action || event.Actor != "bootstrap" || event.Method != step.method || event.Path != step.path || event.ResourceID != "go-101" {
+			t.Errorf("%s: event %+v", step.name, event)
+		}
+		if step.changes == nil {
+			continue
+		}
+		if got := changeNames(event.Changes); !reflect.DeepEqual(got, step.changes) {
+			t.Errorf("%s: changes %v, want %v", step.name, got, step.changes)
+		}
+	}
+}
+
+func TestGetAuditLogFilters(t *testing.T) {
+	h := newTestAPI(memoryStores())
+	var viewer struct{ Data struct{ Key string } }
+	decode(t, request(h, "POST", "/api/key", `{"name":"viewer","subject":"vic","role":"viewer"}`), &viewer)
+	request(h, "POST", "/api/course", courseBody)
+	request(h, "POST", "/api/movie", `{"movie":"Heat"}`)
+	request(h, "DELETE", "/api/course/go-101", "", "X-API-Key", viewer.Data.Key)
+
+	tests := []struct {
+		query   string
+		actions []string
+	}{
+		{"?sort=time", []string{"apikey.create", "course.create", "movie.create", controller.AuditAccessDenied}},
+		{"?resource=course", []string{"course.create"}},
+		{"?actor=vic", []string{controller.AuditAccessDenied}},
+		{"?action=movie.create", []string{"movie.create"}},
+		{"?since=2000-01-01T00:00:00Z&until=2001-01-01T00:00:00Z", []string{}},
+	}
+	for _, tt := range tests {
+		rec := request(h, "GET", "/api/audit"+tt.query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", tt.query, rec.Code, rec.Body)
+		}
+		var page auditPage
+		decode(t, rec, &page)
+		actions := []string{}
+		for _, event := range page.Data {
+			actions = append(actions, event.Action)
+		}
+		if !reflect.DeepEqual(actions, tt.actions) || page.Total != len(tt.actions) {
+			t.Errorf("GET %s: %v of %d, want %v", tt.query, actions, page.Total, tt.actions)
+		}
+	}
+
+	for _, query := range []string{"?since=yesterday", "?since=2024-01-02T00:00:00Z&until=2024-01-01T00:00:00Z", "?cursor=", "?sort=actor"} {
+		if p := decodeProblem(t, request(h, "GET", "/api/audit"+query, ""), http.StatusBadRequest); p.Code != controller.CodeInvalidQuery {
+			t.Errorf("GET %s: code %q, want %q", query, p.Code, controller.CodeInvalidQuery)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Authentication methods recorded on a Principal.
+const (
+	AuthMethodAPIKey    = "api_key"
+	AuthMethodJWT       = "jwt"
+	AuthMethodBootstrap = "bootstrap"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject string `json:"subject"`
+	Name    string `json:"name,omitempty"`
+	Method  string `json:"method"`
+	Role    Role   `json:"role"`
+	// KeyID is the _id of the API key used, for API key callers.
+	KeyID string `json:"keyId,omitempty"`
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal Authenticate attached to the
+// request context. It reports false for anonymous requests.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// HashAPIKey returns the hex SHA-256 digest stored in place of a key. Keys
+// are long random secrets, so a fast unsalted hash is sufficient.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func unauthorized(message string) *Error {
+	return &Error{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: message}
+}
+
+// Authenticate is middleware that identifies the caller from an API key
+// (X-API-Key, or Authorization: Bearer) or a JWT bearer token and attaches
+// the principal to the request context. Requests without credentials are
+// rejected unless AnonymousReads allows them.
+func (c *Controller) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := c.authenticate(r)
+		if err == nil && principal == nil && !(c.AnonymousReads && (r.Method == http.MethodGet || r.Method == http.MethodHead)) {
+			err = unauthorized("Authentication required: send an API key or a bearer token")
+		}
+		if err != nil {
+			var apiErr *Error
+			if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			}
+			writeError(w, r, err)
+			return
+		}
+		if principal != nil {
+			r = r.WithContext(WithPrincipal(r.Context(), *principal))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the request's principal, nil when it carries no
+// credentials, or an error when the credentials are invalid.
+func (c *Controller) authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return c.authenticateKey(r.Context(), key)
+	}
+	authz := r.Header.Get("Authorization")
+	if authz == "" {
+		return nil, nil
+	}
+	scheme, credentials, _ := strings.Cut(authz, " ")
+	credentials = strings.TrimSpace(credentials)
+	if !strings.EqualFold(scheme, "Bearer") || credentials == "" {
+		return nil, unauthorized("Unsupported Authorization header: use Bearer")
+	}
+	if strings.Count(credentials, ".") != 2 {
+		return c.authenticateKey(r.Context(), credentials)
+	}
+	if c.JWT == nil {
+		return nil, unauthorized("Bearer tokens are not accepted by this server")
+	}
+	principal, err := c.JWT.Verify(credentials, time.Now())
+	if err != nil {
+		return nil, unauthorized("Invalid bearer token: " + err.Error())
+	}
+	return &principal, nil
+}
+
+func (c *Controller) authenticateKey(ctx context.Context, key string) (*Principal, error) {
+	hash := HashAPIKey(key)
+	if c.BootstrapKeyHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(c.BootstrapKeyHash)) == 1 {
+		return &Principal{Subject: "bootstrap", Method: AuthMethodBootstrap, Role: RoleAdmin}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	stored, err := c.APIKeys.FindByHash(ctx, hash)
+	if errors.Is(err, ErrNotFound) {
+		return nil, unauthorized("Invalid API key")
+	}
+	if err != nil {
+		return nil, internalError("Failed to look up API key", err)
+	}
+	if stored.RevokedAt != nil {
+		return nil, unauthorized("API key has been revoked")
+	}
+	role := stored.Role
+	if !role.Valid() {
+		role = RoleViewer
+	}
+	return &Principal{Subject: stored.Subject, Name: stored.Name, Method: AuthMethodAPIKey, Role: role, KeyID: stored.ID.Hex()}, nil
+}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	revokedAt := time.Now().UTC().Add(-time.Hour)
+	keys := NewMemoryAPIKeyStore(
+		APIKey{ID: testID(1), Name: "ci", Subject: "ci-bot", Role: RoleEditor, Hash: HashAPIKey("active-key")},
+		APIKey{ID: testID(2), Name: "old", Subject: "ci-bot", Role: RoleEditor, Hash: HashAPIKey("revoked-key"), RevokedAt: &revokedAt},
+		APIKey{ID: testID(3), Name: "odd", Subject: "odd", Role: "root", Hash: HashAPIKey("odd-role-key")},
+	)
+	opts := Options{JWT: &JWTVerifier{HMACSecret: testHMACSecret}, BootstrapKeyHash: HashAPIKey("bootstrap-key")}
+	withJWT := New(Stores{APIKeys: keys}, opts)
+	opts.JWT = nil
+	withoutJWT := New(Stores{APIKeys: keys}, opts)
+
+	now := float64(time.Now().Unix())
+	token := signToken(t, "HS256", map[string]interface{}{"sub": "ann", "exp": now + 3600, "role": "admin"})
+	expired := signToken(t, "HS256", map[string]interface{}{"sub": "ann", "exp": now - 3600})
+	bootstrap := &Principal{Subject: "bootstrap", Method: AuthMethodBootstrap, Role: RoleAdmin}
+	ci := &Principal{Subject: "ci-bot", Name: "ci", Method: AuthMethodAPIKey, Role: RoleEditor, KeyID: testID(1).Hex()}
+
+	tests := []struct {
+		name   string
+		c      *Controller
+		header []string
+		want   *Principal
+		err    string
+	}{
+		{name: "no credentials", c: withJWT},
+		{name: "bootstrap key", c: withJWT, header: []string{"X-API-Key", "bootstrap-key"}, want: bootstrap},
+		{name: "bootstrap key as a bearer", c: withJWT, header: []string{"Authorization", "Bearer bootstrap-key"}, want: bootstrap},
+		{name: "bootstrap key unset", c: New(Stores{APIKeys: keys}, Options{}), header: []string{"X-API-Key", "bootstrap-key"}, err: "Invalid API key"},
+		{name: "API key", c: withJWT, header: []string{"X-API-Key", "active-key"}, want: ci},
+		{name: "API key as a bearer", c: withJWT, header: []string{"Authorization", "bearer active-key"}, want: ci},
+		{name: "X-API-Key wins over Authorization", c: withJWT, header: []string{"X-API-Key", "active-key", "Authorization", "Bearer " + token}, want: ci},
+		{name: "revoked API key", c: withJWT, header: []string{"X-API-Key", "revoked-key"}, err: "API key has been revoked"},
+		{name: "unknown API key", c: withJWT, header: []string{"X-API-Key", "nope"}, err: "Invalid API key"},
+		{name: "API key with an unknown role", c: withJWT, header: []string{"X-API-Key", "odd-role-key"},
+			want: &Principal{Subject: "odd", Name: "odd", Method: AuthMethodAPIKey, Role: RoleViewer, KeyID: testID(3).Hex()}},
+		{name: "JWT", c: withJWT, header: []string{"Authorization", "Bearer " + token}, want: &Principal{Subject: "ann", Method: AuthMethodJWT, Role: RoleAdmin}},
+		{name: "expired JWT", c: withJWT, header: []string{"Authorization", "Bearer " + expired}, err: "Invalid bearer token: token has expired"},
+		{name: "JWT not configured", c: withoutJWT, header: []string{"Authorization", "Bearer " + token}, err: "Bearer tokens are not accepted by this server"},
+		{name: "basic auth", c: withJWT, header: []string{"Authorization", "Basic dXNlcjpwYXNz"}, err: "Unsupported Authorization header: use Bearer"},
+		{name: "empty bearer", c: withJWT, header: []string{"Authorization", "Bearer "}, err: "Unsupported Authorization header: use Bearer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/courses", nil)
+			for i := 0; i+1 < len(tt.header); i += 2 {
+				req.Header.Set(tt.header[i], tt.header[i+1])
+			}
+			got, err := tt.c.authenticate(req)
+			if tt.err != "" {
+				var apiErr *Error
+				if !errors.As(err, &apiErr) || apiErr.Code != CodeUnauthorized || apiErr.Message != tt.err {
+					t.Fatalf("error %v, want unauthorized %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("principal %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,619 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	formatCSV    = "csv"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+const (
+	maxImportBytes  = 64 << 20
+	importBatchSize = 500
+)
+
+// csvColumn maps one CSV column to a field of T. Set is nil for columns that
+// are exported but ignored on import, such as _id.
+type csvColumn[T any] struct {
+	Name string
+	Get  func(T) string
+	Set  func(*T, string) error
+}
+
+var courseCSVColumns = []csvColumn[Course]{
+	{Name: "_id", Get: func(c Course) string { return c.ID.Hex() }},
+	{Name: "courseid", Get: func(c Course) string { return c.CourseId }, Set: func(c *Course, v string) error { c.CourseId = v; return nil }},
+	{Name: "coursename", Get: func(c Course) string { return c.CourseName }, Set: func(c *Course, v string) error { c.CourseName = v; return nil }},
+	{Name: "price", Get: func(c Course) string { return strconv.Itoa(c.Price) }, Set: func(c *Course, v string) error {
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		c.Price = n
+		return nil
+	}},
+	{Name: "author.fullname", Get: func(c Course) string { return courseAuthor(c).Fullname }, Set: func(c *Course, v string) error {
+		if v != "" {
+			ensureAuthor(c).Fullname = v
+		}
+		return nil
+	}},
+	{Name: "author.website", Get: func(c Course) string { return courseAuthor(c).Website }, Set: func(c *Course, v string) error {
+		if v != "" {
+			ensureAuthor(c).Website = v
+		}
+		return nil
+	}},
+}
+
+func courseAuthor(c Course) Author {
+	if c.Author == nil {
+		return Author{}
+	}
+	return *c.Author
+}
+
+func ensureAuthor(c *Course) *Author {
+	if c.Author == nil {
+		c.Author = &Author{}
+	}
+	return c.Author
+}
+
+var movieCSVColumns = []csvColumn[Movie]{
+	{Name: "_id", Get: func(m Movie) string { return m.ID.Hex() }},
+	{Name: "movie", Get: func(m Movie) string { return m.Movie }, Set: func(m *Movie, v string) error { m.Movie = v; return nil }},
+	{Name: "year", Get: func(m Movie) string { return formatOptionalInt(m.Year) }, Set: func(m *Movie, v string) error { return parseOptionalInt(v, &m.Year) }},
+	{Name: "genres", Get: func(m Movie) string { return strings.Join(m.Genres, csvListSeparator) }, Set: func(m *Movie, v string) error { m.Genres = splitCSVList(v); return nil }},
+	{Name: "runtime", Get: func(m Movie) string { return formatOptionalInt(m.Runtime) }, Set: func(m *Movie, v string) error { return parseOptionalInt(v, &m.Runtime) }},
+	{Name: "director", Get: func(m Movie) string { return m.Director }, Set: func(m *Movie, v string) error { m.Director = v; return nil }},
+	{Name: "cast", Get: func(m Movie) string { return strings.Join(m.Cast, csvListSeparator) }, Set: func(m *Movie, v string) error { m.Cast = splitCSVList(v); return nil }},
+	{Name: "posterUrl", Get: func(m Movie) string { return m.PosterURL }, Set: func(m *Movie, v string) error { m.PosterURL = v; return nil }},
+	// The rating columns are maintained from reviews, and the watch
+	// columns are the exporting caller's own history; both are ignored on
+	// import.
+	{Name: "avgRating", Get: func(m Movie) string { return strconv.FormatFloat(m.AvgRating, 'f', -1, 64) }},
+	{Name: "ratingCount", Get: func(m Movie) string { return strconv.Itoa(m.RatingCount) }},
+	{Name: "watched", Get: func(m Movie) string { return strconv.FormatBool(m.Watched) }},
+	{Name: "watchCount", Get: func(m Movie) string { return strconv.Itoa(m.WatchCount) }},
+	{Name: "lastWatchedAt", Get: func(m Movie) string {
+		if m.LastWatchedAt == nil {
+			return ""
+		}
+		return m.LastWatchedAt.Format(time.RFC3339)
+	}},
+}
+
+// csvListSeparator joins list fields such as genres into one CSV cell.
+const csvListSeparator = "|"
+
+func splitCSVList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, csvListSeparator)
+}
+
+// formatOptionalInt leaves unset (zero) numbers blank.
+func formatOptionalInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func parseOptionalInt(v string, dst *int) error {
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("must be an integer")
+	}
+	*dst = n
+	return nil
+}
+
+// importFormat picks the body format from ?format= or the Content-Type.
+func importFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		switch f {
+		case formatCSV, formatJSON, formatNDJSON:
+			return f, nil
+		}
+		return "", badRequest(CodeInvalidQuery, "Invalid query: format must be csv, json or ndjson")
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return formatCSV, nil
+	case "application/json":
+		return formatJSON, nil
+	case ndjsonContentType:
+		return formatNDJSON, nil
+	}
+	return "", &Error{
+		Status:  http.StatusUnsupportedMediaType,
+		Code:    CodeUnsupportedMediaType,
+		Message: "Import requires Content-Type text/csv, application/json or " + ndjsonContentType,
+	}
+}
+
+type rowError struct {
+	Row    int          `json:"row"`
+	Errors []FieldError `json:"errors"`
+}
+
+type importResult struct {
+	Format   string     `json:"format"`
+	DryRun   bool       `json:"dryRun"`
+	Received int        `json:"received"`
+	Valid    int        `json:"valid"`
+	Inserted int        `json:"inserted"`
+	Failed   int        `json:"failed"`
+	Errors   []rowError `json:"errors"`
+}
+
+// importer validates records one at a time and inserts the valid ones in
+// batches, so memory use is bounded by the batch size rather than the body.
+type importer[T any] struct {
+	ctx     context.Context
+	prepare func(*T)
+	insert  func(context.Context, []T) (int, error)
+	// key, when set, names the unique field of T; records repeating a key
+	// earlier in the body, or one taken reports as already stored, are
+	// rejected.
+	key    importKey[T]
+	seen   map[string]int
+	result importResult
+	batch  []pendingRecord[T]
+}
+
+// importKey is the unique field of an imported record.
+type importKey[T any] struct {
+	Field string
+	Get   func(T) string
+	// Taken reports which of the keys are already in use.
+	Taken func(context.Context, []string) (map[string]bool, error)
+}
+
+type pendingRecord[T any] struct {
+	row    int
+	record T
+}
+
+func (im *importer[T]) add(row int, record T, fields []FieldError) error {
+	if im.prepare != nil {
+		im.prepare(&record)
+	}
+	normalize(&record)
+	fields = append(fields, validateStruct(record)...)
+	if len(fields) > 0 {
+		im.reject(row, fields)
+		return nil
+	}
+	if im.key.Get != nil {
+		key := im.key.Get(record)
+		if first, ok := im.seen[key]; ok {
+			im.reject(row, []FieldError{{Field: im.key.Field, Code: "duplicate", Message: fmt.Sprintf("%s %q is already used by row %d", im.key.Field, key, first)}})
+			return nil
+		}
+		im.seen[key] = row
+	}
+	im.result.Received++
+	im.batch = append(im.batch, pendingRecord[T]{row: row, record: record})
+	if len(im.batch) >= importBatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// reject records a row that could not be imported.
+func (im *importer[T]) reject(row int, fields []FieldError) {
+	im.result.Received++
+	im.fail(row, fields)
+}
+
+func (im *importer[T]) fail(row int, fields []FieldError) {
+	im.result.Failed++
+	im.result.Errors = append(im.result.Errors, rowError{Row: row, Errors: fields})
+}
+
+// flush checks the pending records' keys against the store and inserts
+// those still free, unless this is a dry run.
+func (im *importer[T]) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(im.ctx, 30*time.Second)
+	defer cancel()
+	defer func() { im.batch = im.batch[:0] }()
+	var taken map[string]bool
+	if im.key.Taken != nil {
+		keys := make([]string, len(im.batch))
+		for i, p := range im.batch {
+			keys[i] = im.key.Get(p.record)
+		}
+		var err error
+		if taken, err = im.key.Taken(ctx, keys); err != nil {
+			return err
+		}
+	}
+	records := make([]T, 0, len(im.batch))
+	for _, p := range im.batch {
+		if key := im.key.Get; key != nil && taken[key(p.record)] {
+			im.fail(p.row, []FieldError{{Field: im.key.Field, Code: "duplicate", Message: fmt.Sprintf("%s %q is already in use", im.key.Field, key(p.record))}})
+			continue
+		}
+		records = append(records, p.record)
+	}
+	im.result.Valid += len(records)
+	if im.result.DryRun || len(records) == 0 {
+		return nil
+	}
+	n, err := im.insert(ctx, records)
+	im.result.Inserted += n
+	return err
+}
+
+// runImport reads a CSV, JSON array or NDJSON body of T records and inserts
+// every valid one. Invalid records are reported by row number (1-based, not
+// counting a CSV header). With ?dry_run=true nothing is written. Records
+// are written in batches as the body is read, so an import that fails part
+// way reports what it had already inserted and rejected alongside the
+// error. It returns how many records were inserted.
+func runImport[T any](w http.ResponseWriter, r *http.Request, columns []csvColumn[T], prepare func(*T), key importKey[T], insert func(context.Context, []T) (int, error), resource string) int {
+	w.Header().Set("Content-Type", "application/json")
+	format, err := importFormat(r)
+	if err != nil {
+		writeError(w, r, err)
+		return 0
+	}
+	dryRun, err := parseBoolParam(r.URL.Query(), "dry_run")
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return 0
+	}
+	im := &importer[T]{ctx: r.Context(), prepare: prepare, insert: insert, key: key, seen: map[string]int{}}
+	im.result = importResult{Format: format, DryRun: dryRun != nil && *dryRun, Errors: []rowError{}}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	switch format {
+	case formatCSV:
+		err = readCSVRecords(body, columns, im)
+	case formatJSON:
+		err = readJSONArrayRecords(body, im)
+	case formatNDJSON:
+		err = readNDJSONRecords(body, im)
+	}
+	if err == nil {
+		err = im.flush()
+	}
+	// Keys taken in the store are found a batch at a time, after later
+	// rows may already have been rejected.
+	sort.SliceStable(im.result.Errors, func(i, j int) bool { return im.result.Errors[i].Row < im.result.Errors[j].Row })
+	if err != nil {
+		var apiErr *Error
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &apiErr):
+		case errors.As(err, &tooLarge):
+			apiErr = &Error{Status: http.StatusRequestEntityTooLarge, Code: CodeBodyTooLarge, Message: fmt.Sprintf("Import body must not exceed %d bytes", maxImportBytes)}
+		case errors.Is(err, ErrDuplicate):
+			apiErr = &Error{Status: http.StatusConflict, Code: CodeConflict, Message: fmt.Sprintf("Import stopped after inserting %d %s at one whose key is already in use", im.result.Inserted, resource)}
+		default:
+			apiErr = internalError(fmt.Sprintf("Import failed after inserting %d %s", im.result.Inserted, resource), err)
+		}
+		apiErr.Result = im.result
+		writeError(w, r, apiErr)
+		return im.result.Inserted
+	}
+	message := fmt.Sprintf("Imported %d %s", im.result.Inserted, resource)
+	if im.result.DryRun {
+		message = fmt.Sprintf("Dry run: %d of %d %s would be imported", im.result.Valid, im.result.Received, resource)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"data":    im.result,
+	})
+	return im.result.Inserted
+}
+
+func readCSVRecords[T any](body io.Reader, columns []csvColumn[T], im *importer[T]) error {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return badRequest(CodeInvalidImport, "CSV body is empty; a header row is required")
+	}
+	if err != nil {
+		return badRequest(CodeInvalidImport, "Invalid CSV header: "+err.Error())
+	}
+	byName := map[string]csvColumn[T]{}
+	for _, col := range columns {
+		byName[col.Name] = col
+	}
+	var unknown []FieldError
+	for _, name := range header {
+		if _, ok := byName[strings.TrimSpace(name)]; !ok {
+			unknown = append(unknown, FieldError{Field: name, Code: "unknown_field", Message: name + " is not a recognised column"})
+		}
+	}
+	if len(unknown) > 0 {
+		return validationFailed(unknown)
+	}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return badRequest(CodeInvalidImport, fmt.Sprintf("Invalid CSV at row %d: %v", row, parseErr.Err))
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) != len(header) {
+			im.reject(row, []FieldError{{Code: "columns", Message: fmt.Sprintf("row has %d columns; the header has %d", len(record), len(header))}})
+			continue
+		}
+		var value T
+		var fields []FieldError
+		for i, raw := range record {
+			col := byName[strings.TrimSpace(header[i])]
+			if col.Set == nil {
+				continue
+			}
+			if err := col.Set(&value, strings.TrimSpace(raw)); err != nil {
+				fields = append(fields, FieldError{Field: col.Name, Code: "type", Message: col.Name + " " + err.Error()})
+			}
+		}
+		if err := im.add(row, value, fields); err != nil {
+			return err
+		}
+	}
+}
+
+func readJSONArrayRecords[T any](body io.Reader, im *importer[T]) error {
+	dec := json.NewDecoder(body)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return badRequest(CodeInvalidImport, "JSON import body must be an array")
+	}
+	for row := 1; dec.More(); row++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return badRequest(CodeInvalidImport, fmt.Sprintf("Invalid JSON at row %d: %v", row, err))
+		}
+		if err := addRawRecord(row, raw, im); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return badRequest(CodeInvalidImport, "Invalid JSON: "+err.Error())
+	}
+	return nil
+}
+
+// readNDJSONRecords treats every non-blank line as a record, so a malformed
+// line is reported as a row error instead of aborting the import.
+func readNDJSONRecords[T any](body io.Reader, im *importer[T]) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxBodyBytes)
+	row := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		row++
+		if err := addRawRecord(row, line, im); err != nil {
+			return err
+		}
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return badRequest(CodeInvalidImport, fmt.Sprintf("Row %d is longer than %d bytes", row+1, maxBodyBytes))
+	}
+	return scanner.Err()
+}
+
+func addRawRecord[T any](row int, raw []byte, im *importer[T]) error {
+	var value T
+	fields, err := decodeStrict(raw, &value)
+	if err != nil {
+		im.reject(row, []FieldError{{Code: CodeInvalidJSON, Message: "row is not valid JSON"}})
+		return nil
+	}
+	return im.add(row, value, fields)
+}
+
+// exportFormat reads ?format=, defaulting to JSON.
+func exportFormat(r *http.Request) (string, error) {
+	switch f := r.URL.Query().Get("format"); f {
+	case "":
+		return formatJSON, nil
+	case formatCSV, formatJSON, formatNDJSON:
+		return f, nil
+	}
+	return "", badRequest(CodeInvalidQuery, "Invalid query: format must be csv, json or ndjson")
+}
+
+// runExport streams every record produced by stream in the requested
+// format. Like NDJSON listings, failures after the first byte are logged.
+func runExport[T any](w http.ResponseWriter, r *http.Request, format, name string, columns []csvColumn[T], stream func(func(T) error) error) {
+	switch format {
+	case formatNDJSON:
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".ndjson"))
+		streamNDJSON(w, func(emit func(interface{}) error) error {
+			return stream(func(v T) error { return emit(v) })
+		})
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+		cw := csv.NewWriter(w)
+		header := make([]string, len(columns))
+		for i, col := range columns {
+			header[i] = col.Name
+		}
+		err := cw.Write(header)
+		n := 0
+		if err == nil {
+			err = stream(func(v T) error {
+				record := make([]string, len(columns))
+				for i, col := range columns {
+					record[i] = col.Get(v)
+				}
+				n++
+				if n%ndjsonFlushEvery == 0 {
+					cw.Flush()
+				}
+				return cw.Write(record)
+			})
+		}
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+		if err != nil {
+			log.Printf("CSV export of %s stopped after %d rows: %v", name, n, err)
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".json"))
+		bw := bufio.NewWriter(w)
+		bw.WriteString("[")
+		n := 0
+		err := stream(func(v T) error {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				bw.WriteString(",")
+			}
+			n++
+			bw.WriteString("\n")
+			_, err = bw.Write(data)
+			return err
+		})
+		bw.WriteString("\n]\n")
+		if flushErr := bw.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			log.Printf("JSON export of %s stopped after %d documents: %v", name, n, err)
+		}
+	}
+}
+
+// Imports are audited as one event each rather than one per record.
+func (c *Controller) ImportCourses(w http.ResponseWriter, r *http.Request) {
+	inserted := runImport(w, r, courseCSVColumns, func(course *Course) {
+		course.ID = primitive.NewObjectID()
+		course.DeletedAt = nil
+		course.Version = 1
+	}, importKey[Course]{Field: "courseid", Get: func(course Course) string { return course.CourseId }, Taken: c.takenCourseIDs}, c.Courses.InsertMany, "courses")
+	if inserted > 0 {
+		c.auditResource(r, ResourceCourse, VerbImport, "", fmt.Sprintf("imported %d courses", inserted))
+	}
+}
+
+// takenCourseIDs reports which of ids are used by courses outside the
+// trash.
+func (c *Controller) takenCourseIDs(ctx context.Context, ids []string) (map[string]bool, error) {
+	taken := map[string]bool{}
+	err := c.Courses.Stream(ctx, CourseFilter{CourseIDs: ids}, ListOptions{}, func(course Course) error {
+		taken[course.CourseId] = true
+		return nil
+	})
+	return taken, err
+}
+
+func (c *Controller) ImportMovies(w http.ResponseWriter, r *http.Request) {
+	inserted := runImport(w, r, movieCSVColumns, func(movie *Movie) {
+		movie.ID = primitive.NewObjectID()
+		movie.AvgRating, movie.RatingCount = 0, 0
+		movie.Enrichment, movie.DeletedAt = nil, nil
+		movie.Version = 1
+		c.queueEnrichment(movie, time.Now().UTC())
+	}, importKey[Movie]{}, c.Movies.InsertMany, "movies")
+	if inserted > 0 {
+		c.auditResource(r, ResourceMovie, VerbImport, "", fmt.Sprintf("imported %d movies", inserted))
+	}
+	c.notifyEnricher()
+}
+
+func (c *Controller) ExportCourses(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format, err := exportFormat(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	opts, err := parseListOptions(q, courseSortFields)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	filter, err := parseCourseFilter(q)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	runExport(w, r, format, "courses", courseCSVColumns, func(fn func(Course) error) error {
+		return c.Courses.Stream(r.Context(), filter, streamOptions(q, opts), fn)
+	})
+}
+
+func (c *Controller) ExportMovies(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format, err := exportFormat(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	opts, err := parseListOptions(q, movieSortFields)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	wl, err := c.watchlist(r.Context())
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
+		return
+	}
+	filter, err := parseMovieFilter(q, wl)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	runExport(w, r, format, "movies", movieCSVColumns, func(fn func(Movie) error) error {
+		return c.Movies.Stream(r.Context(), filter, streamOptions(q, opts), func(movie Movie) error {
+			wl.apply(&movie)
+			return fn(movie)
+		})
+	})
+}
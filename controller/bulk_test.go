@@ -0,0 +1,136 @@
+package controller_test
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+// importResult is the body of an import response, or the result member of
+// a failed one.
+type importResult struct {
+	DryRun   bool
+	Received int
+	Valid    int
+	Inserted int
+	Failed   int
+	Errors   []struct {
+		Row    int
+		Errors []controller.FieldError
+	}
+}
+
+// rowErrors lists the rows and codes of an import's errors.
+func (r importResult) rowErrors() []string {
+	rows := []string{}
+	for _, e := range r.Errors {
+		for _, f := range e.Errors {
+			rows = append(rows, fmt.Sprintf("%d:%s", e.Row, f.Code))
+		}
+	}
+	return rows
+}
+
+// courseRows returns n CSV course rows numbered from first.
+func courseRows(first, n int) string {
+	var b strings.Builder
+	for i := first; i < first+n; i++ {
+		fmt.Fprintf(&b, "c%d,Course %d,10,Ann\n", i, i)
+	}
+	return b.String()
+}
+
+const courseCSVHeader = "courseid,coursename,price,author.fullname\n"
+
+func TestImportCourses(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		body   string
+		want   importResult
+		errors []string
+		stored int
+	}{
+		{
+			name:   "a row with the wrong number of columns is rejected on its own",
+			body:   courseCSVHeader + "c1,Go,10,Ann\nc2,Rust\nc3,Zig,10,Ann,extra\nc4,C,5,Ann\n",
+			want:   importResult{Received: 4, Valid: 2, Inserted: 2, Failed: 2},
+			errors: []string{"2:columns", "3:columns"},
+			stored: 3,
+		},
+		{
+			name:   "courseids repeated in the file or already stored are rejected",
+			body:   courseCSVHeader + "c1,Go,10,Ann\nc0,Go,10,Ann\nc1,Go again,10,Ann\n",
+			want:   importResult{Received: 3, Valid: 1, Inserted: 1, Failed: 2},
+			errors: []string{"2:duplicate", "3:duplicate"},
+			stored: 2,
+		},
+		{
+			name:   "a dry run reports the same duplicates without writing",
+			query:  "?dry_run=true",
+			body:   courseCSVHeader + "c1,Go,10,Ann\nc0,Go,10,Ann\nc1,Go again,10,Ann\n",
+			want:   importResult{DryRun: true, Received: 3, Valid: 1, Failed: 2},
+			errors: []string{"2:duplicate", "3:duplicate"},
+			stored: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := memoryStores()
+			stores.Courses = controller.NewMemoryCourseStore(controller.Course{ID: fixedID(1), CourseId: "c0", CourseName: "Stored", Author: &controller.Author{Fullname: "Ann"}, Version: 1})
+			h := newTestAPI(stores)
+			rec := request(h, "POST", "/api/courses/import"+tt.query, tt.body, "Content-Type", "text/csv")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			var body struct{ Data importResult }
+			decode(t, rec, &body)
+			errs := body.Data.rowErrors()
+			body.Data.Errors = nil
+			if !reflect.DeepEqual(body.Data, tt.want) || !reflect.DeepEqual(errs, tt.errors) {
+				t.Errorf("result %+v %v, want %+v %v", body.Data, errs, tt.want, tt.errors)
+			}
+			var page struct{ Total int }
+			decode(t, request(h, "GET", "/api/courses", ""), &page)
+			if page.Total != tt.stored {
+				t.Errorf("%d courses stored, want %d", page.Total, tt.stored)
+			}
+		})
+	}
+}
+
+func TestImportFailureReportsProgress(t *testing.T) {
+	// Both bodies hold a full batch of valid rows before the malformed
+	// part, so the batch is written before the import fails.
+	var jsonRows []string
+	for i := 0; i < 500; i++ {
+		jsonRows = append(jsonRows, fmt.Sprintf(`{"courseid":"c%d","coursename":"Go","author":{"fullname":"Ann"}}`, i))
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"CSV", "text/csv", courseCSVHeader + courseRows(0, 500) + "c500,\"unterminated,10,Ann\n"},
+		{"JSON", "application/json", "[" + strings.Join(jsonRows, ",") + `,{"courseid":`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestAPI(memoryStores())
+			rec := request(h, "POST", "/api/courses/import", tt.body, "Content-Type", tt.contentType)
+			p := decodeProblem(t, rec, http.StatusBadRequest)
+			if p.Code != controller.CodeInvalidImport {
+				t.Errorf("code %q, want %q", p.Code, controller.CodeInvalidImport)
+			}
+			var body struct{ Result importResult }
+			decode(t, rec, &body)
+			if body.Result.Inserted != 500 || body.Result.Received != 500 {
+				t.Errorf("result %+v, want 500 received and inserted", body.Result)
+			}
+		})
+	}
+}
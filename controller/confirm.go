@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// ConfirmationHeader carries the token that confirms a bulk action.
+	ConfirmationHeader = "X-Confirm-Token"
+	// confirmationTTL is how long a confirmation token can be used.
+	confirmationTTL = 5 * time.Minute
+	// confirmationSampleSize is how many affected items a preview shows.
+	confirmationSampleSize = 5
+)
+
+// bulkPreview is what a caller is shown before confirming a bulk action.
+type bulkPreview struct {
+	Action    string      `json:"action"`
+	Token     string      `json:"token"`
+	Header    string      `json:"header"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+	Count     int64       `json:"count"`
+	Sample    interface{} `json:"sample"`
+}
+
+// previewFunc counts the items a bulk action would affect and returns a
+// few of them.
+type previewFunc func(ctx context.Context) (int64, interface{}, error)
+
+func newConfirmationToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// confirmBulk guards a bulk-destructive handler and reports whether it may
+// go ahead; when it may not, the response has been written. A request
+// without a token gets a 428 confirmation_required problem carrying a
+// preview and a token, which the same caller confirms by repeating the
+// request with the token in ConfirmationHeader. Every token issued, used
+// or rejected is written to the audit trail.
+func (c *Controller) confirmBulk(w http.ResponseWriter, r *http.Request, action string, preview previewFunc) bool {
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	if token := r.Header.Get(ConfirmationHeader); token != "" {
+		confirmation, err := c.Confirmations.Consume(ctx, HashAPIKey(token), action, subject, now)
+		if errors.Is(err, ErrNotFound) {
+			c.audit(r, AuditConfirmationRejected, action)
+			writeError(w, r, &Error{
+				Status:  http.StatusPreconditionFailed,
+				Code:    CodeConfirmationInvalid,
+				Message: "The confirmation token is unknown, expired or already used; repeat the request without it to get a new one",
+			})
+			return false
+		}
+		if err != nil {
+			writeError(w, r, internalError("Failed to check confirmation token", err))
+			return false
+		}
+		c.audit(r, AuditConfirmationUsed, fmt.Sprintf("%s; previewed %d", action, confirmation.Count))
+		return true
+	}
+	count, sample, err := preview(ctx)
+	if err != nil {
+		writeError(w, r, internalError("Failed to preview "+action, err))
+		return false
+	}
+	token, err := newConfirmationToken()
+	if err != nil {
+		writeError(w, r, internalError("Failed to generate confirmation token", err))
+		return false
+	}
+	confirmation := Confirmation{
+		Hash:      HashAPIKey(token),
+		Action:    action,
+		Subject:   subject,
+		Count:     count,
+		CreatedAt: now,
+		ExpiresAt: now.Add(confirmationTTL),
+	}
+	if err := c.Confirmations.Create(ctx, confirmation); err != nil {
+		writeError(w, r, internalError("Failed to store confirmation token", err))
+		return false
+	}
+	c.audit(r, AuditConfirmationIssued, fmt.Sprintf("%s; %d affected", action, count))
+	writeError(w, r, &Error{
+		Status: http.StatusPreconditionRequired,
+		Code:   CodeConfirmationRequired,
+		Message: fmt.Sprintf("This affects %d items; nothing was changed. Repeat the request with the %s header within %s to go ahead",
+			count, ConfirmationHeader, confirmationTTL),
+		Confirmation: bulkPreview{
+			Action:    action,
+			Token:     token,
+			Header:    ConfirmationHeader,
+			ExpiresAt: confirmation.ExpiresAt,
+			Count:     count,
+			Sample:    sample,
+		},
+	})
+	return false
+}
@@ -0,0 +1,138 @@
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+// auditActions lists the actions in the audit trail, oldest first, that
+// start with one of prefixes.
+func auditActions(t *testing.T, h http.Handler, prefixes ...string) []string {
+	t.Helper()
+	var page struct{ Data []controller.AuditEvent }
+	decode(t, request(h, "GET", "/api/audit?sort=time&limit=100", ""), &page)
+	actions := []string{}
+	for _, event := range page.Data {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(event.Action, prefix) {
+				actions = append(actions, event.Action)
+				break
+			}
+		}
+	}
+	return actions
+}
+
+func TestDeleteAllMoviesConfirmation(t *testing.T) {
+	stores := memoryStores()
+	var movies []controller.Movie
+	for i := 1; i <= 7; i++ {
+		movies = append(movies, controller.Movie{ID: fixedID(i), Movie: fmt.Sprintf("Movie %d", i), Version: 1})
+	}
+	stores.Movies = controller.NewMemoryMovieStore(movies...)
+	h := newTestAPI(stores)
+	var other struct{ Data struct{ Key string } }
+	decode(t, request(h, "POST", "/api/key", `{"name":"bob","subject":"bob","role":"admin"}`), &other)
+
+	rec := request(h, "DELETE", "/api/deleteallmovie", "")
+	decodeProblem(t, rec, http.StatusPreconditionRequired)
+	var issued struct {
+		Code         string
+		Confirmation struct {
+			Action string
+			Token  string
+			Header string
+			Count  int64
+			Sample []controller.Movie
+		}
+	}
+	decode(t, rec, &issued)
+	preview := issued.Confirmation
+	if issued.Code != controller.CodeConfirmationRequired || preview.Token == "" || preview.Header != controller.ConfirmationHeader ||
+		preview.Action != controller.AuditMoviesDeletedAll || preview.Count != 7 || len(preview.Sample) != 5 {
+		t.Fatalf("preview %+v, want a token for 7 movies with 5 shown", issued)
+	}
+
+	steps := []struct {
+		name   string
+		key    string
+		token  string
+		status int
+		left   int
+	}{
+		{"another caller's token", other.Data.Key, preview.Token, http.StatusPreconditionFailed, 7},
+		{"an unknown token", bootstrapKey, "not-a-token", http.StatusPreconditionFailed, 7},
+		{"the token", bootstrapKey, preview.Token, http.StatusOK, 0},
+		{"the token again", bootstrapKey, preview.Token, http.StatusPreconditionFailed, 0},
+	}
+	for _, step := range steps {
+		rec := request(h, "DELETE", "/api/deleteallmovie", "", "X-API-Key", step.key, controller.ConfirmationHeader, step.token)
+		if step.status != http.StatusOK {
+			if p := decodeProblem(t, rec, step.status); p.Code != controller.CodeConfirmationInvalid {
+				t.Errorf("%s: code %q, want %q", step.name, p.Code, controller.CodeConfirmationInvalid)
+			}
+		} else if rec.Code != step.status {
+			t.Fatalf("%s: status %d: %s", step.name, rec.Code, rec.Body)
+		}
+		var page struct{ Total int }
+		decode(t, request(h, "GET", "/api/movies", ""), &page)
+		if page.Total != step.left {
+			t.Errorf("%s: %d movies left, want %d", step.name, page.Total, step.left)
+		}
+	}
+
+	want := []string{
+		controller.AuditConfirmationIssued,
+		controller.AuditConfirmationRejected,
+		controller.AuditConfirmationRejected,
+		controller.AuditConfirmationUsed,
+		controller.AuditMoviesDeletedAll,
+		controller.AuditConfirmationRejected,
+	}
+	if got := auditActions(t, h, "confirmation.", "movie."); !reflect.DeepEqual(got, want) {
+		t.Errorf("audit trail %v, want %v", got, want)
+	}
+}
+
+func TestConfirmationConsume(t *testing.T) {
+	ctx := context.Background()
+	issued := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := controller.NewMemoryConfirmationStore()
+	err := store.Create(ctx, controller.Confirmation{Hash: "hash", Action: "movie.delete_all", Subject: "ann", Count: 7, CreatedAt: issued, ExpiresAt: issued.Add(5 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name    string
+		hash    string
+		action  string
+		subject string
+		now     time.Time
+		ok      bool
+	}{
+		{"unknown token", "other", "movie.delete_all", "ann", issued, false},
+		{"another action", "hash", "course.delete_all", "ann", issued, false},
+		{"another subject", "hash", "movie.delete_all", "bob", issued, false},
+		{"at expiry", "hash", "movie.delete_all", "ann", issued.Add(5 * time.Minute), false},
+		{"before expiry", "hash", "movie.delete_all", "ann", issued.Add(4 * time.Minute), true},
+		{"used", "hash", "movie.delete_all", "ann", issued.Add(4 * time.Minute), false},
+	}
+	for _, step := range steps {
+		got, err := store.Consume(ctx, step.hash, step.action, step.subject, step.now)
+		if step.ok {
+			if err != nil || got.Count != 7 {
+				t.Errorf("%s: %+v, %v, want the confirmation", step.name, got, err)
+			}
+		} else if !errors.Is(err, controller.ErrNotFound) {
+			t.Errorf("%s: error %v, want ErrNotFound", step.name, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Confirmation is an outstanding confirmation token for a bulk action.
+// Only the SHA-256 hash of the token is kept. A token is good for one use,
+// by the caller it was issued to, on the action it was issued for.
+type Confirmation struct {
+	Hash    string `json:"-" bson:"_id"`
+	Action  string `json:"action" bson:"action"`
+	Subject string `json:"subject" bson:"subject"`
+	// Count is how many items the preview said the action would affect.
+	Count     int64     `json:"count" bson:"count"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// ConfirmationStore is the persistence boundary for confirmation tokens.
+type ConfirmationStore interface {
+	Create(ctx context.Context, confirmation Confirmation) error
+	// Consume removes and returns the unexpired confirmation with the given
+	// hash, action and subject. It returns ErrNotFound when there is none,
+	// so each token can be consumed once.
+	Consume(ctx context.Context, hash, action, subject string, now time.Time) (Confirmation, error)
+}
+
+type MongoConfirmationStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoConfirmationStore(coll *mongo.Collection) *MongoConfirmationStore {
+	return &MongoConfirmationStore{coll: coll}
+}
+
+// EnsureIndexes creates a TTL index so MongoDB removes expired tokens.
+func (s *MongoConfirmationStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (s *MongoConfirmationStore) Create(ctx context.Context, confirmation Confirmation) error {
+	_, err := s.coll.InsertOne(ctx, confirmation)
+	return err
+}
+
+func (s *MongoConfirmationStore) Consume(ctx context.Context, hash, action, subject string, now time.Time) (Confirmation, error) {
+	var confirmation Confirmation
+	err := s.coll.FindOneAndDelete(ctx, bson.M{
+		"_id":       hash,
+		"action":    action,
+		"subject":   subject,
+		"expiresAt": bson.M{"$gt": now},
+	}).Decode(&confirmation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Confirmation{}, ErrNotFound
+	}
+	return confirmation, err
+}
+
+// MemoryConfirmationStore keeps confirmation tokens in process memory and
+// is safe for concurrent use. Expired tokens are dropped as new ones are
+// created.
+type MemoryConfirmationStore struct {
+	mu            sync.Mutex
+	confirmations map[string]Confirmation
+}
+
+func NewMemoryConfirmationStore() *MemoryConfirmationStore {
+	return &MemoryConfirmationStore{confirmations: map[string]Confirmation{}}
+}
+
+func (s *MemoryConfirmationStore) Create(ctx context.Context, confirmation Confirmation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, existing := range s.confirmations {
+		if !existing.ExpiresAt.After(confirmation.CreatedAt) {
+			delete(s.confirmations, hash)
+		}
+	}
+	if _, ok := s.confirmations[confirmation.Hash]; ok {
+		return errors.New("duplicate confirmation token")
+	}
+	s.confirmations[confirmation.Hash] = confirmation
+	return nil
+}
+
+func (s *MemoryConfirmationStore) Consume(ctx context.Context, hash, action, subject string, now time.Time) (Confirmation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	confirmation, ok := s.confirmations[hash]
+	if !ok || confirmation.Action != action || confirmation.Subject != subject || !confirmation.ExpiresAt.After(now) {
+		return Confirmation{}, ErrNotFound
+	}
+	delete(s.confirmations, hash)
+	return confirmation, nil
+}
@@ -3,152 +3,261 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var CourseCollection *mongo.Collection
-
 type Course struct {
 	ID         primitive.ObjectID `json:"_id" bson:"_id"`
-	CourseId   string             `json:"courseid" bson:"courseid"`
-	CourseName string             `json:"coursename" bson:"coursename"`
-	Price      int                `json:"price" bson:"price"`
-	Author     *Author            `json:"author" bson:"author"`
+	CourseId   string             `json:"courseid" bson:"courseid" validate:"required,max=64"`
+	CourseName string             `json:"coursename" bson:"coursename" validate:"required,max=200"`
+	Price      int                `json:"price" bson:"price" validate:"min=0,max=1000000"`
+	Author     *Author            `json:"author" bson:"author" validate:"required"`
+	// Version counts the changes made to the course and is its ETag.
+	// Request bodies cannot set it.
+	Version int64 `json:"version" bson:"version"`
+	// DeletedAt is set while the course is in the trash. Request bodies
+	// cannot set it.
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
 }
 
 type Author struct {
-	Fullname string `json:"fullname" bson:"fullname"`
-	Website  string `json:"website" bson:"website"`
+	Fullname string `json:"fullname" bson:"fullname" validate:"required,max=100"`
+	Website  string `json:"website" bson:"website" validate:"url,max=2048"`
 }
 
-func GetAllCourses(w http.ResponseWriter, r *http.Request) {
+func (c *Controller) GetAllCourses(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	var courses []Course
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	q := r.URL.Query()
+	opts, err := parseListOptions(q, courseSortFields)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	filter, err := parseCourseFilter(q)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	if wantsNDJSON(r) {
+		streamNDJSON(w, func(emit func(interface{}) error) error {
+			return c.Courses.Stream(r.Context(), filter, streamOptions(q, opts), func(course Course) error {
+				return emit(course)
+			})
+		})
+		return
+	}
+	keyset := usesCursor(q)
+	fetch := opts
+	if keyset {
+		fetch.Limit++
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
-	cursor, err := CourseCollection.Find(ctx, bson.M{})
+	courses, total, err := c.Courses.List(ctx, filter, fetch)
 	if err != nil {
-		http.Error(w, "Failed to fetch courses: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to fetch courses", err))
 		return
 	}
-	defer cursor.Close(ctx)
-	for cursor.Next(ctx) {
-		var course Course
-		if err := cursor.Decode(&course); err != nil {
-			http.Error(w, "Failed to decode course: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		courses = append(courses, course)
+	if keyset {
+		page, next := cursorPage(courses, opts.Limit, func(course Course) primitive.ObjectID { return course.ID })
+		json.NewEncoder(w).Encode(cursorResponse(r, "Courses retrieved successfully", page, total, opts, next))
+		return
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Courses retrieved successfully",
-		"data":    courses,
-	})
+	json.NewEncoder(w).Encode(listResponse(r, "Courses retrieved successfully", courses, total, opts))
 }
 
-func GetOneCourse(w http.ResponseWriter, r *http.Request) {
+func (c *Controller) GetOneCourse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 	id := vars["id"]
-	var course Course
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	err := CourseCollection.FindOne(ctx, bson.M{"courseid": id}).Decode(&course)
+	course, err := c.Courses.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Course not found"))
+		return
+	}
 	if err != nil {
-		http.Error(w, "Course not found: "+err.Error(), http.StatusNotFound)
+		writeError(w, r, internalError("Failed to fetch course", err))
 		return
 	}
+	setETag(w, course.Version)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Course retrieved successfully",
 		"data":    course,
 	})
 }
 
-func CreateCourse(w http.ResponseWriter, r *http.Request) {
+func (c *Controller) CreateCourse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var course Course
-	if err := json.NewDecoder(r.Body).Decode(&course); err != nil {
-		http.Error(w, "Error decoding JSON: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-	if course.CourseId == "" || course.CourseName == "" || course.Author == nil || course.Author.Fullname == "" {
-		http.Error(w, "Missing required fields: courseid, coursename, or author.fullname", http.StatusBadRequest)
+	if err := bindJSON(w, r, &course, nil); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	course.ID = primitive.NewObjectID()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	course.DeletedAt = nil
+	course.Version = 1
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	_, err := CourseCollection.InsertOne(ctx, course)
+	err := c.Courses.Create(ctx, course)
+	if errors.Is(err, ErrDuplicate) {
+		writeError(w, r, &Error{Status: http.StatusConflict, Code: CodeConflict, Message: "Another course already uses this courseid"})
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to create course: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to create course", err))
 		return
 	}
+	c.reviseCourse(r, nil, course, RevisionCreate, 0)
+	c.auditChange(r, ResourceCourse, VerbCreate, course.CourseId, nil, course)
+	setETag(w, course.Version)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Course created successfully",
 		"data":    course,
 	})
 }
 
-func UpdateCourse(w http.ResponseWriter, r *http.Request) {
+func (c *Controller) UpdateCourse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 	id := vars["id"]
 	var course Course
-	if err := json.NewDecoder(r.Body).Decode(&course); err != nil {
-		http.Error(w, "Error decoding JSON: "+err.Error(), http.StatusBadRequest)
+	if err := bindJSON(w, r, &course, func() { course.CourseId = id }); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	if course.CourseName == "" || course.Author == nil || course.Author.Fullname == "" {
-		http.Error(w, "Missing required fields: coursename or author.fullname", http.StatusBadRequest)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	existingCourse, err := c.Courses.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Course not found"))
 		return
 	}
-	course.CourseId = id
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	var existingCourse Course
-	err := CourseCollection.FindOne(ctx, bson.M{"courseid": id}).Decode(&existingCourse)
 	if err != nil {
-		http.Error(w, "Course not found: "+err.Error(), http.StatusNotFound)
+		writeError(w, r, internalError("Failed to fetch course", err))
+		return
+	}
+	if err := c.checkIfMatch(w, r, existingCourse.Version); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	course.ID = existingCourse.ID // Preserve existing _id
-	result, err := CourseCollection.ReplaceOne(ctx, bson.M{"courseid": id}, course)
+	course.DeletedAt = nil
+	course.Version = existingCourse.Version
+	course, err = c.Courses.Replace(ctx, id, course)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Course not found"))
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeError(w, r, versionConflict(r))
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to update course: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to update course", err))
 		return
 	}
-	if result.MatchedCount == 0 {
-		http.Error(w, "Course not found", http.StatusNotFound)
+	c.reviseCourse(r, &existingCourse, course, RevisionUpdate, 0)
+	c.auditChange(r, ResourceCourse, VerbUpdate, id, existingCourse, course)
+	setETag(w, course.Version)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Course updated successfully",
+		"data":    course,
+	})
+}
+
+func (c *Controller) PatchCourse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	id := vars["id"]
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	current, err := c.Courses.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Course not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch course", err))
+		return
+	}
+	if err := c.checkIfMatch(w, r, current.Version); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	var patched Course
+	update, err := bindPatch(w, r, current, &patched, "_id", "courseid", "deletedAt", "version")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	course := current
+	if !update.IsEmpty() {
+		update.Version = &current.Version
+		course, err = c.Courses.Patch(ctx, id, update)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, r, notFound("Course not found"))
+			return
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			writeError(w, r, versionConflict(r))
+			return
+		}
+		if err != nil {
+			writeError(w, r, internalError("Failed to update course", err))
+			return
+		}
+		c.reviseCourse(r, &current, course, RevisionPatch, 0)
+	}
+	c.auditChange(r, ResourceCourse, VerbPatch, id, current, course)
+	setETag(w, course.Version)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Course updated successfully",
 		"data":    course,
 	})
 }
 
-func DeleteCourse(w http.ResponseWriter, r *http.Request) {
+// DeleteCourse moves the course to the trash.
+func (c *Controller) DeleteCourse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 	id := vars["id"]
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	result, err := CourseCollection.DeleteOne(ctx, bson.M{"courseid": id})
+	course, err := c.Courses.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Course not found"))
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to delete course: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to fetch course", err))
 		return
 	}
-	if result.DeletedCount == 0 {
-		http.Error(w, "Course not found", http.StatusNotFound)
+	if err := c.checkIfMatch(w, r, course.Version); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	err = c.Courses.Delete(ctx, id, time.Now().UTC(), &course.Version)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Course not found"))
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeError(w, r, versionConflict(r))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to delete course", err))
+		return
+	}
+	c.auditChange(r, ResourceCourse, VerbDelete, id, course, nil)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Course deleted successfully",
+		"message": "Course moved to the trash",
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,107 @@
+package controller_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+const courseBody = `{"courseid":"go-101","coursename":"Go","price":10,"author":{"fullname":"Ann","website":"https://ann.example"}}`
+
+func TestCourseCRUD(t *testing.T) {
+	h := newTestAPI(memoryStores())
+	steps := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		header  []string
+		status  int
+		want    *controller.Course
+		problem string
+	}{
+		{name: "create", method: "POST", path: "/api/course", body: courseBody, status: http.StatusOK,
+			want: &controller.Course{CourseId: "go-101", CourseName: "Go", Price: 10, Version: 1}},
+		{name: "create again", method: "POST", path: "/api/course", body: courseBody, status: http.StatusConflict, problem: controller.CodeConflict},
+		{name: "get", method: "GET", path: "/api/course/go-101", status: http.StatusOK,
+			want: &controller.Course{CourseId: "go-101", CourseName: "Go", Price: 10, Version: 1}},
+		{name: "replace", method: "PUT", path: "/api/course/go-101", status: http.StatusOK,
+			body: `{"coursename":"Go in depth","price":20,"author":{"fullname":"Ann","website":"https://ann.example"}}`,
+			want: &controller.Course{CourseId: "go-101", CourseName: "Go in depth", Price: 20, Version: 2}},
+		{name: "delete", method: "DELETE", path: "/api/course/go-101", status: http.StatusOK},
+		{name: "get deleted", method: "GET", path: "/api/course/go-101", status: http.StatusNotFound, problem: controller.CodeNotFound},
+		{name: "replace deleted", method: "PUT", path: "/api/course/go-101", body: courseBody, status: http.StatusNotFound, problem: controller.CodeNotFound},
+		{name: "delete deleted", method: "DELETE", path: "/api/course/go-101", status: http.StatusNotFound, problem: controller.CodeNotFound},
+	}
+	for _, step := range steps {
+		rec := request(h, step.method, step.path, step.body, step.header...)
+		if step.problem != "" {
+			if p := decodeProblem(t, rec, step.status); p.Code != step.problem {
+				t.Errorf("%s: code %q, want %q", step.name, p.Code, step.problem)
+			}
+			continue
+		}
+		if rec.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		if step.want == nil {
+			continue
+		}
+		var body struct{ Data controller.Course }
+		decode(t, rec, &body)
+		got := body.Data
+		if got.ID.IsZero() || got.Author == nil || got.Author.Fullname != "Ann" {
+			t.Errorf("%s: course %+v has no _id or author", step.name, got)
+		}
+		got.ID, got.Author = step.want.ID, nil
+		if !reflect.DeepEqual(got, *step.want) {
+			t.Errorf("%s: course %+v, want %+v", step.name, got, *step.want)
+		}
+	}
+}
+
+func TestPatchCourse(t *testing.T) {
+	stores := memoryStores()
+	stores.Courses = controller.NewMemoryCourseStore(controller.Course{ID: fixedID(1), CourseId: "go-101", CourseName: "Go", Price: 10, Author: &controller.Author{Fullname: "Ann"}, Version: 1})
+	h := newTestAPI(stores)
+	merge := []string{"Content-Type", "application/merge-patch+json"}
+	jsonPatch := []string{"Content-Type", "application/json-patch+json"}
+	steps := []struct {
+		name    string
+		path    string
+		body    string
+		header  []string
+		status  int
+		want    *controller.Course
+		problem string
+	}{
+		{name: "merge patch of a nested field", path: "/api/course/go-101", body: `{"author":{"website":"https://ann.example"}}`, header: merge, status: http.StatusOK,
+			want: &controller.Course{CourseId: "go-101", CourseName: "Go", Price: 10, Author: &controller.Author{Fullname: "Ann", Website: "https://ann.example"}, Version: 2}},
+		{name: "JSON patch", path: "/api/course/go-101", body: `[{"op":"replace","path":"/price","value":30}]`, header: jsonPatch, status: http.StatusOK,
+			want: &controller.Course{CourseId: "go-101", CourseName: "Go", Price: 30, Author: &controller.Author{Fullname: "Ann", Website: "https://ann.example"}, Version: 3}},
+		{name: "the courseid", path: "/api/course/go-101", body: `{"courseid":"go-102"}`, header: merge, status: http.StatusBadRequest, problem: controller.CodeValidationFailed},
+		{name: "an invalid result", path: "/api/course/go-101", body: `{"price":-1}`, header: merge, status: http.StatusBadRequest, problem: controller.CodeValidationFailed},
+		{name: "an unknown op", path: "/api/course/go-101", body: `[{"op":"swap","path":"/price"}]`, header: jsonPatch, status: http.StatusUnprocessableEntity, problem: controller.CodeInvalidPatch},
+		{name: "a missing course", path: "/api/course/go-102", body: `{"price":20}`, header: merge, status: http.StatusNotFound, problem: controller.CodeNotFound},
+	}
+	for _, step := range steps {
+		rec := request(h, "PATCH", step.path, step.body, step.header...)
+		if step.problem != "" {
+			if p := decodeProblem(t, rec, step.status); p.Code != step.problem {
+				t.Errorf("%s: code %q, want %q", step.name, p.Code, step.problem)
+			}
+			continue
+		}
+		if rec.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		var body struct{ Data controller.Course }
+		decode(t, rec, &body)
+		step.want.ID = fixedID(1)
+		if !reflect.DeepEqual(body.Data, *step.want) {
+			t.Errorf("%s: course %+v, want %+v", step.name, body.Data, *step.want)
+		}
+	}
+}
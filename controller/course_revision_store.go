@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Revision actions.
+const (
+	RevisionCreate = "create"
+	RevisionUpdate = "update"
+	RevisionPatch  = "patch"
+	// RevisionOriginal keeps a course as it was before its first recorded
+	// change, when it was imported or created before revisions were kept.
+	RevisionOriginal = "original"
+	RevisionRestore  = "restore"
+)
+
+// CourseRevision is an immutable snapshot of a course. Revisions of a
+// course are numbered from 1 in the order they were made; restoring one
+// adds a new revision rather than removing those after it.
+type CourseRevision struct {
+	ID primitive.ObjectID `json:"_id" bson:"_id"`
+	// CourseRef is the course's _id, which unlike its courseid is never
+	// reused by another course.
+	CourseRef primitive.ObjectID `json:"-" bson:"course"`
+	Number    int                `json:"number" bson:"number"`
+	Action    string             `json:"action" bson:"action"`
+	// RestoredFrom is the revision a restore brought back.
+	RestoredFrom int       `json:"restoredFrom,omitempty" bson:"restoredFrom,omitempty"`
+	Actor        string    `json:"actor" bson:"actor"`
+	RequestID    string    `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"`
+	Course       Course    `json:"course" bson:"snapshot"`
+}
+
+// CourseRevisionStore is the persistence boundary for course revisions.
+// Revisions are append-only.
+type CourseRevisionStore interface {
+	// Append numbers rev after the course's latest revision, stores it and
+	// returns it.
+	Append(ctx context.Context, rev CourseRevision) (CourseRevision, error)
+	// AppendFirst stores rev as revision 1 unless the course already has
+	// revisions, and reports whether it did.
+	AppendFirst(ctx context.Context, rev CourseRevision) (bool, error)
+	// List returns one page of a course's revisions and how many it has.
+	List(ctx context.Context, course primitive.ObjectID, opts ListOptions) ([]CourseRevision, int64, error)
+	// Get returns revision n of a course, or ErrNotFound.
+	Get(ctx context.Context, course primitive.ObjectID, n int) (CourseRevision, error)
+	// DeleteCourse removes every revision of a purged course.
+	DeleteCourse(ctx context.Context, course primitive.ObjectID) error
+}
+
+type MongoCourseRevisionStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoCourseRevisionStore(coll *mongo.Collection) *MongoCourseRevisionStore {
+	return &MongoCourseRevisionStore{coll: coll}
+}
+
+// EnsureIndexes creates the unique index that numbers each course's
+// revisions.
+func (s *MongoCourseRevisionStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "course", Value: 1}, {Key: "number", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// Append takes the number after the latest revision's. When a concurrent
+// append takes it first the unique index rejects the insert and the next
+// number is tried.
+func (s *MongoCourseRevisionStore) Append(ctx context.Context, rev CourseRevision) (CourseRevision, error) {
+	for attempt := 0; attempt < maxEditAttempts; attempt++ {
+		var latest CourseRevision
+		err := s.coll.FindOne(ctx, bson.M{"course": rev.CourseRef},
+			options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}}).SetProjection(bson.M{"number": 1}),
+		).Decode(&latest)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return CourseRevision{}, err
+		}
+		rev.Number = latest.Number + 1
+		_, err = s.coll.InsertOne(ctx, rev)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return CourseRevision{}, err
+		}
+		return rev, nil
+	}
+	return CourseRevision{}, ErrEditConflict
+}
+
+// AppendFirst relies on the unique index: when revision 1 exists, whether
+// or not it was stored by a concurrent request, the insert is rejected.
+func (s *MongoCourseRevisionStore) AppendFirst(ctx context.Context, rev CourseRevision) (bool, error) {
+	rev.Number = 1
+	_, err := s.coll.InsertOne(ctx, rev)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *MongoCourseRevisionStore) List(ctx context.Context, course primitive.ObjectID, opts ListOptions) ([]CourseRevision, int64, error) {
+	doc := bson.M{"course": course}
+	total, err := s.coll.CountDocuments(ctx, doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.coll.Find(ctx, pageFilter(doc, opts), findOptions(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+	revs := []CourseRevision{}
+	if err := cursor.All(ctx, &revs); err != nil {
+		return nil, 0, err
+	}
+	return revs, total, nil
+}
+
+func (s *MongoCourseRevisionStore) Get(ctx context.Context, course primitive.ObjectID, n int) (CourseRevision, error) {
+	var rev CourseRevision
+	err := s.coll.FindOne(ctx, bson.M{"course": course, "number": n}).Decode(&rev)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return CourseRevision{}, ErrNotFound
+	}
+	return rev, err
+}
+
+func (s *MongoCourseRevisionStore) DeleteCourse(ctx context.Context, course primitive.ObjectID) error {
+	_, err := s.coll.DeleteMany(ctx, bson.M{"course": course})
+	return err
+}
+
+// MemoryCourseRevisionStore keeps course revisions in process memory and
+// is safe for concurrent use.
+type MemoryCourseRevisionStore struct {
+	mu   sync.RWMutex
+	revs []CourseRevision
+}
+
+func NewMemoryCourseRevisionStore() *MemoryCourseRevisionStore {
+	return &MemoryCourseRevisionStore{}
+}
+
+func (s *MemoryCourseRevisionStore) Append(ctx context.Context, rev CourseRevision) (CourseRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rev.Number = 1
+	for _, existing := range s.revs {
+		if existing.CourseRef == rev.CourseRef && existing.Number >= rev.Number {
+			rev.Number = existing.Number + 1
+		}
+	}
+	rev.Course = copyCourse(rev.Course)
+	s.revs = append(s.revs, rev)
+	return rev, nil
+}
+
+func (s *MemoryCourseRevisionStore) AppendFirst(ctx context.Context, rev CourseRevision) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.revs {
+		if existing.CourseRef == rev.CourseRef {
+			return false, nil
+		}
+	}
+	rev.Number = 1
+	rev.Course = copyCourse(rev.Course)
+	s.revs = append(s.revs, rev)
+	return true, nil
+}
+
+func courseRevisionField(rev CourseRevision, name string) interface{} {
+	switch name {
+	case "_id":
+		return rev.ID
+	case "number":
+		return rev.Number
+	case "createdAt":
+		return rev.CreatedAt
+	}
+	return nil
+}
+
+func (s *MemoryCourseRevisionStore) List(ctx context.Context, course primitive.ObjectID, opts ListOptions) ([]CourseRevision, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revs := []CourseRevision{}
+	for _, rev := range s.revs {
+		if rev.CourseRef == course {
+			rev.Course = copyCourse(rev.Course)
+			revs = append(revs, rev)
+		}
+	}
+	total := int64(len(revs))
+	return sortAndPage(revs, opts, courseRevisionField), total, nil
+}
+
+func (s *MemoryCourseRevisionStore) Get(ctx context.Context, course primitive.ObjectID, n int) (CourseRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rev := range s.revs {
+		if rev.CourseRef == course && rev.Number == n {
+			rev.Course = copyCourse(rev.Course)
+			return rev, nil
+		}
+	}
+	return CourseRevision{}, ErrNotFound
+}
+
+func (s *MemoryCourseRevisionStore) DeleteCourse(ctx context.Context, course primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.revs[:0]
+	for _, rev := range s.revs {
+		if rev.CourseRef != course {
+			kept = append(kept, rev)
+		}
+	}
+	s.revs = kept
+	return nil
+}
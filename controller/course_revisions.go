@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var courseRevisionSortFields = map[string]bool{"number": true, "createdAt": true}
+
+// reviseCourse stores after as the course's next revision. before is the
+// course as it was, or nil for a new course; a course with no revisions yet
+// first gets one keeping before, so its first recorded change can be
+// diffed and undone. A failure is logged, not returned, since the change
+// has already been made.
+func (c *Controller) reviseCourse(r *http.Request, before *Course, after Course, action string, restoredFrom int) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	rev := CourseRevision{
+		CourseRef:    after.ID,
+		Action:       action,
+		RestoredFrom: restoredFrom,
+		Actor:        requestActor(r),
+		RequestID:    RequestIDFromContext(r.Context()),
+		CreatedAt:    time.Now().UTC(),
+	}
+	if before != nil {
+		_, total, err := c.CourseRevisions.List(ctx, after.ID, ListOptions{Limit: 1})
+		if err != nil {
+			log.Printf("Failed to fetch revisions of course %s: %v", after.CourseId, err)
+			return
+		}
+		// The count only saves a write once revisions exist. AppendFirst
+		// does nothing if another request stored one meanwhile, so two
+		// first changes cannot both store an original.
+		if total == 0 {
+			original := rev
+			original.ID = primitive.NewObjectID()
+			original.Action = RevisionOriginal
+			original.RestoredFrom = 0
+			original.Course = *before
+			original.Course.DeletedAt = nil
+			if _, err := c.CourseRevisions.AppendFirst(ctx, original); err != nil {
+				log.Printf("Failed to store original revision of course %s: %v", after.CourseId, err)
+				return
+			}
+		}
+	}
+	rev.ID = primitive.NewObjectID()
+	rev.Course = after
+	rev.Course.DeletedAt = nil
+	if _, err := c.CourseRevisions.Append(ctx, rev); err != nil {
+		log.Printf("Failed to store revision of course %s: %v", after.CourseId, err)
+	}
+}
+
+// courseAndRevision fetches the live course in the route and, when the
+// route names one, its revision.
+func (c *Controller) courseAndRevision(ctx context.Context, r *http.Request) (Course, CourseRevision, error) {
+	vars := mux.Vars(r)
+	course, err := c.Courses.Get(ctx, vars["id"])
+	if errors.Is(err, ErrNotFound) {
+		return Course{}, CourseRevision{}, notFound("Course not found")
+	}
+	if err != nil {
+		return Course{}, CourseRevision{}, internalError("Failed to fetch course", err)
+	}
+	if vars["n"] == "" {
+		return course, CourseRevision{}, nil
+	}
+	n, err := strconv.Atoi(vars["n"])
+	if err != nil {
+		return Course{}, CourseRevision{}, badRequest(CodeInvalidID, "Invalid revision number")
+	}
+	rev, err := c.courseRevision(ctx, course, n)
+	return course, rev, err
+}
+
+func (c *Controller) courseRevision(ctx context.Context, course Course, n int) (CourseRevision, error) {
+	rev, err := c.CourseRevisions.Get(ctx, course.ID, n)
+	if errors.Is(err, ErrNotFound) {
+		return CourseRevision{}, notFound(fmt.Sprintf("Revision %d not found", n))
+	}
+	if err != nil {
+		return CourseRevision{}, internalError("Failed to fetch revision", err)
+	}
+	return rev, nil
+}
+
+// GetCourseRevisions lists a course's revisions, newest first unless
+// ?sort= says otherwise.
+func (c *Controller) GetCourseRevisions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	opts, err := parseListOptions(q, courseRevisionSortFields)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	if usesCursor(q) {
+		writeError(w, r, invalidQuery(fmt.Errorf("revisions are paged with offset, not cursor")))
+		return
+	}
+	if len(opts.Sort) == 0 {
+		opts.Sort = []SortField{{Field: "number", Desc: true}}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	course, _, err := c.courseAndRevision(ctx, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	revs, total, err := c.CourseRevisions.List(ctx, course.ID, opts)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch revisions", err))
+		return
+	}
+	json.NewEncoder(w).Encode(listResponse(r, "Revisions retrieved successfully", revs, total, opts))
+}
+
+func (c *Controller) GetCourseRevision(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	_, rev, err := c.courseAndRevision(ctx, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Revision retrieved successfully",
+		"data":    rev,
+	})
+}
+
+// DiffCourseRevisions returns the fields that differ between revisions
+// ?from= and ?to= of a course. Either may be the older one.
+func (c *Controller) DiffCourseRevisions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	numbers := make(map[string]int, 2)
+	for _, name := range []string{"from", "to"} {
+		n, err := parseIntParam(q, name)
+		if err == nil && n == nil {
+			err = fmt.Errorf("%s is required", name)
+		}
+		if err != nil {
+			writeError(w, r, invalidQuery(err))
+			return
+		}
+		numbers[name] = *n
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	course, _, err := c.courseAndRevision(ctx, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	from, err := c.courseRevision(ctx, course, numbers["from"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	to, err := c.courseRevision(ctx, course, numbers["to"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	changes, err := diffFields(from.Course, to.Course)
+	if err != nil {
+		writeError(w, r, internalError("Failed to compare revisions", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Revisions compared successfully",
+		"data": map[string]interface{}{
+			"from":    from.Number,
+			"to":      to.Number,
+			"changes": changes,
+		},
+	})
+}
+
+// RestoreCourseRevision puts a course back as it was in one of its
+// revisions. The restore is itself a new revision, so the revisions after
+// the one restored are kept.
+func (c *Controller) RestoreCourseRevision(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	current, rev, err := c.courseAndRevision(ctx, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := c.checkIfMatch(w, r, current.Version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	course := rev.Course
+	course.ID = current.ID
+	course.CourseId = current.CourseId
+	course.DeletedAt = nil
+	course.Version = current.Version
+	course, err = c.Courses.Replace(ctx, current.CourseId, course)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Course not found"))
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeError(w, r, versionConflict(r))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to restore revision", err))
+		return
+	}
+	c.reviseCourse(r, &current, course, RevisionRestore, rev.Number)
+	c.auditChange(r, ResourceCourse, VerbRestoreRevision, current.CourseId, current, course)
+	setETag(w, course.Version)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("Course restored to revision %d", rev.Number),
+		"data":    course,
+	})
+}
@@ -0,0 +1,171 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type revisionPage struct {
+	Data  []controller.CourseRevision
+	Total int
+}
+
+// revisionSummaries lists revisions as number:action:price.
+func revisionSummaries(revs []controller.CourseRevision) []string {
+	out := []string{}
+	for _, rev := range revs {
+		out = append(out, fmt.Sprintf("%d:%s:%d", rev.Number, rev.Action, rev.Course.Price))
+	}
+	return out
+}
+
+func TestCourseRevisions(t *testing.T) {
+	h := newTestAPI(memoryStores())
+	patch := []string{"Content-Type", "application/merge-patch+json"}
+	for _, step := range []struct {
+		method, path, body string
+		header             []string
+	}{
+		{"POST", "/api/course", courseBody, nil},
+		{"PUT", "/api/course/go-101", `{"coursename":"Go","price":20,"author":{"fullname":"Ann"}}`, nil},
+		{"PATCH", "/api/course/go-101", `{"price":30}`, patch},
+	} {
+		if rec := request(h, step.method, step.path, step.body, step.header...); rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d: %s", step.method, step.path, rec.Code, rec.Body)
+		}
+	}
+
+	var page revisionPage
+	decode(t, request(h, "GET", "/api/course/go-101/revisions", ""), &page)
+	if got, want := revisionSummaries(page.Data), []string{"3:patch:30", "2:update:20", "1:create:10"}; !reflect.DeepEqual(got, want) || page.Total != 3 {
+		t.Errorf("revisions %v of %d, want %v", got, page.Total, want)
+	}
+
+	var rev struct{ Data controller.CourseRevision }
+	decode(t, request(h, "GET", "/api/course/go-101/revisions/2", ""), &rev)
+	if rev.Data.Number != 2 || rev.Data.Course.Price != 20 || rev.Data.Actor != "bootstrap" || rev.Data.RequestID == "" {
+		t.Errorf("revision 2 %+v", rev.Data)
+	}
+
+	diffs := []struct {
+		query   string
+		changes []string
+	}{
+		{"?from=1&to=3", []string{"author.website:\"https://ann.example\"->\"\"", "price:10->30", "version:1->3"}},
+		{"?from=3&to=1", []string{"author.website:\"\"->\"https://ann.example\"", "price:30->10", "version:3->1"}},
+		{"?from=2&to=2", []string{}},
+	}
+	for _, tt := range diffs {
+		rec := request(h, "GET", "/api/course/go-101/revisions/diff"+tt.query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("diff %s: status %d: %s", tt.query, rec.Code, rec.Body)
+		}
+		var body struct {
+			Data struct{ Changes []controller.FieldChange }
+		}
+		decode(t, rec, &body)
+		if got := changeNames(body.Data.Changes); !reflect.DeepEqual(got, tt.changes) {
+			t.Errorf("diff %s: %v, want %v", tt.query, got, tt.changes)
+		}
+	}
+
+	problems := []struct {
+		method, path string
+		header       []string
+		status       int
+		code         string
+	}{
+		{"GET", "/api/course/go-101/revisions/9", nil, http.StatusNotFound, controller.CodeNotFound},
+		{"GET", "/api/course/go-101/revisions/diff?from=1", nil, http.StatusBadRequest, controller.CodeInvalidQuery},
+		{"GET", "/api/course/go-101/revisions/diff?from=1&to=9", nil, http.StatusNotFound, controller.CodeNotFound},
+		{"GET", "/api/course/go-102/revisions", nil, http.StatusNotFound, controller.CodeNotFound},
+		{"POST", "/api/course/go-101/revisions/1/restore", []string{"If-Match", `"2"`}, http.StatusPreconditionFailed, controller.CodePreconditionFailed},
+	}
+	for _, tt := range problems {
+		if p := decodeProblem(t, request(h, tt.method, tt.path, "", tt.header...), tt.status); p.Code != tt.code {
+			t.Errorf("%s %s: code %q, want %q", tt.method, tt.path, p.Code, tt.code)
+		}
+	}
+
+	rec := request(h, "POST", "/api/course/go-101/revisions/1/restore", "", "If-Match", `"3"`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore: status %d: %s", rec.Code, rec.Body)
+	}
+	var restored struct{ Data controller.Course }
+	decode(t, rec, &restored)
+	if restored.Data.Price != 10 || restored.Data.Version != 4 || rec.Header().Get("ETag") != `"4"` {
+		t.Errorf("restored course %+v, ETag %s", restored.Data, rec.Header().Get("ETag"))
+	}
+	decode(t, request(h, "GET", "/api/course/go-101/revisions?sort=number", ""), &page)
+	if got, want := revisionSummaries(page.Data), []string{"1:create:10", "2:update:20", "3:patch:30", "4:restore:10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("revisions after the restore %v, want %v", got, want)
+	}
+	if last := page.Data[len(page.Data)-1]; last.RestoredFrom != 1 {
+		t.Errorf("restore revision restoredFrom %d, want 1", last.RestoredFrom)
+	}
+}
+
+// pausedRevisions holds the first two requests that count a course's
+// revisions until both have counted, so both see none.
+type pausedRevisions struct {
+	controller.CourseRevisionStore
+	counted chan struct{}
+	both    sync.WaitGroup
+}
+
+func (s *pausedRevisions) List(ctx context.Context, course primitive.ObjectID, opts controller.ListOptions) ([]controller.CourseRevision, int64, error) {
+	revs, total, err := s.CourseRevisionStore.List(ctx, course, opts)
+	if opts.Limit == 1 {
+		s.counted <- struct{}{}
+		s.both.Done()
+		s.both.Wait()
+	}
+	return revs, total, err
+}
+
+func TestCourseOriginalRevisionStoredOnce(t *testing.T) {
+	stores := memoryStores()
+	stores.Courses = controller.NewMemoryCourseStore(controller.Course{ID: fixedID(1), CourseId: "go-101", CourseName: "Go", Price: 10, Author: &controller.Author{Fullname: "Ann"}, Version: 1})
+	revisions := &pausedRevisions{CourseRevisionStore: stores.CourseRevisions, counted: make(chan struct{}, 2)}
+	revisions.both.Add(2)
+	stores.CourseRevisions = revisions
+	h := newTestAPI(stores)
+
+	// A course stored before revisions were kept gets an original revision
+	// on its first change. Here a second change lands while the first is
+	// still recording its revisions.
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"coursename":"Go","price":%d,"author":{"fullname":"Ann"}}`, 20+10*i)
+			codes[i] = request(h, "PUT", "/api/course/go-101", body).Code
+		}(i)
+		<-revisions.counted
+	}
+	wg.Wait()
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK {
+		t.Fatalf("statuses %v, want both updates to succeed", codes)
+	}
+
+	var page revisionPage
+	decode(t, request(h, "GET", "/api/course/go-101/revisions?sort=number", ""), &page)
+	originals := 0
+	for _, rev := range page.Data {
+		if rev.Action == controller.RevisionOriginal {
+			originals++
+		}
+	}
+	if originals != 1 || page.Total != 3 || page.Data[0].Action != controller.RevisionOriginal {
+		t.Errorf("revisions %v, want the original first and one revision per update", revisionSummaries(page.Data))
+	}
+}
@@ -0,0 +1,389 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Near-duplicates are found by comparing duplicate keys: the title
+// lower-cased, without a trailing "(year)", punctuation or a leading
+// article. Two movies look alike when their keys are equal or a typo or two
+// apart, unless both have a year and the years differ, which tells remakes
+// apart.
+const (
+	// titleTypoLength is how many key characters allow one edit; shorter
+	// keys must match exactly.
+	titleTypoLength = 8
+	maxTitleEdits   = 2
+	// maxDuplicateCandidates caps the matches reported when a create is
+	// refused.
+	maxDuplicateCandidates = 5
+	// maxMergedGenres and maxMergedCast are the validation limits on
+	// Movie, which merged metadata must stay within.
+	maxMergedGenres = 10
+	maxMergedCast   = 100
+	// duplicatePrefixLength is how many leading key characters a candidate
+	// duplicate of a movie without a year must share with it.
+	duplicatePrefixLength = 3
+)
+
+var titleYearPattern = regexp.MustCompile(`^(.*\S)\s*\((\d{4})\)$`)
+
+var leadingArticles = []string{"the ", "a ", "an "}
+
+// normalizeTitle collapses runs of whitespace in title and moves a
+// trailing "(2010)" into year, unless year is already set to something
+// else.
+func normalizeTitle(title string, year int) (string, int) {
+	title = strings.Join(strings.Fields(title), " ")
+	if m := titleYearPattern.FindStringSubmatch(title); m != nil {
+		y, _ := strconv.Atoi(m[2])
+		if y >= 1888 && y <= 2100 && (year == 0 || year == y) {
+			return m[1], y
+		}
+	}
+	return title, year
+}
+
+// duplicateKey reduces title to the words that identify it and returns the
+// year given in it, if any.
+func duplicateKey(title string) (string, int) {
+	title, year := normalizeTitle(title, 0)
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	key := strings.Join(strings.Fields(b.String()), " ")
+	for _, article := range leadingArticles {
+		if strings.HasPrefix(key, article) {
+			key = strings.TrimPrefix(key, article)
+			break
+		}
+	}
+	return key, year
+}
+
+// duplicatePrefix is the start of key that candidate duplicates share.
+func duplicatePrefix(key string) string {
+	if r := []rune(key); len(r) > duplicatePrefixLength {
+		return string(r[:duplicatePrefixLength])
+	}
+	return key
+}
+
+// withDuplicateKey sets movie's DuplicateKey from its title.
+func withDuplicateKey(movie Movie) Movie {
+	movie.DuplicateKey, _ = duplicateKey(movie.Movie)
+	return movie
+}
+
+// duplicateKeyUpdate adds the new duplicate key to an update that renames a
+// movie.
+func duplicateKeyUpdate(update Update) Update {
+	title, ok := update.Set["movie"].(string)
+	if !ok {
+		return update
+	}
+	set := make(map[string]interface{}, len(update.Set)+1)
+	for path, v := range update.Set {
+		set[path] = v
+	}
+	set["duplicateKey"], _ = duplicateKey(title)
+	update.Set = set
+	return update
+}
+
+// editDistance is the Levenshtein distance between a and b in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// titleDistance reports how many edits apart the titles of a and b are,
+// and whether that is close enough for them to be the same movie.
+func titleDistance(a, b Movie) (int, bool) {
+	keyA, yearA := duplicateKey(a.Movie)
+	keyB, yearB := duplicateKey(b.Movie)
+	if a.Year != 0 {
+		yearA = a.Year
+	}
+	if b.Year != 0 {
+		yearB = b.Year
+	}
+	if keyA == "" || keyB == "" || (yearA != 0 && yearB != 0 && yearA != yearB) {
+		return 0, false
+	}
+	if keyA == keyB {
+		return 0, true
+	}
+	shorter := len([]rune(keyA))
+	if n := len([]rune(keyB)); n < shorter {
+		shorter = n
+	}
+	allowed := shorter / titleTypoLength
+	if allowed > maxTitleEdits {
+		allowed = maxTitleEdits
+	}
+	if allowed == 0 {
+		return 0, false
+	}
+	edits := editDistance(keyA, keyB)
+	return edits, edits <= allowed
+}
+
+// findDuplicates returns the movies that look like movie, closest first.
+// Only the movies whose duplicate keys start like movie's, or of the same
+// year, are compared, so a typo in the first few characters of a title
+// without a year goes unnoticed.
+func (c *Controller) findDuplicates(ctx context.Context, movie Movie) ([]Movie, error) {
+	type candidate struct {
+		movie Movie
+		edits int
+	}
+	key, year := duplicateKey(movie.Movie)
+	if movie.Year != 0 {
+		year = movie.Year
+	}
+	if key == "" {
+		return nil, nil
+	}
+	var candidates []candidate
+	filter := MovieFilter{DuplicateKey: key, DuplicateYear: year}
+	err := c.Movies.Stream(ctx, filter, ListOptions{Sort: []SortField{{Field: "_id"}}}, func(existing Movie) error {
+		if edits, ok := titleDistance(movie, existing); ok {
+			candidates = append(candidates, candidate{existing, edits})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].edits < candidates[j].edits })
+	if len(candidates) > maxDuplicateCandidates {
+		candidates = candidates[:maxDuplicateCandidates]
+	}
+	movies := make([]Movie, len(candidates))
+	for i, candidate := range candidates {
+		movies[i] = candidate.movie
+	}
+	return movies, nil
+}
+
+// duplicateError refuses a create that looks like the candidates.
+func duplicateError(candidates []Movie) *Error {
+	return &Error{
+		Status:     http.StatusConflict,
+		Code:       CodeDuplicate,
+		Message:    "A movie with a similar title already exists; pass force=true to create it anyway",
+		Candidates: candidates,
+	}
+}
+
+// mergeMetadata fills in the metadata into is missing from sources, taking
+// the first source that has it, and adds their genres and cast.
+func mergeMetadata(into Movie, sources []Movie) Update {
+	update := Update{Set: map[string]interface{}{}}
+	genres, cast := into.Genres, into.Cast
+	for _, source := range sources {
+		if into.Year == 0 && source.Year != 0 {
+			into.Year = source.Year
+			update.Set["year"] = source.Year
+		}
+		if into.Runtime == 0 && source.Runtime != 0 {
+			into.Runtime = source.Runtime
+			update.Set["runtime"] = source.Runtime
+		}
+		if into.Director == "" && source.Director != "" {
+			into.Director = source.Director
+			update.Set["director"] = source.Director
+		}
+		if into.PosterURL == "" && source.PosterURL != "" {
+			into.PosterURL = source.PosterURL
+			update.Set["posterUrl"] = source.PosterURL
+		}
+		for _, genre := range source.Genres {
+			if len(genres) < maxMergedGenres && !contains(genres, genre) {
+				genres = append(genres, genre)
+			}
+		}
+		for _, name := range source.Cast {
+			if len(cast) < maxMergedCast && !contains(cast, name) {
+				cast = append(cast, name)
+			}
+		}
+	}
+	if len(genres) != len(into.Genres) {
+		update.Set["genres"] = genres
+	}
+	if len(cast) != len(into.Cast) {
+		update.Set["cast"] = cast
+	}
+	return update
+}
+
+type movieMergeRequest struct {
+	// Into is the movie that is kept; the movies listed in From are
+	// merged into it and moved to the trash.
+	Into string   `json:"into" validate:"required"`
+	From []string `json:"from" validate:"required,max=20"`
+}
+
+// MergeMovies folds duplicate movies into one. Every user's watch history,
+// reviews, playlist and queue entries and random picks move to the kept
+// movie, which also takes any metadata it lacks from the others; the
+// others are then moved to the trash. Each step can be repeated, so a merge
+// that fails part way through can be retried with the movies not yet
+// trashed.
+func (c *Controller) MergeMovies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req movieMergeRequest
+	if err := bindJSON(w, r, &req, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	into, err := primitive.ObjectIDFromHex(req.Into)
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	var from []primitive.ObjectID
+	for _, hex := range req.From {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+			return
+		}
+		if id == into || containsID(from, id) {
+			writeError(w, r, validationFailed([]FieldError{{
+				Field:   "from",
+				Code:    "distinct",
+				Message: "from must list distinct movies other than into",
+			}}))
+			return
+		}
+		from = append(from, id)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	target, err := c.Movies.Get(ctx, into)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	sources := make([]Movie, 0, len(from))
+	for _, id := range from {
+		source, err := c.Movies.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, r, notFound(fmt.Sprintf("Movie %s not found", id.Hex())))
+			return
+		}
+		if err != nil {
+			writeError(w, r, internalError("Failed to fetch movie", err))
+			return
+		}
+		sources = append(sources, source)
+	}
+	// Metadata is copied before anything is deleted, so it survives a
+	// merge that fails part way through.
+	if update := mergeMetadata(target, sources); !update.IsEmpty() {
+		if _, err := c.Movies.Patch(ctx, into, update); err != nil {
+			writeError(w, r, internalError("Failed to merge movie metadata", err))
+			return
+		}
+	}
+	now := time.Now().UTC()
+	for _, source := range sources {
+		if err := c.mergeMovie(ctx, source.ID, into, now); err != nil {
+			writeError(w, r, internalError("Failed to merge movie "+source.ID.Hex(), err))
+			return
+		}
+		c.auditResource(r, ResourceMovie, VerbDelete, source.ID.Hex(), "merged into "+into.Hex())
+	}
+	merged, err := c.Movies.Get(ctx, into)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	c.auditChange(r, ResourceMovie, VerbMerge, into.Hex(), target, merged)
+	if merged, err = c.withWatched(ctx, merged); err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Movies merged successfully",
+		"data":    merged,
+		"merged":  from,
+	})
+}
+
+// mergeMovie moves everything that refers to from onto into and moves from
+// to the trash. Both ratings are recomputed from the reviews, even when
+// only some of them could be moved, so from goes to the trash unrated.
+func (c *Controller) mergeMovie(ctx context.Context, from, into primitive.ObjectID, now time.Time) error {
+	if err := c.Watches.MergeMovie(ctx, from, into); err != nil {
+		return fmt.Errorf("watch history: %w", err)
+	}
+	moved, err := c.Reviews.MergeMovie(ctx, from, into)
+	if len(moved) > 0 {
+		if _, err := c.refreshRating(ctx, into); err != nil {
+			return fmt.Errorf("rating: %w", err)
+		}
+	}
+	if _, err := c.refreshRating(ctx, from); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("rating: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("reviews: %w", err)
+	}
+	if err := c.Playlists.MergeMovie(ctx, from, into, now); err != nil {
+		return fmt.Errorf("playlists: %w", err)
+	}
+	if err := c.Picks.MergeMovie(ctx, from, into); err != nil {
+		return fmt.Errorf("picks: %w", err)
+	}
+	if err := c.Movies.Delete(ctx, from, now, nil); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Enrichment statuses. A movie is pending until a worker claims it, then
+// processing; a lookup that fails with a transient error puts it back to
+// pending until MaxAttempts is reached.
+const (
+	EnrichmentPending    = "pending"
+	EnrichmentProcessing = "processing"
+	EnrichmentEnriched   = "enriched"
+	EnrichmentNotFound   = "not_found"
+	EnrichmentFailed     = "failed"
+)
+
+const (
+	// enrichmentLease is how long a claimed movie stays processing before
+	// another worker may take it over, for workers that die mid-lookup.
+	enrichmentLease = 2 * time.Minute
+	// enrichmentTimeout bounds one provider lookup.
+	enrichmentTimeout = 30 * time.Second
+	// maxEnrichmentRetryDelay caps the exponential backoff between attempts.
+	maxEnrichmentRetryDelay = time.Hour
+)
+
+// Enrichment tracks the background lookup of a movie's missing metadata.
+type Enrichment struct {
+	Status   string `json:"status" bson:"status"`
+	Attempts int    `json:"attempts" bson:"attempts"`
+	// Error is the last transient lookup error.
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+	// Filled names the fields the lookup filled in.
+	Filled []string `json:"filled,omitempty" bson:"filled,omitempty"`
+	// NextAttemptAt is when a pending movie becomes due, or when the lease
+	// of a processing one expires.
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty" bson:"nextAttemptAt,omitempty"`
+	UpdatedAt     time.Time  `json:"updatedAt" bson:"updatedAt"`
+}
+
+// pendingEnrichment is the state a movie is queued with.
+func pendingEnrichment(now time.Time) *Enrichment {
+	return &Enrichment{Status: EnrichmentPending, NextAttemptAt: &now, UpdatedAt: now}
+}
+
+// ErrNoMetadata is returned by a MetadataProvider that has no match for a
+// movie. It is final: the lookup is not retried.
+var ErrNoMetadata = errors.New("no metadata found")
+
+// Metadata is what a provider knows about one movie.
+type Metadata struct {
+	Title     string   `json:"title"`
+	Year      int      `json:"year"`
+	Genres    []string `json:"genres"`
+	Runtime   int      `json:"runtime"`
+	Director  string   `json:"director"`
+	Cast      []string `json:"cast"`
+	PosterURL string   `json:"posterUrl"`
+}
+
+// MetadataProvider looks up movie metadata by title. year is the movie's
+// release year when known, and zero otherwise. Errors other than
+// ErrNoMetadata are treated as transient and retried.
+type MetadataProvider interface {
+	Lookup(ctx context.Context, title string, year int) (Metadata, error)
+}
+
+// EnricherOptions tunes an Enricher. Zero values take the defaults.
+type EnricherOptions struct {
+	// Workers is the number of concurrent lookups (default 2).
+	Workers int
+	// MaxAttempts is how many lookups a movie gets before it is marked
+	// failed (default 5).
+	MaxAttempts int
+	// RetryDelay is the wait after the first failed attempt; it doubles
+	// with each further attempt (default 30s).
+	RetryDelay time.Duration
+	// PollInterval is how often idle workers look for retries that have
+	// come due (default 1m).
+	PollInterval time.Duration
+}
+
+// Enricher fills in missing movie metadata in the background. Movies are
+// queued by giving them a pending Enrichment; the queue lives in the movie
+// store, so it survives restarts and can be shared by several servers.
+type Enricher struct {
+	movies   MovieStore
+	provider MetadataProvider
+	opts     EnricherOptions
+	wake     chan struct{}
+	now      func() time.Time
+}
+
+func NewEnricher(movies MovieStore, provider MetadataProvider, opts EnricherOptions) *Enricher {
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 30 * time.Second
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Minute
+	}
+	return &Enricher{
+		movies:   movies,
+		provider: provider,
+		opts:     opts,
+		wake:     make(chan struct{}, 1),
+		now:      func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Notify tells the workers that movies have been queued. It never blocks.
+func (e *Enricher) Notify() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes queued movies until ctx is cancelled.
+func (e *Enricher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < e.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (e *Enricher) work(ctx context.Context) {
+	ticker := time.NewTicker(e.opts.PollInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		if e.processNext(ctx) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-e.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNext claims and enriches one due movie. It reports false when
+// there was nothing to do.
+func (e *Enricher) processNext(ctx context.Context) bool {
+	claimCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	movie, err := e.movies.ClaimEnrichment(claimCtx, e.now(), enrichmentLease)
+	cancel()
+	if errors.Is(err, ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to claim a movie for enrichment: %v", err)
+		}
+		return false
+	}
+	// There may be more work queued; let another worker look for it.
+	e.Notify()
+	e.enrich(ctx, movie)
+	return true
+}
+
+func (e *Enricher) enrich(ctx context.Context, movie Movie) {
+	lookupCtx, cancel := context.WithTimeout(ctx, enrichmentTimeout)
+	meta, err := e.provider.Lookup(lookupCtx, movie.Movie, movie.Year)
+	cancel()
+	if ctx.Err() != nil {
+		// Shutting down; the lease expires and the movie is retried.
+		return
+	}
+	now := e.now()
+	state := Enrichment{Attempts: movie.Enrichment.Attempts, UpdatedAt: now}
+	update := Update{Set: map[string]interface{}{}}
+	switch {
+	case errors.Is(err, ErrNoMetadata):
+		state.Status = EnrichmentNotFound
+	case err != nil:
+		state.Error = err.Error()
+		if state.Attempts >= e.opts.MaxAttempts {
+			state.Status = EnrichmentFailed
+			break
+		}
+		state.Status = EnrichmentPending
+		next := now.Add(e.retryDelay(state.Attempts))
+		state.NextAttemptAt = &next
+	default:
+		// Fill against the latest copy so edits made during the lookup
+		// are not overwritten.
+		current, err := e.movies.Get(ctx, movie.ID)
+		if errors.Is(err, ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to fetch movie %s for enrichment: %v", movie.ID.Hex(), err)
+			return
+		}
+		state.Status = EnrichmentEnriched
+		state.Filled = fillMissing(&current, meta)
+		doc, err := toBSON(current)
+		if err != nil {
+			log.Printf("Failed to encode enriched movie %s: %v", movie.ID.Hex(), err)
+			return
+		}
+		for _, name := range state.Filled {
+			update.Set[name] = doc[name]
+		}
+	}
+	update.Set["enrichment"] = state
+	if _, err := e.movies.Patch(ctx, movie.ID, update); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Failed to save enrichment of movie %s: %v", movie.ID.Hex(), err)
+	}
+}
+
+// retryDelay is the backoff after the given number of failed attempts.
+func (e *Enricher) retryDelay(attempts int) time.Duration {
+	delay := e.opts.RetryDelay
+	for i := 1; i < attempts && delay < maxEnrichmentRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxEnrichmentRetryDelay {
+		delay = maxEnrichmentRetryDelay
+	}
+	return delay
+}
+
+// fillMissing copies the metadata fields movie lacks from meta and returns
+// their names. Values that would not pass validation are skipped.
+func fillMissing(movie *Movie, meta Metadata) []string {
+	candidates := []struct {
+		name    string
+		missing bool
+		set     func(*Movie)
+	}{
+		{"year", movie.Year == 0 && meta.Year != 0, func(m *Movie) { m.Year = meta.Year }},
+		{"genres", len(movie.Genres) == 0 && len(meta.Genres) > 0, func(m *Movie) { m.Genres = append([]string(nil), meta.Genres...) }},
+		{"runtime", movie.Runtime == 0 && meta.Runtime != 0, func(m *Movie) { m.Runtime = meta.Runtime }},
+		{"director", movie.Director == "" && meta.Director != "", func(m *Movie) { m.Director = meta.Director }},
+		{"cast", len(movie.Cast) == 0 && len(meta.Cast) > 0, func(m *Movie) { m.Cast = append([]string(nil), meta.Cast...) }},
+		{"posterUrl", movie.PosterURL == "" && meta.PosterURL != "", func(m *Movie) { m.PosterURL = meta.PosterURL }},
+	}
+	filled := []string{}
+	for _, c := range candidates {
+		if !c.missing {
+			continue
+		}
+		next := *movie
+		c.set(&next)
+		next.normalize()
+		if hasFieldError(validateStruct(next), c.name) {
+			continue
+		}
+		*movie = next
+		filled = append(filled, c.name)
+	}
+	return filled
+}
+
+func hasFieldError(fields []FieldError, name string) bool {
+	for _, f := range fields {
+		if f.Field == name || strings.HasPrefix(f.Field, name+"[") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+const problemContentType = "application/problem+json"
+
+// Error codes are part of the API contract: clients switch on them, so
+// existing values must not change.
+const (
+	CodeInvalidJSON          = "invalid_json"
+	CodeInvalidQuery         = "invalid_query"
+	CodeInvalidID            = "invalid_id"
+	CodeValidationFailed     = "validation_failed"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeInvalidPatch         = "invalid_patch"
+	CodePatchTestFailed      = "patch_test_failed"
+	CodeInvalidImport        = "invalid_import"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodeDuplicate            = "duplicate"
+	CodeConfirmationRequired = "confirmation_required"
+	CodeConfirmationInvalid  = "confirmation_invalid"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeUnavailable          = "unavailable"
+	CodeInternal             = "internal_error"
+)
+
+// Error is the error type every handler in this package reports to
+// clients. Err is the internal cause: it is logged but never sent.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+	// Candidates lists existing resources the request may duplicate.
+	Candidates interface{}
+	// Confirmation previews a bulk action and carries the token that
+	// confirms it.
+	Confirmation interface{}
+	// Result reports what a bulk request did before it failed.
+	Result interface{}
+	Err    error
+}
+
+// FieldError describes one invalid field of a request body or query.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func badRequest(code, message string) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: code, Message: message}
+}
+
+func invalidQuery(err error) *Error {
+	return badRequest(CodeInvalidQuery, "Invalid query: "+err.Error())
+}
+
+func invalidJSON(err error) *Error {
+	return badRequest(CodeInvalidJSON, "Error decoding JSON: "+err.Error())
+}
+
+func validationFailed(fields []FieldError) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeValidationFailed, Message: "Request validation failed", Fields: fields}
+}
+
+func notFound(message string) *Error {
+	return &Error{Status: http.StatusNotFound, Code: CodeNotFound, Message: message}
+}
+
+func internalError(message string, err error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Err: err}
+}
+
+// problem is the application/problem+json body (RFC 7807) with our code and
+// field details as extension members.
+type problem struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Status     int          `json:"status"`
+	Code       string       `json:"code"`
+	Detail     string       `json:"detail"`
+	Instance   string       `json:"instance,omitempty"`
+	Errors     []FieldError `json:"errors,omitempty"`
+	Candidates interface{}  `json:"candidates,omitempty"`
+	// Confirmation is sent with confirmation_required.
+	Confirmation interface{} `json:"confirmation,omitempty"`
+	// Result is sent with a failed import: the rows it had already
+	// inserted and those it rejected.
+	Result interface{} `json:"result,omitempty"`
+}
+
+// writeError renders err as a problem response. Anything that is not an
+// *Error is treated as an internal failure, except ErrNotFound.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		if errors.Is(err, ErrNotFound) {
+			apiErr = notFound("Not found")
+		} else {
+			apiErr = internalError("Internal server error", err)
+		}
+	}
+	if apiErr.Err != nil {
+		log.Printf("%s %s: %d %s: %v", r.Method, r.URL.Path, apiErr.Status, apiErr.Message, apiErr.Err)
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(problem{
+		Type:         "about:blank",
+		Title:        http.StatusText(apiErr.Status),
+		Status:       apiErr.Status,
+		Code:         apiErr.Code,
+		Detail:       apiErr.Message,
+		Instance:     r.URL.Path,
+		Errors:       apiErr.Fields,
+		Candidates:   apiErr.Candidates,
+		Confirmation: apiErr.Confirmation,
+		Result:       apiErr.Result,
+	})
+}
+
+// NotFoundHandler and MethodNotAllowedHandler report routing failures in
+// the same format as handler errors.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, notFound("No route for "+r.URL.Path))
+}
+
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, &Error{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: r.Method + " is not allowed on " + r.URL.Path})
+}
@@ -0,0 +1,119 @@
+package controller_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+// problem is an application/problem+json error body.
+type problem struct {
+	Type     string                  `json:"type"`
+	Title    string                  `json:"title"`
+	Status   int                     `json:"status"`
+	Code     string                  `json:"code"`
+	Detail   string                  `json:"detail"`
+	Instance string                  `json:"instance"`
+	Errors   []controller.FieldError `json:"errors"`
+}
+
+// decodeProblem checks that rec is a problem response with status and
+// returns its body.
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder, status int) problem {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type %q, want application/problem+json", ct)
+	}
+	var p problem
+	decode(t, rec, &p)
+	if p.Type != "about:blank" || p.Title != http.StatusText(status) || p.Status != status {
+		t.Errorf("problem %+v does not describe status %d", p, status)
+	}
+	return p
+}
+
+func TestProblemResponses(t *testing.T) {
+	h := newTestAPI(memoryStores())
+	var created struct{ Data struct{ Key string } }
+	rec := request(h, "POST", "/api/key", `{"name":"viewer","subject":"vic","role":"viewer"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("creating a key: status %d: %s", rec.Code, rec.Body)
+	}
+	decode(t, rec, &created)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header []string
+		want   problem
+		auth   bool
+	}{
+		{
+			name: "no route", method: "GET", path: "/api/nowhere",
+			want: problem{Status: 404, Code: controller.CodeNotFound, Detail: "No route for /api/nowhere"},
+		},
+		{
+			name: "wrong method", method: "DELETE", path: "/api/courses",
+			want: problem{Status: 405, Code: controller.CodeMethodNotAllowed, Detail: "DELETE is not allowed on /api/courses"},
+		},
+		{
+			name: "no credentials", method: "GET", path: "/api/courses", header: []string{"X-API-Key", ""},
+			want: problem{Status: 401, Code: controller.CodeUnauthorized, Detail: "Authentication required: send an API key or a bearer token"},
+			auth: true,
+		},
+		{
+			name: "unsupported scheme", method: "GET", path: "/api/courses", header: []string{"X-API-Key", "", "Authorization", "Basic dXNlcg=="},
+			want: problem{Status: 401, Code: controller.CodeUnauthorized, Detail: "Unsupported Authorization header: use Bearer"},
+			auth: true,
+		},
+		{
+			name: "role too low", method: "DELETE", path: "/api/course/go-101", header: []string{"X-API-Key", created.Data.Key},
+			want: problem{Status: 403, Code: controller.CodeForbidden, Detail: "This action requires the admin role"},
+		},
+		{
+			name: "malformed JSON", method: "POST", path: "/api/course", body: `{"courseid":`,
+			want: problem{Status: 400, Code: controller.CodeInvalidJSON, Detail: "Error decoding JSON: unexpected EOF"},
+		},
+		{
+			name: "body too large", method: "POST", path: "/api/course", body: `{"coursename":"` + strings.Repeat("x", 1<<20) + `"}`,
+			want: problem{Status: 413, Code: controller.CodeBodyTooLarge, Detail: "Request body must not exceed 1048576 bytes"},
+		},
+		{
+			name: "missing resource", method: "GET", path: "/api/course/go-101",
+			want: problem{Status: 404, Code: controller.CodeNotFound, Detail: "Course not found"},
+		},
+		{
+			name: "invalid fields", method: "POST", path: "/api/course", body: `{"courseid":"go-101","price":-1,"author":{"fullname":"Ann"}}`,
+			want: problem{Status: 400, Code: controller.CodeValidationFailed, Detail: "Request validation failed", Errors: []controller.FieldError{
+				{Field: "coursename", Code: "required", Message: "coursename is required"},
+				{Field: "price", Code: "min", Message: "price must be at least 0"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request(h, tt.method, tt.path, tt.body, tt.header...)
+			got := decodeProblem(t, rec, tt.want.Status)
+			want := tt.want
+			want.Type, want.Title, want.Instance = "about:blank", http.StatusText(want.Status), tt.path
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("problem %+v, want %+v", got, want)
+			}
+			if rec.Header().Get(controller.RequestIDHeader) == "" {
+				t.Errorf("no %s header", controller.RequestIDHeader)
+			}
+			if challenge := rec.Header().Get("WWW-Authenticate"); (challenge != "") != tt.auth {
+				t.Errorf("WWW-Authenticate %q", challenge)
+			}
+		})
+	}
+}
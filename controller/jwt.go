@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// jwtLeeway absorbs clock skew between the token issuer and this server.
+const jwtLeeway = time.Minute
+
+// JWTVerifier checks compact JWS bearer tokens signed with HS256 or RS256.
+// Only the algorithms with a configured key are accepted, so a token cannot
+// pick its own verification method.
+type JWTVerifier struct {
+	HMACSecret   []byte
+	RSAPublicKey *rsa.PublicKey
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Name      string      `json:"name"`
+	Role      Role        `json:"role"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+}
+
+// jwtAudience accepts the aud claim as a single string or an array.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = jwtAudience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = many
+	return nil
+}
+
+// Verify checks the signature and registered claims of token and returns
+// the principal it identifies. A token must carry sub and exp; the optional
+// role claim defaults to viewer.
+func (v *JWTVerifier) Verify(token string, now time.Time) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("malformed header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, errors.New("malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && len(v.HMACSecret) > 0:
+		mac := hmac.New(sha256.New, v.HMACSecret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return Principal{}, errors.New("invalid signature")
+		}
+	case header.Alg == "RS256" && v.RSAPublicKey != nil:
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(v.RSAPublicKey, crypto.SHA256, digest[:], signature) != nil {
+			return Principal{}, errors.New("invalid signature")
+		}
+	default:
+		return Principal{}, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, fmt.Errorf("malformed claims: %w", err)
+	}
+	switch {
+	case claims.Subject == "":
+		return Principal{}, errors.New("token has no sub claim")
+	case claims.ExpiresAt == nil:
+		return Principal{}, errors.New("token has no exp claim")
+	case now.After(numericDate(*claims.ExpiresAt).Add(jwtLeeway)):
+		return Principal{}, errors.New("token has expired")
+	case claims.NotBefore != nil && now.Add(jwtLeeway).Before(numericDate(*claims.NotBefore)):
+		return Principal{}, errors.New("token is not valid yet")
+	case v.Issuer != "" && claims.Issuer != v.Issuer:
+		return Principal{}, errors.New("token issuer is not accepted")
+	case v.Audience != "" && !contains(claims.Audience, v.Audience):
+		return Principal{}, errors.New("token audience is not accepted")
+	case claims.Role != "" && !claims.Role.Valid():
+		return Principal{}, fmt.Errorf("token role %q is not recognised", claims.Role)
+	}
+	if claims.Role == "" {
+		claims.Role = RoleViewer
+	}
+	return Principal{Subject: claims.Subject, Name: claims.Name, Method: AuthMethodJWT, Role: claims.Role}, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// numericDate converts a JWT NumericDate (seconds since the epoch, possibly
+// fractional) to a time.
+func numericDate(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testHMACSecret = []byte("hmac-secret-0123456789abcdef")
+
+// testRSAKey is generated once; generating a key per test is slow.
+var testRSAKey = func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// signToken returns a compact JWS of claims with alg in its header, signed
+// with the test key for alg. Any other alg gets an empty signature.
+func signToken(t *testing.T, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(claims)
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, testHMACSecret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, testRSAKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerify(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) float64 { return float64(now.Add(d).Unix()) }
+	// claims returns valid claims for ann with the given ones replaced;
+	// a nil value removes a claim.
+	claims := func(set ...interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "ann", "exp": at(time.Hour)}
+		for i := 0; i+1 < len(set); i += 2 {
+			if set[i+1] == nil {
+				delete(c, set[i].(string))
+			} else {
+				c[set[i].(string)] = set[i+1]
+			}
+		}
+		return c
+	}
+	hmacOnly := &JWTVerifier{HMACSecret: testHMACSecret}
+	rsaOnly := &JWTVerifier{RSAPublicKey: &testRSAKey.PublicKey}
+	scoped := &JWTVerifier{HMACSecret: testHMACSecret, Issuer: "https://issuer.example", Audience: "movies"}
+	viewer := Principal{Subject: "ann", Method: AuthMethodJWT, Role: RoleViewer}
+	// tampered carries bob's claims under the signature of ann's.
+	ann, bob := strings.Split(signToken(t, "HS256", claims()), "."), strings.Split(signToken(t, "HS256", claims("sub", "bob")), ".")
+	tampered := ann[0] + "." + bob[1] + "." + ann[2]
+
+	tests := []struct {
+		name     string
+		verifier *JWTVerifier
+		token    string
+		want     Principal
+		err      string
+	}{
+		{name: "HS256", verifier: hmacOnly, token: signToken(t, "HS256", claims()), want: viewer},
+		{name: "RS256", verifier: rsaOnly, token: signToken(t, "RS256", claims()), want: viewer},
+		{name: "name and role", verifier: hmacOnly, token: signToken(t, "HS256", claims("name", "Ann", "role", "editor")),
+			want: Principal{Subject: "ann", Name: "Ann", Method: AuthMethodJWT, Role: RoleEditor}},
+		{name: "HS256 without an HMAC secret", verifier: rsaOnly, token: signToken(t, "HS256", claims()), err: `unsupported signing algorithm "HS256"`},
+		{name: "RS256 without a public key", verifier: hmacOnly, token: signToken(t, "RS256", claims()), err: `unsupported signing algorithm "RS256"`},
+		{name: "alg none", verifier: hmacOnly, token: signToken(t, "none", claims()), err: `unsupported signing algorithm "none"`},
+		{name: "tampered claims", verifier: hmacOnly, token: tampered, err: "invalid signature"},
+		{name: "RS256 signed by another key", verifier: &JWTVerifier{RSAPublicKey: &rsa.PublicKey{N: testRSAKey.N, E: 3}}, token: signToken(t, "RS256", claims()), err: "invalid signature"},
+		{name: "not three segments", verifier: hmacOnly, token: "a.b", err: "malformed token"},
+		{name: "no sub", verifier: hmacOnly, token: signToken(t, "HS256", claims("sub", nil)), err: "token has no sub claim"},
+		{name: "no exp", verifier: hmacOnly, token: signToken(t, "HS256", claims("exp", nil)), err: "token has no exp claim"},
+		{name: "expired within the leeway", verifier: hmacOnly, token: signToken(t, "HS256", claims("exp", at(-30*time.Second))), want: viewer},
+		{name: "expired beyond the leeway", verifier: hmacOnly, token: signToken(t, "HS256", claims("exp", at(-2*time.Minute))), err: "token has expired"},
+		{name: "not before, within the leeway", verifier: hmacOnly, token: signToken(t, "HS256", claims("nbf", at(30*time.Second))), want: viewer},
+		{name: "not before, beyond the leeway", verifier: hmacOnly, token: signToken(t, "HS256", claims("nbf", at(2*time.Minute))), err: "token is not valid yet"},
+		{name: "issuer and audience", verifier: scoped, token: signToken(t, "HS256", claims("iss", "https://issuer.example", "aud", []string{"books", "movies"})), want: viewer},
+		{name: "audience as a string", verifier: scoped, token: signToken(t, "HS256", claims("iss", "https://issuer.example", "aud", "movies")), want: viewer},
+		{name: "wrong issuer", verifier: scoped, token: signToken(t, "HS256", claims("iss", "https://other.example", "aud", "movies")), err: "token issuer is not accepted"},
+		{name: "no issuer", verifier: scoped, token: signToken(t, "HS256", claims("aud", "movies")), err: "token issuer is not accepted"},
+		{name: "wrong audience", verifier: scoped, token: signToken(t, "HS256", claims("iss", "https://issuer.example", "aud", "books")), err: "token audience is not accepted"},
+		{name: "malformed audience", verifier: scoped, token: signToken(t, "HS256", claims("iss", "https://issuer.example", "aud", 7)), err: "malformed claims: aud must be a string or an array of strings"},
+		{name: "unknown role", verifier: hmacOnly, token: signToken(t, "HS256", claims("role", "root")), err: `token role "root" is not recognised`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.verifier.Verify(tt.token, now)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("principal %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,723 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// applyUpdate applies update to doc, a pointer to a struct, through its BSON
+// form so dotted paths behave as they do in Mongo's $set and $unset.
+func applyUpdate(doc interface{}, update Update) error {
+	m, err := toBSON(doc)
+	if err != nil {
+		return err
+	}
+	for path, v := range update.Set {
+		keys := strings.Split(path, ".")
+		parent := m
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := parent[key].(bson.M)
+			if !ok {
+				child = bson.M{}
+				parent[key] = child
+			}
+			parent = child
+		}
+		parent[keys[len(keys)-1]] = v
+	}
+	for _, path := range update.Unset {
+		keys := strings.Split(path, ".")
+		parent := m
+		for _, key := range keys[:len(keys)-1] {
+			parent, _ = parent[key].(bson.M)
+		}
+		if parent != nil {
+			delete(parent, keys[len(keys)-1])
+		}
+	}
+	data, err := bson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(doc).Elem()
+	rv.Set(reflect.Zero(rv.Type()))
+	return bson.Unmarshal(data, doc)
+}
+
+// checkVersion reports whether a document at version may be written by a
+// write made on condition it is at want, when there is a condition.
+func checkVersion(version int64, want *int64) error {
+	if want != nil && *want != version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// compareValues orders two field values the way Mongo would for the types
+// our documents use. Values of unknown or mismatched types compare equal.
+func compareValues(a, b interface{}) int {
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	case int:
+		if y, ok := b.(int); ok {
+			return compareOrdered(x, y)
+		}
+	case float64:
+		if y, ok := b.(float64); ok {
+			return compareOrdered(x, y)
+		}
+	case bool:
+		if y, ok := b.(bool); ok && x != y {
+			if x {
+				return 1
+			}
+			return -1
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return strings.Compare(x.Hex(), y.Hex())
+		}
+	}
+	return 0
+}
+
+func compareOrdered[T int | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// sortAndPage drops items at or before opts.After, sorts the rest in place
+// by opts.Sort (then by _id) and returns the requested page.
+func sortAndPage[T any](items []T, opts ListOptions, field func(T, string) interface{}) []T {
+	if opts.After != nil {
+		kept := items[:0]
+		for _, item := range items {
+			if compareValues(field(item, "_id"), *opts.After) > 0 {
+				kept = append(kept, item)
+			}
+		}
+		items = kept
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		for _, f := range opts.Sort {
+			c := compareValues(field(items[i], f.Field), field(items[j], f.Field))
+			if c != 0 {
+				return (c < 0) != f.Desc
+			}
+		}
+		return compareValues(field(items[i], "_id"), field(items[j], "_id")) < 0
+	})
+	if opts.Offset >= len(items) {
+		return items[:0]
+	}
+	items = items[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(items) {
+		items = items[:opts.Limit]
+	}
+	return items
+}
+
+func courseField(course Course, name string) interface{} {
+	switch name {
+	case "_id":
+		return course.ID
+	case "courseid":
+		return course.CourseId
+	case "coursename":
+		return course.CourseName
+	case "price":
+		return course.Price
+	case "author.fullname":
+		if course.Author != nil {
+			return course.Author.Fullname
+		}
+		return ""
+	case "deletedAt":
+		return deletedTime(course.DeletedAt)
+	}
+	return nil
+}
+
+// deletedTime is the time a trashed document was deleted, or the zero time.
+func deletedTime(at *time.Time) time.Time {
+	if at == nil {
+		return time.Time{}
+	}
+	return *at
+}
+
+func movieField(movie Movie, name string) interface{} {
+	switch name {
+	case "_id":
+		return movie.ID
+	case "movie":
+		return movie.Movie
+	case "avgRating":
+		return movie.AvgRating
+	case "ratingCount":
+		return movie.RatingCount
+	case "year":
+		return movie.Year
+	case "runtime":
+		return movie.Runtime
+	case "deletedAt":
+		return deletedTime(movie.DeletedAt)
+	}
+	return nil
+}
+
+// MemoryCourseStore keeps courses in process memory. It mirrors the Mongo
+// store's semantics (insertion order, first match wins) and is safe for
+// concurrent use. Trashed courses stay in the slice with DeletedAt set.
+type MemoryCourseStore struct {
+	mu      sync.RWMutex
+	courses []Course
+}
+
+func NewMemoryCourseStore(seed ...Course) *MemoryCourseStore {
+	s := &MemoryCourseStore{}
+	for _, course := range seed {
+		s.courses = append(s.courses, copyCourse(course))
+	}
+	return s
+}
+
+func copyCourse(course Course) Course {
+	if course.Author != nil {
+		author := *course.Author
+		course.Author = &author
+	}
+	return course
+}
+
+func (f CourseFilter) matches(course Course) bool {
+	if f.CourseIDs != nil && !contains(f.CourseIDs, course.CourseId) {
+		return false
+	}
+	if f.AuthorFullname != "" && (course.Author == nil || course.Author.Fullname != f.AuthorFullname) {
+		return false
+	}
+	if f.PriceMin != nil && course.Price < *f.PriceMin {
+		return false
+	}
+	if f.PriceMax != nil && course.Price > *f.PriceMax {
+		return false
+	}
+	return true
+}
+
+// indexOf finds the first course with courseID that is not in the trash.
+func (s *MemoryCourseStore) indexOf(courseID string) int {
+	for i, course := range s.courses {
+		if course.CourseId == courseID && course.DeletedAt == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *MemoryCourseStore) List(ctx context.Context, filter CourseFilter, opts ListOptions) ([]Course, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	courses := []Course{}
+	for _, course := range s.courses {
+		if course.DeletedAt == nil && filter.matches(course) {
+			courses = append(courses, copyCourse(course))
+		}
+	}
+	total := int64(len(courses))
+	return sortAndPage(courses, opts, courseField), total, nil
+}
+
+func (s *MemoryCourseStore) Stream(ctx context.Context, filter CourseFilter, opts ListOptions, fn func(Course) error) error {
+	courses, _, err := s.List(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	for _, course := range courses {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(course); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryCourseStore) Get(ctx context.Context, courseID string) (Course, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := s.indexOf(courseID)
+	if i < 0 {
+		return Course{}, ErrNotFound
+	}
+	return copyCourse(s.courses[i]), nil
+}
+
+func (s *MemoryCourseStore) Create(ctx context.Context, course Course) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.indexOf(course.CourseId) >= 0 {
+		return ErrDuplicate
+	}
+	s.courses = append(s.courses, copyCourse(course))
+	return nil
+}
+
+func (s *MemoryCourseStore) InsertMany(ctx context.Context, courses []Course) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, course := range courses {
+		if s.indexOf(course.CourseId) >= 0 {
+			return i, ErrDuplicate
+		}
+		s.courses = append(s.courses, copyCourse(course))
+	}
+	return len(courses), nil
+}
+
+func (s *MemoryCourseStore) Replace(ctx context.Context, courseID string, course Course) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(courseID)
+	if i < 0 {
+		return Course{}, ErrNotFound
+	}
+	if err := checkVersion(s.courses[i].Version, &course.Version); err != nil {
+		return Course{}, err
+	}
+	course.Version++
+	s.courses[i] = copyCourse(course)
+	return copyCourse(course), nil
+}
+
+func (s *MemoryCourseStore) Patch(ctx context.Context, courseID string, update Update) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(courseID)
+	if i < 0 {
+		return Course{}, ErrNotFound
+	}
+	if err := checkVersion(s.courses[i].Version, update.Version); err != nil {
+		return Course{}, err
+	}
+	course := copyCourse(s.courses[i])
+	if err := applyUpdate(&course, update); err != nil {
+		return Course{}, err
+	}
+	course.Version++
+	s.courses[i] = course
+	return copyCourse(course), nil
+}
+
+func (s *MemoryCourseStore) Delete(ctx context.Context, courseID string, at time.Time, version *int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(courseID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	if err := checkVersion(s.courses[i].Version, version); err != nil {
+		return err
+	}
+	s.courses[i].DeletedAt = &at
+	s.courses[i].Version++
+	return nil
+}
+
+func (s *MemoryCourseStore) ListDeleted(ctx context.Context, opts ListOptions) ([]Course, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	courses := []Course{}
+	for _, course := range s.courses {
+		if course.DeletedAt != nil {
+			courses = append(courses, copyCourse(course))
+		}
+	}
+	total := int64(len(courses))
+	return sortAndPage(courses, opts, courseField), total, nil
+}
+
+// latestDeleted returns the index of the most recently deleted course with
+// courseID, or -1.
+func (s *MemoryCourseStore) latestDeleted(courseID string) int {
+	latest := -1
+	for i, course := range s.courses {
+		if course.CourseId == courseID && course.DeletedAt != nil &&
+			(latest < 0 || course.DeletedAt.After(*s.courses[latest].DeletedAt)) {
+			latest = i
+		}
+	}
+	return latest
+}
+
+func (s *MemoryCourseStore) GetDeleted(ctx context.Context, courseID string) (Course, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	latest := s.latestDeleted(courseID)
+	if latest < 0 {
+		return Course{}, ErrNotFound
+	}
+	return copyCourse(s.courses[latest]), nil
+}
+
+func (s *MemoryCourseStore) Restore(ctx context.Context, courseID string, version *int64) (Course, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latest := s.latestDeleted(courseID)
+	if latest < 0 {
+		return Course{}, ErrNotFound
+	}
+	if err := checkVersion(s.courses[latest].Version, version); err != nil {
+		return Course{}, err
+	}
+	if s.indexOf(courseID) >= 0 {
+		return Course{}, ErrDuplicate
+	}
+	s.courses[latest].DeletedAt = nil
+	s.courses[latest].Version++
+	return copyCourse(s.courses[latest]), nil
+}
+
+func (s *MemoryCourseStore) Purge(ctx context.Context, id primitive.ObjectID, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, course := range s.courses {
+		if course.ID == id && course.DeletedAt != nil && course.DeletedAt.Before(before) {
+			s.courses = append(s.courses[:i], s.courses[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// MemoryMovieStore keeps movies in process memory and is safe for
+// concurrent use. Trashed movies stay in the slice with DeletedAt set.
+type MemoryMovieStore struct {
+	mu     sync.RWMutex
+	movies []Movie
+}
+
+func NewMemoryMovieStore(seed ...Movie) *MemoryMovieStore {
+	s := &MemoryMovieStore{}
+	for _, movie := range seed {
+		s.movies = append(s.movies, copyMovie(withDuplicateKey(movie)))
+	}
+	return s
+}
+
+// copyMovie clones the slices and pointers of movie, so the store and its
+// callers never share them.
+func copyMovie(movie Movie) Movie {
+	movie.Genres = append([]string(nil), movie.Genres...)
+	movie.Cast = append([]string(nil), movie.Cast...)
+	if movie.Enrichment != nil {
+		e := *movie.Enrichment
+		e.Filled = append([]string(nil), e.Filled...)
+		if e.NextAttemptAt != nil {
+			at := *e.NextAttemptAt
+			e.NextAttemptAt = &at
+		}
+		movie.Enrichment = &e
+	}
+	if movie.DeletedAt != nil {
+		at := *movie.DeletedAt
+		movie.DeletedAt = &at
+	}
+	if movie.LastWatchedAt != nil {
+		at := *movie.LastWatchedAt
+		movie.LastWatchedAt = &at
+	}
+	return movie
+}
+
+func (f MovieFilter) matches(movie Movie) bool {
+	if f.IDs != nil && !containsID(f.IDs, movie.ID) {
+		return false
+	}
+	if containsID(f.ExcludeIDs, movie.ID) {
+		return false
+	}
+	if len(f.Genres) > 0 && !containsAny(movie.Genres, f.Genres) {
+		return false
+	}
+	if f.YearMin != nil && movie.Year < *f.YearMin {
+		return false
+	}
+	if f.YearMax != nil && movie.Year > *f.YearMax {
+		return false
+	}
+	if f.RuntimeMax != nil && (movie.Runtime == 0 || movie.Runtime > *f.RuntimeMax) {
+		return false
+	}
+	if f.RatingMin != nil && movie.AvgRating < *f.RatingMin {
+		return false
+	}
+	if f.DuplicateKey != "" && !strings.HasPrefix(movie.DuplicateKey, duplicatePrefix(f.DuplicateKey)) &&
+		(f.DuplicateYear == 0 || movie.Year != f.DuplicateYear) {
+		return false
+	}
+	return true
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, v := range wanted {
+		if contains(values, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// indexOf finds the movie with id, in the trash or not as deleted says.
+func (s *MemoryMovieStore) indexOf(id primitive.ObjectID, deleted bool) int {
+	for i, movie := range s.movies {
+		if movie.ID == id && (movie.DeletedAt != nil) == deleted {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *MemoryMovieStore) List(ctx context.Context, filter MovieFilter, opts ListOptions) ([]Movie, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	movies := []Movie{}
+	for _, movie := range s.movies {
+		if movie.DeletedAt == nil && filter.matches(movie) {
+			movies = append(movies, copyMovie(movie))
+		}
+	}
+	total := int64(len(movies))
+	return sortAndPage(movies, opts, movieField), total, nil
+}
+
+func (s *MemoryMovieStore) Stream(ctx context.Context, filter MovieFilter, opts ListOptions, fn func(Movie) error) error {
+	movies, _, err := s.List(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	for _, movie := range movies {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(movie); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryMovieStore) Get(ctx context.Context, id primitive.ObjectID) (Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := s.indexOf(id, false)
+	if i < 0 {
+		return Movie{}, ErrNotFound
+	}
+	return copyMovie(s.movies[i]), nil
+}
+
+func (s *MemoryMovieStore) Create(ctx context.Context, movie Movie) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.movies = append(s.movies, copyMovie(withDuplicateKey(movie)))
+	return nil
+}
+
+func (s *MemoryMovieStore) InsertMany(ctx context.Context, movies []Movie) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, movie := range movies {
+		s.movies = append(s.movies, copyMovie(withDuplicateKey(movie)))
+	}
+	return len(movies), nil
+}
+
+func (s *MemoryMovieStore) Replace(ctx context.Context, movie Movie) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(movie.ID, false)
+	if i < 0 {
+		return Movie{}, ErrNotFound
+	}
+	if err := checkVersion(s.movies[i].Version, &movie.Version); err != nil {
+		return Movie{}, err
+	}
+	movie.Version++
+	movie.AvgRating, movie.RatingCount = s.movies[i].AvgRating, s.movies[i].RatingCount
+	movie = withDuplicateKey(movie)
+	s.movies[i] = copyMovie(movie)
+	return copyMovie(movie), nil
+}
+
+func (s *MemoryMovieStore) Patch(ctx context.Context, id primitive.ObjectID, update Update) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id, false)
+	if i < 0 {
+		return Movie{}, ErrNotFound
+	}
+	if err := checkVersion(s.movies[i].Version, update.Version); err != nil {
+		return Movie{}, err
+	}
+	movie := copyMovie(s.movies[i])
+	if err := applyUpdate(&movie, duplicateKeyUpdate(update)); err != nil {
+		return Movie{}, err
+	}
+	movie.Version++
+	s.movies[i] = movie
+	return copyMovie(movie), nil
+}
+
+func (s *MemoryMovieStore) SetRating(ctx context.Context, was Movie, avg float64, count int) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(was.ID, false)
+	if i < 0 {
+		return Movie{}, ErrNotFound
+	}
+	if s.movies[i].AvgRating != was.AvgRating || s.movies[i].RatingCount != was.RatingCount {
+		return Movie{}, ErrVersionMismatch
+	}
+	s.movies[i].AvgRating, s.movies[i].RatingCount = avg, count
+	return copyMovie(s.movies[i]), nil
+}
+
+func (s *MemoryMovieStore) ClaimEnrichment(ctx context.Context, now time.Time, lease time.Duration) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	best := -1
+	for i, movie := range s.movies {
+		e := movie.Enrichment
+		if movie.DeletedAt != nil || e == nil || (e.Status != EnrichmentPending && e.Status != EnrichmentProcessing) || e.NextAttemptAt == nil || e.NextAttemptAt.After(now) {
+			continue
+		}
+		if best < 0 || e.NextAttemptAt.Before(*s.movies[best].Enrichment.NextAttemptAt) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Movie{}, ErrNotFound
+	}
+	e := *s.movies[best].Enrichment
+	expires := now.Add(lease)
+	e.Status, e.NextAttemptAt, e.UpdatedAt = EnrichmentProcessing, &expires, now
+	e.Attempts++
+	s.movies[best].Enrichment = &e
+	s.movies[best].Version++
+	return copyMovie(s.movies[best]), nil
+}
+
+func (s *MemoryMovieStore) Delete(ctx context.Context, id primitive.ObjectID, at time.Time, version *int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id, false)
+	if i < 0 {
+		return ErrNotFound
+	}
+	if err := checkVersion(s.movies[i].Version, version); err != nil {
+		return err
+	}
+	s.movies[i].DeletedAt = &at
+	s.movies[i].Version++
+	return nil
+}
+
+func (s *MemoryMovieStore) DeleteAll(ctx context.Context, at time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for i := range s.movies {
+		if s.movies[i].DeletedAt == nil {
+			s.movies[i].DeletedAt = &at
+			s.movies[i].Version++
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryMovieStore) ListDeleted(ctx context.Context, opts ListOptions) ([]Movie, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	movies := []Movie{}
+	for _, movie := range s.movies {
+		if movie.DeletedAt != nil {
+			movies = append(movies, copyMovie(movie))
+		}
+	}
+	total := int64(len(movies))
+	return sortAndPage(movies, opts, movieField), total, nil
+}
+
+func (s *MemoryMovieStore) GetDeleted(ctx context.Context, id primitive.ObjectID) (Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := s.indexOf(id, true)
+	if i < 0 {
+		return Movie{}, ErrNotFound
+	}
+	return copyMovie(s.movies[i]), nil
+}
+
+func (s *MemoryMovieStore) Restore(ctx context.Context, id primitive.ObjectID, version *int64) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id, true)
+	if i < 0 {
+		return Movie{}, ErrNotFound
+	}
+	if err := checkVersion(s.movies[i].Version, version); err != nil {
+		return Movie{}, err
+	}
+	s.movies[i].DeletedAt = nil
+	s.movies[i].Version++
+	return copyMovie(s.movies[i]), nil
+}
+
+func (s *MemoryMovieStore) Purge(ctx context.Context, id primitive.ObjectID, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, movie := range s.movies {
+		if movie.ID == id && movie.DeletedAt != nil && movie.DeletedAt.Before(before) {
+			s.movies = append(s.movies[:i], s.movies[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
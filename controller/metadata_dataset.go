@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DatasetProvider is a MetadataProvider backed by an in-memory dataset,
+// typically loaded from a local JSON or CSV file. It needs no network, so
+// enrichment works offline and in tests.
+type DatasetProvider struct {
+	byTitle map[string][]Metadata
+}
+
+func NewDatasetProvider(records ...Metadata) *DatasetProvider {
+	p := &DatasetProvider{byTitle: map[string][]Metadata{}}
+	for _, record := range records {
+		key := titleKey(record.Title)
+		p.byTitle[key] = append(p.byTitle[key], record)
+	}
+	return p
+}
+
+// titleKey folds case and runs of whitespace so that titles typed
+// slightly differently still match.
+func titleKey(title string) string {
+	return strings.Join(strings.Fields(strings.ToLower(title)), " ")
+}
+
+// Lookup matches on title. When year is known only a record from that year
+// matches, which tells remakes apart; otherwise the first record wins.
+func (p *DatasetProvider) Lookup(ctx context.Context, title string, year int) (Metadata, error) {
+	for _, record := range p.byTitle[titleKey(title)] {
+		if year == 0 || record.Year == year {
+			return record, nil
+		}
+	}
+	return Metadata{}, ErrNoMetadata
+}
+
+// LoadDataset reads a dataset file. A .json file holds an array of Metadata
+// objects. A .csv file has a header row naming the columns title, year,
+// genres, runtime, director, cast and posterUrl, in any order and all but
+// title optional; genres and cast are separated by "|".
+func LoadDataset(path string) (*DatasetProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("metadata dataset: %w", err)
+	}
+	defer f.Close()
+	var records []Metadata
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.NewDecoder(f).Decode(&records)
+	case ".csv":
+		records, err = readDatasetCSV(f)
+	default:
+		return nil, fmt.Errorf("metadata dataset %s: must be a .json or .csv file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("metadata dataset %s: %w", path, err)
+	}
+	for i, record := range records {
+		if strings.TrimSpace(record.Title) == "" {
+			return nil, fmt.Errorf("metadata dataset %s: record %d has no title", path, i+1)
+		}
+	}
+	return NewDatasetProvider(records...), nil
+}
+
+func readDatasetCSV(r io.Reader) ([]Metadata, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("CSV header has no title column")
+	}
+	var records []Metadata
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		record := Metadata{
+			Title:     get("title"),
+			Genres:    splitCSVList(get("genres")),
+			Director:  get("director"),
+			Cast:      splitCSVList(get("cast")),
+			PosterURL: get("posterUrl"),
+		}
+		for name, dst := range map[string]*int{"year": &record.Year, "runtime": &record.Runtime} {
+			if v := get(name); v != "" {
+				if *dst, err = strconv.Atoi(v); err != nil {
+					return nil, fmt.Errorf("line %d: %s must be an integer", line, name)
+				}
+			}
+		}
+		records = append(records, record)
+	}
+}
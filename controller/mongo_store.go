@@ -0,0 +1,682 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func findOptions(opts ListOptions) *options.FindOptions {
+	sort := bson.D{}
+	sortsByID := false
+	for _, f := range opts.Sort {
+		dir := 1
+		if f.Desc {
+			dir = -1
+		}
+		sort = append(sort, bson.E{Key: f.Field, Value: dir})
+		sortsByID = sortsByID || f.Field == "_id"
+	}
+	if !sortsByID {
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
+	}
+	fo := options.Find().SetSort(sort).SetSkip(int64(opts.Offset))
+	if opts.Limit > 0 {
+		fo.SetLimit(int64(opts.Limit))
+	}
+	return fo
+}
+
+// pageFilter adds the keyset bound from opts to filter. A filter that
+// already constrains _id is combined with the bound through $and.
+func pageFilter(filter bson.M, opts ListOptions) bson.M {
+	if opts.After == nil {
+		return filter
+	}
+	bound := bson.M{"_id": bson.M{"$gt": *opts.After}}
+	if _, ok := filter["_id"]; ok {
+		return bson.M{"$and": bson.A{filter, bound}}
+	}
+	for k, v := range filter {
+		bound[k] = v
+	}
+	return bound
+}
+
+// insertMany writes docs with an ordered insert and reports how many were
+// inserted before any failure. An ordered insert stops at the first write
+// error, so its index is the number of documents written.
+func insertMany(ctx context.Context, coll *mongo.Collection, docs []interface{}) (int, error) {
+	if len(docs) == 0 {
+		return 0, nil
+	}
+	_, err := coll.InsertMany(ctx, docs)
+	if err == nil {
+		return len(docs), nil
+	}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		return bulkErr.WriteErrors[0].Index, err
+	}
+	return 0, err
+}
+
+func updateDoc(update Update) bson.M {
+	doc := bson.M{"$inc": bson.M{"version": 1}}
+	if len(update.Set) > 0 {
+		doc["$set"] = update.Set
+	}
+	if len(update.Unset) > 0 {
+		unset := bson.M{}
+		for _, path := range update.Unset {
+			unset[path] = ""
+		}
+		doc["$unset"] = unset
+	}
+	return doc
+}
+
+// notDeleted restricts filter to documents that are not in the trash.
+func notDeleted(filter bson.M) bson.M {
+	filter["deletedAt"] = bson.M{"$exists": false}
+	return filter
+}
+
+// atVersion restricts filter to the document at the given version, when
+// one is given. Documents written before versions were kept have none,
+// which counts as version 0.
+func atVersion(filter bson.M, version *int64) bson.M {
+	switch {
+	case version == nil:
+	case *version == 0:
+		filter["version"] = bson.M{"$in": bson.A{int64(0), nil}}
+	default:
+		filter["version"] = *version
+	}
+	return filter
+}
+
+// versionMiss explains why a version-checked write to the document
+// matching filter matched nothing: it is gone, or at another version.
+func versionMiss(ctx context.Context, coll *mongo.Collection, filter bson.M) error {
+	n, err := coll.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionMismatch
+}
+
+// deletedFilter matches the documents in the trash.
+func deletedFilter() bson.M {
+	return bson.M{"deletedAt": bson.M{"$exists": true}}
+}
+
+// streamBatchSize bounds how many documents the driver holds per round trip
+// while streaming.
+const streamBatchSize = 500
+
+type MongoCourseStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoCourseStore(coll *mongo.Collection) *MongoCourseStore {
+	return &MongoCourseStore{coll: coll}
+}
+
+// EnsureIndexes creates the unique index that gives each course outside
+// the trash its own courseid. A partial index cannot select the documents
+// lacking deletedAt, so deletedAt is part of the key instead: every live
+// course has the same missing value, and trashed ones their deletion times.
+// EnsureIndexes makes courseid unique outside the trash. Collections
+// written before it was unique may hold several live courses with one
+// courseid; the index cannot be built until they are told apart, so the
+// error then names them.
+func (s *MongoCourseStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "courseid", Value: 1}, {Key: "deletedAt", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	shared, findErr := s.sharedCourseIDs(ctx)
+	if findErr != nil || len(shared) == 0 {
+		return err
+	}
+	return fmt.Errorf("courseids must be unique, but %d are each used by several courses: %s; "+
+		"give each of those courses its own courseid, or move the extras to the trash "+
+		"(db.%s.updateOne({_id: ...}, {$set: {deletedAt: new Date()}})), then restart",
+		len(shared), strings.Join(shared, ", "), s.coll.Name())
+}
+
+// maxSharedCourseIDs bounds how many shared courseids an error lists.
+const maxSharedCourseIDs = 20
+
+// sharedCourseIDs finds courseids held by more than one course outside
+// the trash.
+func (s *MongoCourseStore) sharedCourseIDs(ctx context.Context) ([]string, error) {
+	cursor, err := s.coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: notDeleted(bson.M{})}},
+		{{Key: "$group", Value: bson.M{"_id": "$courseid", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$limit", Value: maxSharedCourseIDs}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		ID    string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	shared := make([]string, len(groups))
+	for i, g := range groups {
+		shared[i] = fmt.Sprintf("%q (%d courses)", g.ID, g.Count)
+	}
+	return shared, nil
+}
+
+// duplicateErr maps a duplicate key error to ErrDuplicate.
+func duplicateErr(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func courseFilterDoc(filter CourseFilter) bson.M {
+	doc := bson.M{}
+	if filter.CourseIDs != nil {
+		doc["courseid"] = bson.M{"$in": filter.CourseIDs}
+	}
+	if filter.AuthorFullname != "" {
+		doc["author.fullname"] = filter.AuthorFullname
+	}
+	price := bson.M{}
+	if filter.PriceMin != nil {
+		price["$gte"] = *filter.PriceMin
+	}
+	if filter.PriceMax != nil {
+		price["$lte"] = *filter.PriceMax
+	}
+	if len(price) > 0 {
+		doc["price"] = price
+	}
+	return notDeleted(doc)
+}
+
+func (s *MongoCourseStore) List(ctx context.Context, filter CourseFilter, opts ListOptions) ([]Course, int64, error) {
+	doc := courseFilterDoc(filter)
+	total, err := s.coll.CountDocuments(ctx, doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.coll.Find(ctx, pageFilter(doc, opts), findOptions(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+	courses := []Course{}
+	if err := cursor.All(ctx, &courses); err != nil {
+		return nil, 0, err
+	}
+	return courses, total, nil
+}
+
+func (s *MongoCourseStore) Stream(ctx context.Context, filter CourseFilter, opts ListOptions, fn func(Course) error) error {
+	cursor, err := s.coll.Find(ctx, pageFilter(courseFilterDoc(filter), opts), findOptions(opts).SetBatchSize(streamBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var course Course
+		if err := cursor.Decode(&course); err != nil {
+			return err
+		}
+		if err := fn(course); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *MongoCourseStore) Get(ctx context.Context, courseID string) (Course, error) {
+	var course Course
+	err := s.coll.FindOne(ctx, notDeleted(bson.M{"courseid": courseID})).Decode(&course)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Course{}, ErrNotFound
+	}
+	return course, err
+}
+
+func (s *MongoCourseStore) Create(ctx context.Context, course Course) error {
+	_, err := s.coll.InsertOne(ctx, course)
+	return duplicateErr(err)
+}
+
+func (s *MongoCourseStore) InsertMany(ctx context.Context, courses []Course) (int, error) {
+	docs := make([]interface{}, len(courses))
+	for i, course := range courses {
+		docs[i] = course
+	}
+	n, err := insertMany(ctx, s.coll, docs)
+	return n, duplicateErr(err)
+}
+
+func (s *MongoCourseStore) Replace(ctx context.Context, courseID string, course Course) (Course, error) {
+	version := course.Version
+	course.Version++
+	result, err := s.coll.ReplaceOne(ctx, atVersion(notDeleted(bson.M{"courseid": courseID}), &version), course)
+	if err != nil {
+		return Course{}, err
+	}
+	if result.MatchedCount == 0 {
+		return Course{}, versionMiss(ctx, s.coll, notDeleted(bson.M{"courseid": courseID}))
+	}
+	return course, nil
+}
+
+func (s *MongoCourseStore) Patch(ctx context.Context, courseID string, update Update) (Course, error) {
+	var course Course
+	err := s.coll.FindOneAndUpdate(ctx, atVersion(notDeleted(bson.M{"courseid": courseID}), update.Version), updateDoc(update),
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&course)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Course{}, versionMiss(ctx, s.coll, notDeleted(bson.M{"courseid": courseID}))
+	}
+	return course, err
+}
+
+func (s *MongoCourseStore) Delete(ctx context.Context, courseID string, at time.Time, version *int64) error {
+	result, err := s.coll.UpdateOne(ctx, atVersion(notDeleted(bson.M{"courseid": courseID}), version), bson.M{"$set": bson.M{"deletedAt": at}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMiss(ctx, s.coll, notDeleted(bson.M{"courseid": courseID}))
+	}
+	return nil
+}
+
+func (s *MongoCourseStore) ListDeleted(ctx context.Context, opts ListOptions) ([]Course, int64, error) {
+	total, err := s.coll.CountDocuments(ctx, deletedFilter())
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.coll.Find(ctx, pageFilter(deletedFilter(), opts), findOptions(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+	courses := []Course{}
+	if err := cursor.All(ctx, &courses); err != nil {
+		return nil, 0, err
+	}
+	return courses, total, nil
+}
+
+func (s *MongoCourseStore) GetDeleted(ctx context.Context, courseID string) (Course, error) {
+	filter := deletedFilter()
+	filter["courseid"] = courseID
+	var course Course
+	err := s.coll.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "deletedAt", Value: -1}})).Decode(&course)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Course{}, ErrNotFound
+	}
+	return course, err
+}
+
+// Restore finds the most recently deleted course first and then restores
+// it by _id, so the version check applies to that course alone.
+func (s *MongoCourseStore) Restore(ctx context.Context, courseID string, version *int64) (Course, error) {
+	latest, err := s.GetDeleted(ctx, courseID)
+	if err != nil {
+		return Course{}, err
+	}
+	filter := deletedFilter()
+	filter["_id"] = latest.ID
+	var course Course
+	err = s.coll.FindOneAndUpdate(ctx, atVersion(filter, version), bson.M{"$unset": bson.M{"deletedAt": ""}, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&course)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		filter = deletedFilter()
+		filter["_id"] = latest.ID
+		return Course{}, versionMiss(ctx, s.coll, filter)
+	}
+	return course, duplicateErr(err)
+}
+
+func (s *MongoCourseStore) Purge(ctx context.Context, id primitive.ObjectID, before time.Time) error {
+	return purgeDeleted(ctx, s.coll, id, before)
+}
+
+type MongoMovieStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoMovieStore(coll *mongo.Collection) *MongoMovieStore {
+	return &MongoMovieStore{coll: coll}
+}
+
+// EnsureIndexes creates the indexes behind the movie filters, the metadata
+// and rating sorts, the duplicate check and the trash, and stores the
+// duplicate key of movies saved before it was kept.
+func (s *MongoMovieStore) EnsureIndexes(ctx context.Context) error {
+	if err := s.backfillDuplicateKeys(ctx); err != nil {
+		return err
+	}
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "duplicateKey", Value: 1}}},
+		{Keys: bson.D{{Key: "genres", Value: 1}}},
+		{Keys: bson.D{{Key: "year", Value: 1}}},
+		{Keys: bson.D{{Key: "runtime", Value: 1}}},
+		{Keys: bson.D{{Key: "director", Value: 1}}},
+		{Keys: bson.D{{Key: "cast", Value: 1}}},
+		{Keys: bson.D{{Key: "avgRating", Value: 1}}},
+		{Keys: bson.D{{Key: "enrichment.status", Value: 1}, {Key: "enrichment.nextAttemptAt", Value: 1}}},
+		{Keys: bson.D{{Key: "deletedAt", Value: 1}}},
+	})
+	return err
+}
+
+func (s *MongoMovieStore) backfillDuplicateKeys(ctx context.Context) error {
+	cursor, err := s.coll.Find(ctx, bson.M{"duplicateKey": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"movie": 1}).SetBatchSize(streamBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	var models []mongo.WriteModel
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		_, err := s.coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		models = models[:0]
+		return err
+	}
+	for cursor.Next(ctx) {
+		var movie Movie
+		if err := cursor.Decode(&movie); err != nil {
+			return err
+		}
+		key, _ := duplicateKey(movie.Movie)
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": movie.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"duplicateKey": key}}))
+		if len(models) == importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func movieFilterDoc(filter MovieFilter) bson.M {
+	doc := bson.M{}
+	ids := bson.M{}
+	if filter.IDs != nil {
+		ids["$in"] = filter.IDs
+	}
+	if len(filter.ExcludeIDs) > 0 {
+		ids["$nin"] = filter.ExcludeIDs
+	}
+	if len(ids) > 0 {
+		doc["_id"] = ids
+	}
+	if len(filter.Genres) > 0 {
+		doc["genres"] = bson.M{"$in": filter.Genres}
+	}
+	year := bson.M{}
+	if filter.YearMin != nil {
+		year["$gte"] = *filter.YearMin
+	}
+	if filter.YearMax != nil {
+		year["$lte"] = *filter.YearMax
+	}
+	if len(year) > 0 {
+		doc["year"] = year
+	}
+	if filter.RuntimeMax != nil {
+		doc["runtime"] = bson.M{"$lte": *filter.RuntimeMax}
+	}
+	if filter.RatingMin != nil {
+		doc["avgRating"] = bson.M{"$gte": *filter.RatingMin}
+	}
+	if filter.DuplicateKey != "" {
+		similar := bson.A{bson.M{"duplicateKey": bson.M{"$regex": "^" + regexp.QuoteMeta(duplicatePrefix(filter.DuplicateKey))}}}
+		if filter.DuplicateYear != 0 {
+			similar = append(similar, bson.M{"year": filter.DuplicateYear})
+		}
+		doc["$or"] = similar
+	}
+	return notDeleted(doc)
+}
+
+func (s *MongoMovieStore) List(ctx context.Context, filter MovieFilter, opts ListOptions) ([]Movie, int64, error) {
+	doc := movieFilterDoc(filter)
+	total, err := s.coll.CountDocuments(ctx, doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.coll.Find(ctx, pageFilter(doc, opts), findOptions(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+	movies := []Movie{}
+	if err := cursor.All(ctx, &movies); err != nil {
+		return nil, 0, err
+	}
+	return movies, total, nil
+}
+
+func (s *MongoMovieStore) Stream(ctx context.Context, filter MovieFilter, opts ListOptions, fn func(Movie) error) error {
+	cursor, err := s.coll.Find(ctx, pageFilter(movieFilterDoc(filter), opts), findOptions(opts).SetBatchSize(streamBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var movie Movie
+		if err := cursor.Decode(&movie); err != nil {
+			return err
+		}
+		if err := fn(movie); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *MongoMovieStore) Get(ctx context.Context, id primitive.ObjectID) (Movie, error) {
+	var movie Movie
+	err := s.coll.FindOne(ctx, notDeleted(bson.M{"_id": id})).Decode(&movie)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Movie{}, ErrNotFound
+	}
+	return movie, err
+}
+
+func (s *MongoMovieStore) Create(ctx context.Context, movie Movie) error {
+	_, err := s.coll.InsertOne(ctx, withDuplicateKey(movie))
+	return err
+}
+
+func (s *MongoMovieStore) InsertMany(ctx context.Context, movies []Movie) (int, error) {
+	docs := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		docs[i] = withDuplicateKey(movie)
+	}
+	return insertMany(ctx, s.coll, docs)
+}
+
+func (s *MongoMovieStore) Replace(ctx context.Context, movie Movie) (Movie, error) {
+	version := movie.Version
+	movie.Version++
+	doc, err := bson.Marshal(withDuplicateKey(movie))
+	if err != nil {
+		return Movie{}, err
+	}
+	// The replacement is taken literally, so no value in it is read as an
+	// expression, except for the rating, which is carried over.
+	replace := mongo.Pipeline{{{Key: "$replaceWith", Value: bson.M{"$mergeObjects": bson.A{
+		bson.M{"$literal": bson.Raw(doc)},
+		bson.M{"avgRating": "$avgRating", "ratingCount": "$ratingCount"},
+	}}}}}
+	var stored Movie
+	err = s.coll.FindOneAndUpdate(ctx, atVersion(notDeleted(bson.M{"_id": movie.ID}), &version), replace,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Movie{}, versionMiss(ctx, s.coll, notDeleted(bson.M{"_id": movie.ID}))
+	}
+	return stored, err
+}
+
+func (s *MongoMovieStore) Patch(ctx context.Context, id primitive.ObjectID, update Update) (Movie, error) {
+	var movie Movie
+	err := s.coll.FindOneAndUpdate(ctx, atVersion(notDeleted(bson.M{"_id": id}), update.Version), updateDoc(duplicateKeyUpdate(update)),
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&movie)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Movie{}, versionMiss(ctx, s.coll, notDeleted(bson.M{"_id": id}))
+	}
+	return movie, err
+}
+
+func (s *MongoMovieStore) SetRating(ctx context.Context, was Movie, avg float64, count int) (Movie, error) {
+	filter := notDeleted(bson.M{"_id": was.ID, "avgRating": was.AvgRating, "ratingCount": was.RatingCount})
+	if was.RatingCount == 0 {
+		// Movies stored before ratings were kept have none, which counts
+		// as no reviews.
+		filter["avgRating"] = bson.M{"$in": bson.A{was.AvgRating, nil}}
+		filter["ratingCount"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	var movie Movie
+	err := s.coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"avgRating": avg, "ratingCount": count}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&movie)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Movie{}, versionMiss(ctx, s.coll, notDeleted(bson.M{"_id": was.ID}))
+	}
+	return movie, err
+}
+
+func (s *MongoMovieStore) ClaimEnrichment(ctx context.Context, now time.Time, lease time.Duration) (Movie, error) {
+	var movie Movie
+	err := s.coll.FindOneAndUpdate(ctx,
+		notDeleted(bson.M{
+			"enrichment.status":        bson.M{"$in": bson.A{EnrichmentPending, EnrichmentProcessing}},
+			"enrichment.nextAttemptAt": bson.M{"$lte": now},
+		}),
+		bson.M{
+			"$set": bson.M{
+				"enrichment.status":        EnrichmentProcessing,
+				"enrichment.nextAttemptAt": now.Add(lease),
+				"enrichment.updatedAt":     now,
+			},
+			"$inc": bson.M{"enrichment.attempts": 1, "version": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "enrichment.nextAttemptAt", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&movie)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Movie{}, ErrNotFound
+	}
+	return movie, err
+}
+
+func (s *MongoMovieStore) Delete(ctx context.Context, id primitive.ObjectID, at time.Time, version *int64) error {
+	result, err := s.coll.UpdateOne(ctx, atVersion(notDeleted(bson.M{"_id": id}), version), bson.M{"$set": bson.M{"deletedAt": at}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return versionMiss(ctx, s.coll, notDeleted(bson.M{"_id": id}))
+	}
+	return nil
+}
+
+func (s *MongoMovieStore) DeleteAll(ctx context.Context, at time.Time) (int64, error) {
+	result, err := s.coll.UpdateMany(ctx, notDeleted(bson.M{}), bson.M{"$set": bson.M{"deletedAt": at}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (s *MongoMovieStore) ListDeleted(ctx context.Context, opts ListOptions) ([]Movie, int64, error) {
+	total, err := s.coll.CountDocuments(ctx, deletedFilter())
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.coll.Find(ctx, pageFilter(deletedFilter(), opts), findOptions(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+	movies := []Movie{}
+	if err := cursor.All(ctx, &movies); err != nil {
+		return nil, 0, err
+	}
+	return movies, total, nil
+}
+
+func (s *MongoMovieStore) GetDeleted(ctx context.Context, id primitive.ObjectID) (Movie, error) {
+	filter := deletedFilter()
+	filter["_id"] = id
+	var movie Movie
+	err := s.coll.FindOne(ctx, filter).Decode(&movie)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Movie{}, ErrNotFound
+	}
+	return movie, err
+}
+
+func (s *MongoMovieStore) Restore(ctx context.Context, id primitive.ObjectID, version *int64) (Movie, error) {
+	filter := deletedFilter()
+	filter["_id"] = id
+	var movie Movie
+	err := s.coll.FindOneAndUpdate(ctx, atVersion(filter, version), bson.M{"$unset": bson.M{"deletedAt": ""}, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&movie)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		filter = deletedFilter()
+		filter["_id"] = id
+		return Movie{}, versionMiss(ctx, s.coll, filter)
+	}
+	return movie, err
+}
+
+func (s *MongoMovieStore) Purge(ctx context.Context, id primitive.ObjectID, before time.Time) error {
+	return purgeDeleted(ctx, s.coll, id, before)
+}
+
+// purgeDeleted deletes a document from coll's trash if it expired before
+// the given time, so a document restored meanwhile is kept.
+func purgeDeleted(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, before time.Time) error {
+	result, err := coll.DeleteOne(ctx, bson.M{"_id": id, "deletedAt": bson.M{"$lt": before}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
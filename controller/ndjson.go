@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonFlushEvery is how many lines are written between flushes, so slow
+// clients see progress without a syscall per document.
+const ndjsonFlushEvery = 100
+
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// streamOptions adapts list options for a stream: without an explicit
+// ?limit= the whole result set is streamed.
+func streamOptions(q url.Values, opts ListOptions) ListOptions {
+	if q.Get("limit") == "" {
+		opts.Limit = 0
+	}
+	return opts
+}
+
+// streamNDJSON writes one JSON document per line as produce emits them.
+// Headers are sent before the first document, so a failure part-way through
+// can only be logged; the client sees a truncated stream.
+func streamNDJSON(w http.ResponseWriter, produce func(emit func(interface{}) error) error) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	n := 0
+	err := produce(func(v interface{}) error {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		n++
+		if flusher != nil && n%ndjsonFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("NDJSON stream stopped after %d documents: %v", n, err)
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
@@ -3,130 +3,327 @@ package controller
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
-	"fmt"
 
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var NetflixCollection *mongo.Collection
-
 type Movie struct {
-	ID      primitive.ObjectID `json:"_id" bson:"_id"`
-	Movie   string             `json:"movie" bson:"movie"`
-	Watched bool               `json:"watched" bson:"watched"`
+	ID    primitive.ObjectID `json:"_id" bson:"_id"`
+	Movie string             `json:"movie" bson:"movie" validate:"required,max=200"`
+
+	// Descriptive metadata, all optional. Runtime is in minutes. Genres
+	// are stored lower-cased so filtering on them is case-insensitive.
+	Year      int      `json:"year,omitempty" bson:"year,omitempty" validate:"min=1888,max=2100"`
+	Genres    []string `json:"genres,omitempty" bson:"genres,omitempty" validate:"max=10,dive,required,max=50"`
+	Runtime   int      `json:"runtime,omitempty" bson:"runtime,omitempty" validate:"min=1,max=1440"`
+	Director  string   `json:"director,omitempty" bson:"director,omitempty" validate:"max=200"`
+	Cast      []string `json:"cast,omitempty" bson:"cast,omitempty" validate:"max=100,dive,required,max=200"`
+	PosterURL string   `json:"posterUrl,omitempty" bson:"posterUrl,omitempty" validate:"url,max=2048"`
+
+	// AvgRating and RatingCount aggregate the movie's reviews. The server
+	// maintains them as reviews are written; request bodies cannot set them.
+	AvgRating   float64 `json:"avgRating" bson:"avgRating"`
+	RatingCount int     `json:"ratingCount" bson:"ratingCount"`
+
+	// Enrichment tracks the background lookup of missing metadata. It is
+	// absent when enrichment is disabled, and request bodies cannot set it.
+	Enrichment *Enrichment `json:"enrichment,omitempty" bson:"enrichment,omitempty"`
+
+	// DeletedAt is set while the movie is in the trash. Request bodies
+	// cannot set it.
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+
+	// Version counts the changes made to the movie and is its ETag.
+	// Request bodies cannot set it.
+	Version int64 `json:"version" bson:"version"`
+
+	// DuplicateKey is the title's duplicate key, kept by the store so that
+	// near-duplicates can be looked up by it.
+	DuplicateKey string `json:"-" bson:"duplicateKey"`
+
+	// The remaining fields describe the caller's own history with the
+	// movie. They are derived for each response from the watch history,
+	// never stored, and ignored in request bodies.
+	Watched       bool       `json:"watched" bson:"-"`
+	WatchCount    int        `json:"watchCount" bson:"-"`
+	LastWatchedAt *time.Time `json:"lastWatchedAt,omitempty" bson:"-"`
+}
+
+// normalize tidies the title, trims the metadata fields and lower-cases
+// and de-duplicates the genres.
+func (m *Movie) normalize() {
+	m.Movie, m.Year = normalizeTitle(m.Movie, m.Year)
+	m.Director = strings.TrimSpace(m.Director)
+	m.PosterURL = strings.TrimSpace(m.PosterURL)
+	if m.Genres != nil {
+		genres := []string{}
+		for _, genre := range m.Genres {
+			genre = strings.ToLower(strings.TrimSpace(genre))
+			if !contains(genres, genre) {
+				genres = append(genres, genre)
+			}
+		}
+		m.Genres = genres
+	}
+	for i, name := range m.Cast {
+		m.Cast[i] = strings.TrimSpace(name)
+	}
+}
+
+// watchlist is one caller's watch history, by movie id.
+type watchlist map[primitive.ObjectID]WatchState
+
+// apply fills in the caller-specific fields of movie.
+func (wl watchlist) apply(movie *Movie) {
+	state, ok := wl[movie.ID]
+	movie.Watched = state.Watched
+	movie.WatchCount = state.WatchCount
+	movie.LastWatchedAt = nil
+	if ok && !state.LastWatchedAt.IsZero() {
+		last := state.LastWatchedAt
+		movie.LastWatchedAt = &last
+	}
+}
+
+// watchedIDs returns the ids of the movies currently on the watchlist.
+func (wl watchlist) watchedIDs() []primitive.ObjectID {
+	ids := []primitive.ObjectID{}
+	for id, state := range wl {
+		if state.Watched {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// watchlist returns the caller's watch history. Anonymous callers have
+// watched nothing.
+func (c *Controller) watchlist(ctx context.Context) (watchlist, error) {
+	wl := watchlist{}
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return wl, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	states, err := c.Watches.States(ctx, principal.Subject)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		wl[state.MovieID] = state
+	}
+	return wl, nil
 }
 
-func GetAllMovies(w http.ResponseWriter, r *http.Request) {
+// callerSubject returns the subject of the authenticated caller. Routes
+// that act on the caller's own data use it to reject anonymous requests.
+func callerSubject(r *http.Request) (string, error) {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		return "", unauthorized("Authentication required: this endpoint acts on your own data")
+	}
+	return principal.Subject, nil
+}
+
+func (c *Controller) GetAllMovies(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	var movies []Movie
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	q := r.URL.Query()
+	opts, err := parseListOptions(q, movieSortFields)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	wl, err := c.watchlist(r.Context())
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
+		return
+	}
+	filter, err := parseMovieFilter(q, wl)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	c.listMovies(w, r, filter, opts, wl, "Movies retrieved successfully")
+}
+
+// listMovies renders a movie listing as an offset page, a cursor page or an
+// NDJSON stream, depending on what the request asked for. wl is the
+// caller's watch history, used to fill in the caller-specific fields.
+func (c *Controller) listMovies(w http.ResponseWriter, r *http.Request, filter MovieFilter, opts ListOptions, wl watchlist, message string) {
+	q := r.URL.Query()
+	if wantsNDJSON(r) {
+		streamNDJSON(w, func(emit func(interface{}) error) error {
+			return c.Movies.Stream(r.Context(), filter, streamOptions(q, opts), func(movie Movie) error {
+				wl.apply(&movie)
+				return emit(movie)
+			})
+		})
+		return
+	}
+	keyset := usesCursor(q)
+	fetch := opts
+	if keyset {
+		fetch.Limit++
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
-	cursor, err := NetflixCollection.Find(ctx, bson.M{})
+	movies, total, err := c.Movies.List(ctx, filter, fetch)
 	if err != nil {
-		log.Println("Error fetching movies:", err)
-		http.Error(w, "Failed to fetch movies: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to fetch movies", err))
 		return
 	}
-	defer cursor.Close(ctx)
-	for cursor.Next(ctx) {
-		var movie Movie
-		if err := cursor.Decode(&movie); err != nil {
-			log.Println("Error decoding movie:", err)
-			http.Error(w, "Failed to decode movie: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		movies = append(movies, movie)
+	for i := range movies {
+		wl.apply(&movies[i])
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Movies retrieved successfully",
-		"data":    movies,
-	})
+	if keyset {
+		page, next := cursorPage(movies, opts.Limit, func(m Movie) primitive.ObjectID { return m.ID })
+		json.NewEncoder(w).Encode(cursorResponse(r, message, page, total, opts, next))
+		return
+	}
+	json.NewEncoder(w).Encode(listResponse(r, message, movies, total, opts))
 }
 
-func GetOneMovie(w http.ResponseWriter, r *http.Request) {
+func (c *Controller) GetOneMovie(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 	id, err := primitive.ObjectIDFromHex(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid movie ID: "+err.Error(), http.StatusBadRequest)
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
 		return
 	}
-	var movie Movie
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	err = NetflixCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&movie)
+	movie, err := c.Movies.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
 	if err != nil {
-		http.Error(w, "Movie not found: "+err.Error(), http.StatusNotFound)
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	if movie, err = c.withWatched(ctx, movie); err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
 		return
 	}
+	setETag(w, movie.Version)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Movie retrieved successfully",
 		"data":    movie,
 	})
 }
 
-func CreateMovie(w http.ResponseWriter, r *http.Request) {
+// CreateMovie refuses a movie whose title looks like one already in the
+// catalogue with a 409 listing the likely matches, unless ?force=true.
+func (c *Controller) CreateMovie(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	var movie Movie
-	if err := json.NewDecoder(r.Body).Decode(&movie); err != nil {
-		log.Println("Error decoding JSON:", err)
-		http.Error(w, "Error decoding JSON: "+err.Error(), http.StatusBadRequest)
+	force, err := parseBoolParam(r.URL.Query(), "force")
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
 		return
 	}
-	if movie.Movie == "" {
-		http.Error(w, "Missing required field: movie", http.StatusBadRequest)
+	var movie Movie
+	if err := bindJSON(w, r, &movie, nil); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	movie.ID = primitive.NewObjectID()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	_, err := NetflixCollection.InsertOne(ctx, movie)
-	if err != nil {
-		log.Println("Error creating movie:", err)
-		http.Error(w, "Failed to create movie: "+err.Error(), http.StatusInternalServerError)
+	if force == nil || !*force {
+		candidates, err := c.findDuplicates(ctx, movie)
+		if err != nil {
+			writeError(w, r, internalError("Failed to check for duplicate movies", err))
+			return
+		}
+		if len(candidates) > 0 {
+			wl, err := c.watchlist(ctx)
+			if err != nil {
+				writeError(w, r, internalError("Failed to fetch watchlist", err))
+				return
+			}
+			for i := range candidates {
+				wl.apply(&candidates[i])
+			}
+			writeError(w, r, duplicateError(candidates))
+			return
+		}
+	}
+	movie.ID = primitive.NewObjectID()
+	movie.AvgRating, movie.RatingCount = 0, 0
+	movie.Enrichment, movie.DeletedAt = nil, nil
+	movie.Version = 1
+	c.queueEnrichment(&movie, time.Now().UTC())
+	watchlist{}.apply(&movie)
+	if err := c.Movies.Create(ctx, movie); err != nil {
+		writeError(w, r, internalError("Failed to create movie", err))
 		return
 	}
+	c.auditChange(r, ResourceMovie, VerbCreate, movie.ID.Hex(), nil, movie)
+	setETag(w, movie.Version)
+	c.notifyEnricher()
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Movie created successfully",
 		"data":    movie,
 	})
 }
 
-func UpdateMovie(w http.ResponseWriter, r *http.Request) {
+func (c *Controller) UpdateMovie(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 	id, err := primitive.ObjectIDFromHex(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid movie ID: "+err.Error(), http.StatusBadRequest)
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
 		return
 	}
 	var movie Movie
-	if err := json.NewDecoder(r.Body).Decode(&movie); err != nil {
-		log.Println("Error decoding JSON:", err)
-		http.Error(w, "Error decoding JSON: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-	if movie.Movie == "" {
-		http.Error(w, "Missing required field: movie", http.StatusBadRequest)
+	if err := bindJSON(w, r, &movie, nil); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	movie.ID = id
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	result, err := NetflixCollection.ReplaceOne(ctx, bson.M{"_id": id}, movie)
+	current, err := c.Movies.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
 	if err != nil {
-		log.Println("Error updating movie:", err)
-		http.Error(w, "Failed to update movie: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	if err := c.checkIfMatch(w, r, current.Version); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	if result.MatchedCount == 0 {
-		http.Error(w, "Movie not found", http.StatusNotFound)
+	movie.AvgRating, movie.RatingCount = current.AvgRating, current.RatingCount
+	movie.Enrichment = current.Enrichment
+	movie.DeletedAt = nil
+	movie.Version = current.Version
+	movie, err = c.Movies.Replace(ctx, movie)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeError(w, r, versionConflict(r))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to update movie", err))
+		return
+	}
+	c.auditChange(r, ResourceMovie, VerbUpdate, id.Hex(), current, movie)
+	setETag(w, movie.Version)
+	if movie, err = c.withWatched(ctx, movie); err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
 		return
 	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -135,102 +332,362 @@ func UpdateMovie(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func DeleteAMovie(w http.ResponseWriter, r *http.Request) {
+func (c *Controller) PatchMovie(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 	id, err := primitive.ObjectIDFromHex(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid movie ID: "+err.Error(), http.StatusBadRequest)
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	result, err := NetflixCollection.DeleteOne(ctx, bson.M{"_id": id})
+	current, err := c.Movies.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
 	if err != nil {
-		log.Println("Error deleting movie:", err)
-		http.Error(w, "Failed to delete movie: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	if err := c.checkIfMatch(w, r, current.Version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// The caller's own watch state is patched too, so a movie sent back as
+	// fetched passes the immutable check.
+	if current, err = c.withWatched(ctx, current); err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
 		return
 	}
-	if result.DeletedCount == 0 {
-		http.Error(w, "Movie not found", http.StatusNotFound)
+	var patched Movie
+	update, err := bindPatch(w, r, current, &patched, "_id", "avgRating", "ratingCount", "enrichment", "deletedAt", "version", "watched", "watchCount", "lastWatchedAt")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	movie := current
+	if !update.IsEmpty() {
+		update.Version = &current.Version
+		movie, err = c.Movies.Patch(ctx, id, update)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, r, notFound("Movie not found"))
+			return
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			writeError(w, r, versionConflict(r))
+			return
+		}
+		if err != nil {
+			writeError(w, r, internalError("Failed to update movie", err))
+			return
+		}
+	}
+	c.auditChange(r, ResourceMovie, VerbPatch, id.Hex(), current, movie)
+	setETag(w, movie.Version)
+	if movie, err = c.withWatched(ctx, movie); err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
 		return
 	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Movie deleted successfully",
+		"message": "Movie updated successfully",
+		"data":    movie,
 	})
 }
 
-func GetMyAllMovies(w http.ResponseWriter, r *http.Request) {
+// DeleteAMovie moves the movie to the trash. Its watch history, reviews,
+// playlist entries and picks are kept until it is purged, so restoring it
+// loses nothing.
+func (c *Controller) DeleteAMovie(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	var movies []Movie
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	vars := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(vars["id"])
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	cursor, err := NetflixCollection.Find(ctx, bson.M{"watched": true})
+	movie, err := c.Movies.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
 	if err != nil {
-		log.Println("Error fetching watched movies:", err)
-		http.Error(w, "Failed to fetch watched movies: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to fetch movie", err))
 		return
 	}
-	defer cursor.Close(ctx)
-	for cursor.Next(ctx) {
-		var movie Movie
-		if err := cursor.Decode(&movie); err != nil {
-			log.Println("Error decoding movie:", err)
-			http.Error(w, "Failed to decode movie: "+err.Error(), http.StatusInternalServerError)
+	if err := c.checkIfMatch(w, r, movie.Version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = c.Movies.Delete(ctx, id, time.Now().UTC(), &movie.Version)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeError(w, r, versionConflict(r))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to delete movie", err))
+		return
+	}
+	c.auditChange(r, ResourceMovie, VerbDelete, id.Hex(), movie, nil)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Movie moved to the trash",
+	})
+}
+
+// withWatched fills in the caller-specific fields of movie.
+func (c *Controller) withWatched(ctx context.Context, movie Movie) (Movie, error) {
+	wl, err := c.watchlist(ctx)
+	if err != nil {
+		return Movie{}, err
+	}
+	wl.apply(&movie)
+	return movie, nil
+}
+
+// GetMyAllMovies lists the movies on the caller's own watchlist. With
+// ?since= (and optionally ?until=) it instead lists the movies the caller
+// watched in that window, whether or not they are still on the watchlist.
+func (c *Controller) GetMyAllMovies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	opts, err := parseListOptions(q, movieSortFields)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	since, until, err := parseTimeWindow(q)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	wl, err := c.watchlist(r.Context())
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
+		return
+	}
+	filter := MovieFilter{IDs: wl.watchedIDs()}
+	if since != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		var end time.Time
+		if until != nil {
+			end = *until
+		}
+		if filter.IDs, err = c.Watches.WatchedBetween(ctx, subject, *since, end); err != nil {
+			writeError(w, r, internalError("Failed to fetch watch history", err))
 			return
 		}
-		movies = append(movies, movie)
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Watched movies retrieved successfully",
-		"data":    movies,
+	c.listMovies(w, r, filter, opts, wl, "Watched movies retrieved successfully")
+}
+
+// watchState returns subject's watch state for movieID, or nil when they
+// have no history with it.
+func (c *Controller) watchState(ctx context.Context, subject string, movieID primitive.ObjectID) (*WatchState, error) {
+	states, err := c.Watches.FindStates(ctx, WatchStateFilter{
+		Subjects: []string{subject},
+		MovieIDs: []primitive.ObjectID{movieID},
 	})
+	if err != nil || len(states) == 0 {
+		return nil, err
+	}
+	return &states[0], nil
 }
 
-func MarkAsWatched(w http.ResponseWriter, r *http.Request) {
+// MarkAsWatched records a viewing of a movie by the caller and puts it on
+// their watchlist. Marking a movie again counts as a rewatch.
+func (c *Controller) MarkAsWatched(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 	vars := mux.Vars(r)
 	id, err := primitive.ObjectIDFromHex(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid movie ID: "+err.Error(), http.StatusBadRequest)
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	result, err := NetflixCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"watched": true}})
+	movie, err := c.Movies.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
 	if err != nil {
-		log.Println("Error marking movie as watched:", err)
-		http.Error(w, "Failed to mark movie as watched: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to fetch movie", err))
 		return
 	}
-	if result.MatchedCount == 0 {
-		http.Error(w, "Movie not found", http.StatusNotFound)
+	before, err := c.watchState(ctx, subject, id)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch watch state", err))
 		return
 	}
-	var movie Movie
-	err = NetflixCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&movie)
+	state, err := c.Watches.Record(ctx, subject, id, time.Now().UTC())
 	if err != nil {
-		log.Println("Error fetching updated movie:", err)
-		http.Error(w, "Movie not found: "+err.Error(), http.StatusNotFound)
+		writeError(w, r, internalError("Failed to mark movie as watched", err))
 		return
 	}
+	c.auditChange(r, ResourceWatch, VerbMark, id.Hex(), before, state)
+	watchlist{id: state}.apply(&movie)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Movie marked as watched",
 		"data":    movie,
 	})
 }
 
-func DeleteAllMoviesHandler(w http.ResponseWriter, r *http.Request) {
+// UnmarkAsWatched takes a movie off the caller's watchlist. Its watch
+// history is kept.
+func (c *Controller) UnmarkAsWatched(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(vars["id"])
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	result, err := NetflixCollection.DeleteMany(ctx, bson.M{})
+	movie, err := c.Movies.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
 	if err != nil {
-		log.Println("Error deleting all movies:", err)
-		http.Error(w, "Failed to delete all movies: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	before, err := c.watchState(ctx, subject, id)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch watch state", err))
+		return
+	}
+	err = c.Watches.Unmark(ctx, subject, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie is not on your watchlist"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to unmark movie", err))
+		return
+	}
+	if before != nil {
+		after := *before
+		after.Watched = false
+		c.auditChange(r, ResourceWatch, VerbUnmark, id.Hex(), before, after)
+	}
+	if movie, err = c.withWatched(ctx, movie); err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
 		return
 	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": fmt.Sprintf("Deleted %d movies successfully", result.DeletedCount),
+		"message": "Movie removed from your watchlist",
+		"data":    movie,
 	})
-}
\ No newline at end of file
+}
+
+// DeleteAllMoviesHandler moves every movie to the trash, all with the
+// same deletion time. It needs a confirmation token; see confirmBulk.
+func (c *Controller) DeleteAllMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !c.confirmBulk(w, r, AuditMoviesDeletedAll, c.previewAllMovies) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	deleted, err := c.Movies.DeleteAll(ctx, time.Now().UTC())
+	if err != nil {
+		writeError(w, r, internalError("Failed to delete all movies", err))
+		return
+	}
+	c.record(r, AuditEvent{
+		Action:   AuditMoviesDeletedAll,
+		Resource: ResourceMovie,
+		Detail:   fmt.Sprintf("moved %d movies to the trash", deleted),
+	})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("Moved %d movies to the trash", deleted),
+	})
+}
+
+// previewAllMovies is the preview confirmBulk shows before every movie is
+// deleted.
+func (c *Controller) previewAllMovies(ctx context.Context) (int64, interface{}, error) {
+	movies, total, err := c.Movies.List(ctx, MovieFilter{}, ListOptions{
+		Limit: confirmationSampleSize,
+		Sort:  []SortField{{Field: "_id"}},
+	})
+	return total, movies, err
+}
+
+// queueEnrichment marks a new movie for enrichment, when it is enabled.
+func (c *Controller) queueEnrichment(movie *Movie, now time.Time) {
+	if c.Enricher != nil {
+		movie.Enrichment = pendingEnrichment(now)
+	}
+}
+
+// notifyEnricher wakes the enrichment workers, when enrichment is enabled.
+func (c *Controller) notifyEnricher() {
+	if c.Enricher != nil {
+		c.Enricher.Notify()
+	}
+}
+
+// EnrichMovie queues a movie for another metadata lookup, for example after
+// one failed or found nothing. Fields that are already set are kept.
+func (c *Controller) EnrichMovie(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if c.Enricher == nil {
+		writeError(w, r, &Error{Status: http.StatusServiceUnavailable, Code: CodeUnavailable, Message: "Metadata enrichment is not configured on this server"})
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(vars["id"])
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	movie, err := c.Movies.Patch(ctx, id, Update{Set: map[string]interface{}{
+		"enrichment": pendingEnrichment(time.Now().UTC()),
+	}})
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to queue movie for enrichment", err))
+		return
+	}
+	c.auditResource(r, ResourceMovie, VerbEnrich, id.Hex(), "queued for a metadata lookup")
+	c.notifyEnricher()
+	if movie, err = c.withWatched(ctx, movie); err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Movie queued for enrichment",
+		"data":    movie,
+	})
+}
@@ -0,0 +1,116 @@
+package controller_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+func TestMovieCRUD(t *testing.T) {
+	h := newTestAPI(memoryStores())
+	steps := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		header  []string
+		status  int
+		want    *controller.Movie
+		problem string
+	}{
+		{name: "create", method: "POST", path: "/api/movie", body: `{"movie":"Heat","year":1995,"genres":["Crime"]}`, status: http.StatusOK,
+			want: &controller.Movie{Movie: "Heat", Year: 1995, Genres: []string{"crime"}, Version: 1}},
+		{name: "create a likely duplicate", method: "POST", path: "/api/movie", body: `{"movie":"heat","year":1995}`, status: http.StatusConflict, problem: controller.CodeDuplicate},
+		{name: "get", method: "GET", path: "/api/movie/{id}", status: http.StatusOK,
+			want: &controller.Movie{Movie: "Heat", Year: 1995, Genres: []string{"crime"}, Version: 1}},
+		{name: "get with a malformed id", method: "GET", path: "/api/movie/heat", status: http.StatusBadRequest, problem: controller.CodeInvalidID},
+		{name: "get a missing movie", method: "GET", path: "/api/movie/" + fixedID(99).Hex(), status: http.StatusNotFound, problem: controller.CodeNotFound},
+		{name: "replace", method: "PUT", path: "/api/movie/{id}", body: `{"movie":"Heat","year":1995,"runtime":170}`, status: http.StatusOK,
+			want: &controller.Movie{Movie: "Heat", Year: 1995, Runtime: 170, Version: 2}},
+		{name: "delete", method: "DELETE", path: "/api/movie/{id}", status: http.StatusOK},
+		{name: "get deleted", method: "GET", path: "/api/movie/{id}", status: http.StatusNotFound, problem: controller.CodeNotFound},
+	}
+	var id string
+	for _, step := range steps {
+		rec := request(h, step.method, strings.Replace(step.path, "{id}", id, 1), step.body, step.header...)
+		if step.problem != "" {
+			if p := decodeProblem(t, rec, step.status); p.Code != step.problem {
+				t.Errorf("%s: code %q, want %q", step.name, p.Code, step.problem)
+			}
+			continue
+		}
+		if rec.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		if step.want == nil {
+			continue
+		}
+		var body struct{ Data controller.Movie }
+		decode(t, rec, &body)
+		got := body.Data
+		if id == "" {
+			id = got.ID.Hex()
+		}
+		if got.ID.Hex() != id {
+			t.Errorf("%s: _id %s, want %s", step.name, got.ID.Hex(), id)
+		}
+		got.ID, got.Enrichment = step.want.ID, nil
+		if !reflect.DeepEqual(got, *step.want) {
+			t.Errorf("%s: movie %+v, want %+v", step.name, got, *step.want)
+		}
+	}
+}
+
+func TestPatchMovie(t *testing.T) {
+	stores := memoryStores()
+	stores.Movies = controller.NewMemoryMovieStore(controller.Movie{ID: fixedID(1), Movie: "Heat", Year: 1995, Genres: []string{"crime"}, Version: 1})
+	h := newTestAPI(stores)
+	merge := []string{"Content-Type", "application/merge-patch+json"}
+	jsonPatch := []string{"Content-Type", "application/json-patch+json"}
+	path := "/api/movie/" + fixedID(1).Hex()
+	steps := []struct {
+		name    string
+		path    string
+		body    string
+		header  []string
+		status  int
+		want    *controller.Movie
+		problem string
+	}{
+		{name: "merge patch", path: path, body: `{"director":"Michael Mann","runtime":170}`, header: merge, status: http.StatusOK,
+			want: &controller.Movie{Movie: "Heat", Year: 1995, Genres: []string{"crime"}, Director: "Michael Mann", Runtime: 170, Version: 2}},
+		{name: "merge patch null deletes", path: path, body: `{"runtime":null}`, header: merge, status: http.StatusOK,
+			want: &controller.Movie{Movie: "Heat", Year: 1995, Genres: []string{"crime"}, Director: "Michael Mann", Version: 3}},
+		{name: "JSON patch", path: path, body: `[{"op":"add","path":"/genres/-","value":"Thriller"}]`, header: jsonPatch, status: http.StatusOK,
+			want: &controller.Movie{Movie: "Heat", Year: 1995, Genres: []string{"crime", "thriller"}, Director: "Michael Mann", Version: 4}},
+		{name: "failed test op", path: path, body: `[{"op":"test","path":"/year","value":1996},{"op":"replace","path":"/year","value":1997}]`, header: jsonPatch,
+			status: http.StatusConflict, problem: controller.CodePatchTestFailed},
+		{name: "invalid result", path: path, body: `{"movie":null}`, header: merge, status: http.StatusBadRequest, problem: controller.CodeValidationFailed},
+		{name: "the watch state", path: path, body: `{"watched":true}`, header: merge, status: http.StatusBadRequest, problem: controller.CodeValidationFailed},
+		{name: "plain JSON", path: path, body: `{"runtime":171}`, status: http.StatusUnsupportedMediaType, problem: controller.CodeUnsupportedMediaType},
+		{name: "a missing movie", path: "/api/movie/" + fixedID(99).Hex(), body: `{"runtime":171}`, header: merge, status: http.StatusNotFound, problem: controller.CodeNotFound},
+	}
+	for _, step := range steps {
+		rec := request(h, "PATCH", step.path, step.body, step.header...)
+		if step.problem != "" {
+			if p := decodeProblem(t, rec, step.status); p.Code != step.problem {
+				t.Errorf("%s: code %q, want %q", step.name, p.Code, step.problem)
+			}
+			continue
+		}
+		if rec.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		var body struct{ Data controller.Movie }
+		decode(t, rec, &body)
+		got := body.Data
+		got.Enrichment = nil
+		step.want.ID = fixedID(1)
+		if !reflect.DeepEqual(got, *step.want) {
+			t.Errorf("%s: movie %+v, want %+v", step.name, got, *step.want)
+		}
+	}
+}
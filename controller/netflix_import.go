@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Netflix's "Viewing Activity" download is a CSV with a Title and a Date
+// column, one row per title watched per day. Series episodes are titled
+// "Show: Season 1: Episode Name". The full account data export names the
+// date column "Start Time" and adds others, which are ignored.
+
+const netflixSource = "netflix"
+
+// ViewingImportOptions tunes ImportViewingActivity.
+type ViewingImportOptions struct {
+	// DayFirst reads slash dates as D/M/Y, as exported outside the US,
+	// instead of M/D/Y.
+	DayFirst bool
+	// DryRun parses and matches the file without writing anything.
+	DryRun bool
+}
+
+// ViewingImportResult reports what an import did, or would do on a dry run.
+type ViewingImportResult struct {
+	DryRun   bool `json:"dryRun"`
+	Received int  `json:"received"`
+	Valid    int  `json:"valid"`
+	Failed   int  `json:"failed"`
+	// MoviesMatched counts distinct titles that were already in the
+	// catalogue; MoviesCreated those added for the import.
+	MoviesMatched int `json:"moviesMatched"`
+	MoviesCreated int `json:"moviesCreated"`
+	// Recorded counts new watch events; Duplicates counts rows already
+	// recorded by an earlier import or earlier in the same file.
+	Recorded   int        `json:"recorded"`
+	Duplicates int        `json:"duplicates"`
+	Errors     []rowError `json:"errors"`
+}
+
+// viewing is one valid row of viewing activity.
+type viewing struct {
+	Title   string
+	Season  string
+	Episode string
+	At      time.Time
+}
+
+// seasonPattern matches the second part of a series title.
+var seasonPattern = regexp.MustCompile(`(?i)^((season|series|part|volume|vol\.|book|collection)\s+[\w.-]+|limited series|miniseries|mini-series)$`)
+
+// splitSeriesTitle splits "Show: Season 1: Episode" into its parts. Titles
+// that do not look like an episode, such as "Mad Max: Fury Road", come
+// back whole as the show with no season or episode.
+func splitSeriesTitle(title string) (show, season, episode string) {
+	parts := strings.Split(title, ": ")
+	if len(parts) >= 3 && (seasonPattern.MatchString(parts[1]) || strings.HasPrefix(parts[1], parts[0]+" ")) {
+		return parts[0], parts[1], strings.Join(parts[2:], ": ")
+	}
+	return title, "", ""
+}
+
+// parseViewingDate reads the date formats Netflix exports: M/D/YY (or
+// D/M/YY with dayFirst), the same with a four-digit year, YYYY-MM-DD, and
+// the "YYYY-MM-DD hh:mm:ss" start times of the full export, all in UTC.
+func parseViewingDate(v string, dayFirst bool) (time.Time, error) {
+	layouts := []string{"1/2/06", "1/2/2006"}
+	if dayFirst {
+		layouts = []string{"2/1/06", "2/1/2006"}
+	}
+	layouts = append(layouts, "2006-01-02", "2006-01-02 15:04:05", time.RFC3339)
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, errors.New("must be a date such as 1/31/24 or 2024-01-31")
+}
+
+// readViewingActivity parses the CSV, calling reject for invalid rows.
+func readViewingActivity(body io.Reader, dayFirst bool, now time.Time, reject func(int, []FieldError)) ([]viewing, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, badRequest(CodeInvalidImport, "CSV body is empty; a Title,Date header row is required")
+	}
+	if err != nil {
+		return nil, badRequest(CodeInvalidImport, "Invalid CSV header: "+err.Error())
+	}
+	titleCol, dateCol := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "title":
+			titleCol = i
+		case "date", "start time":
+			if dateCol < 0 {
+				dateCol = i
+			}
+		}
+	}
+	if titleCol < 0 || dateCol < 0 {
+		return nil, badRequest(CodeInvalidImport, "Netflix viewing activity needs a header row with Title and Date columns")
+	}
+	var rows []viewing
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, badRequest(CodeInvalidImport, fmt.Sprintf("Invalid CSV at row %d: %v", row, parseErr.Err))
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(i int) string {
+			if i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		var fields []FieldError
+		v := viewing{}
+		v.Title, v.Season, v.Episode = splitSeriesTitle(get(titleCol))
+		if v.Title == "" {
+			fields = append(fields, FieldError{Field: "Title", Code: "required", Message: "Title is required"})
+		}
+		if date := get(dateCol); date == "" {
+			fields = append(fields, FieldError{Field: "Date", Code: "required", Message: "Date is required"})
+		} else if v.At, err = parseViewingDate(date, dayFirst); err != nil {
+			fields = append(fields, FieldError{Field: "Date", Code: "type", Message: "Date " + err.Error()})
+		} else if v.At.After(now) {
+			fields = append(fields, FieldError{Field: "Date", Code: "max", Message: "Date must not be in the future"})
+		}
+		if len(fields) == 0 {
+			// The movie the row is recorded against must itself be valid.
+			fields = validateStruct(Movie{Movie: v.Title})
+		}
+		if len(fields) > 0 {
+			reject(row, fields)
+			continue
+		}
+		rows = append(rows, v)
+	}
+}
+
+// ImportViewingActivity records a Netflix viewing activity CSV as subject's
+// watch history. Each row becomes a watch event at its date, against the
+// movie of the same title (ignoring case and spacing), which is created
+// when the catalogue has none; every episode of a series is recorded
+// against one movie named after the show. Rows already imported are
+// skipped, so a fresh download can be imported over an older one.
+func (c *Controller) ImportViewingActivity(ctx context.Context, subject string, body io.Reader, opts ViewingImportOptions) (ViewingImportResult, error) {
+	result := ViewingImportResult{DryRun: opts.DryRun, Errors: []rowError{}}
+	now := time.Now().UTC()
+	rows, err := readViewingActivity(body, opts.DayFirst, now, func(row int, fields []FieldError) {
+		result.Failed++
+		result.Errors = append(result.Errors, rowError{Row: row, Errors: fields})
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Valid = len(rows)
+	result.Received = result.Valid + result.Failed
+	if len(rows) == 0 {
+		return result, nil
+	}
+
+	// Match titles against the catalogue, oldest movie first when several
+	// share a title.
+	ids := map[string]primitive.ObjectID{}
+	for _, v := range rows {
+		ids[titleKey(v.Title)] = primitive.NilObjectID
+	}
+	err = c.Movies.Stream(ctx, MovieFilter{}, ListOptions{Sort: []SortField{{Field: "_id"}}}, func(m Movie) error {
+		if id, ok := ids[titleKey(m.Movie)]; ok && id.IsZero() {
+			ids[titleKey(m.Movie)] = m.ID
+			result.MoviesMatched++
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("matching titles: %w", err)
+	}
+	var created []Movie
+	for _, v := range rows {
+		key := titleKey(v.Title)
+		if !ids[key].IsZero() {
+			continue
+		}
+		movie := Movie{ID: primitive.NewObjectID(), Movie: v.Title, Version: 1}
+		c.queueEnrichment(&movie, now)
+		ids[key] = movie.ID
+		created = append(created, movie)
+	}
+	result.MoviesCreated = len(created)
+	if opts.DryRun {
+		return result, nil
+	}
+
+	for len(created) > 0 {
+		batch := created
+		if len(batch) > importBatchSize {
+			batch = batch[:importBatchSize]
+		}
+		if _, err := c.Movies.InsertMany(ctx, batch); err != nil {
+			return result, fmt.Errorf("creating movies: %w", err)
+		}
+		created = created[len(batch):]
+	}
+	c.notifyEnricher()
+	events := make([]WatchEvent, 0, importBatchSize)
+	for i, v := range rows {
+		events = append(events, WatchEvent{
+			Subject:   subject,
+			MovieID:   ids[titleKey(v.Title)],
+			WatchedAt: v.At,
+			Source:    netflixSource,
+			Season:    v.Season,
+			Episode:   v.Episode,
+		})
+		if len(events) < importBatchSize && i < len(rows)-1 {
+			continue
+		}
+		n, err := c.Watches.Import(ctx, events)
+		result.Recorded += n
+		if err != nil {
+			return result, fmt.Errorf("recording watch events: %w", err)
+		}
+		result.Duplicates += len(events) - n
+		events = events[:0]
+	}
+	return result, nil
+}
+
+// ImportNetflixActivity imports the caller's Netflix viewing activity CSV.
+// ?day_first=true reads D/M/Y dates; ?dry_run=true writes nothing.
+func (c *Controller) ImportNetflixActivity(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	var opts ViewingImportOptions
+	for name, dst := range map[string]*bool{"day_first": &opts.DayFirst, "dry_run": &opts.DryRun} {
+		b, err := parseBoolParam(q, name)
+		if err != nil {
+			writeError(w, r, invalidQuery(err))
+			return
+		}
+		*dst = b != nil && *b
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+	result, err := c.ImportViewingActivity(ctx, subject, http.MaxBytesReader(w, r.Body, maxImportBytes), opts)
+	if !result.DryRun && result.MoviesCreated > 0 {
+		c.auditResource(r, ResourceMovie, VerbImport, "", fmt.Sprintf("created %d movies from Netflix viewing activity", result.MoviesCreated))
+	}
+	if !result.DryRun && result.Recorded > 0 {
+		c.auditResource(r, ResourceWatch, VerbImport, "", fmt.Sprintf("recorded %d watch events from Netflix viewing activity", result.Recorded))
+	}
+	if err != nil {
+		var apiErr *Error
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &apiErr):
+		case errors.As(err, &tooLarge):
+			err = &Error{Status: http.StatusRequestEntityTooLarge, Code: CodeBodyTooLarge, Message: fmt.Sprintf("Import body must not exceed %d bytes", maxImportBytes)}
+		default:
+			err = internalError(fmt.Sprintf("Import failed after recording %d watch events", result.Recorded), err)
+		}
+		writeError(w, r, err)
+		return
+	}
+	message := fmt.Sprintf("Recorded %d watch events (%d already imported)", result.Recorded, result.Duplicates)
+	if result.DryRun {
+		message = fmt.Sprintf("Dry run: %d of %d rows would be imported", result.Valid, result.Received)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"data":    result,
+	})
+}
@@ -0,0 +1,489 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// PATCH bodies are applied to the JSON form of the current document, the
+// result is decoded and validated like a PUT body, and the difference
+// between the two is sent to the store as one atomic update. This relies on
+// our JSON and BSON field names being identical.
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+func invalidPatch(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusUnprocessableEntity, Code: CodeInvalidPatch, Message: fmt.Sprintf(format, args...)}
+}
+
+// bindPatch applies the request's merge patch or JSON patch to current,
+// decodes the result into patched and validates it. Fields named in
+// immutable may not be changed by the patch. It returns the update that
+// turns current into patched.
+func bindPatch(w http.ResponseWriter, r *http.Request, current, patched interface{}, immutable ...string) (Update, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchContentType && mediaType != jsonPatchContentType {
+		return Update{}, &Error{
+			Status:  http.StatusUnsupportedMediaType,
+			Code:    CodeUnsupportedMediaType,
+			Message: fmt.Sprintf("PATCH requires Content-Type %s or %s", mergePatchContentType, jsonPatchContentType),
+		}
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		return Update{}, err
+	}
+	before, err := toGeneric(current)
+	if err != nil {
+		return Update{}, internalError("Failed to prepare document for patching", err)
+	}
+	target, err := toGeneric(current)
+	if err != nil {
+		return Update{}, internalError("Failed to prepare document for patching", err)
+	}
+
+	var after interface{}
+	if mediaType == mergePatchContentType {
+		var patch interface{}
+		if err := unmarshalJSON(body, &patch); err != nil {
+			return Update{}, invalidJSON(err)
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return Update{}, invalidPatch("A merge patch must be a JSON object")
+		}
+		after = mergePatch(target, patch)
+	} else {
+		var ops []patchOp
+		if err := unmarshalJSON(body, &ops); err != nil {
+			return Update{}, invalidJSON(err)
+		}
+		if after, err = applyJSONPatch(target, ops); err != nil {
+			return Update{}, err
+		}
+	}
+
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		return Update{}, internalError("Failed to encode patched document", err)
+	}
+	fields, err := decodeStrict(afterJSON, patched)
+	if err != nil {
+		return Update{}, err
+	}
+	normalize(patched)
+	canonical, err := toGeneric(patched)
+	if err != nil {
+		return Update{}, internalError("Failed to encode patched document", err)
+	}
+	beforeDoc, _ := before.(map[string]interface{})
+	afterDoc, _ := canonical.(map[string]interface{})
+	for _, name := range immutable {
+		if !jsonEqual(beforeDoc[name], afterDoc[name]) {
+			fields = append(fields, FieldError{Field: name, Code: "immutable", Message: name + " cannot be changed"})
+		}
+	}
+	fields = append(fields, validateStruct(patched)...)
+	if len(fields) > 0 {
+		return Update{}, validationFailed(fields)
+	}
+
+	typed, err := toBSON(patched)
+	if err != nil {
+		return Update{}, internalError("Failed to encode patched document", err)
+	}
+	update := Update{Set: map[string]interface{}{}}
+	diffUpdate(beforeDoc, afterDoc, typed, "", &update)
+	return update, nil
+}
+
+func unmarshalJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after the JSON body")
+	}
+	return nil
+}
+
+// toGeneric converts v to the maps, slices and json.Numbers it encodes as.
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = unmarshalJSON(data, &out)
+	return out, err
+}
+
+func toBSON(v interface{}) (bson.M, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out bson.M
+	err = bson.Unmarshal(data, &out)
+	return out, err
+}
+
+// lookupBSON returns the value at a dotted path in doc.
+func lookupBSON(doc interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		switch d := doc.(type) {
+		case bson.M:
+			doc = d[key]
+		case bson.D:
+			doc = d.Map()[key]
+		default:
+			return nil
+		}
+	}
+	return doc
+}
+
+// diffUpdate records in u the $set and $unset operations that turn before
+// into after. Objects present on both sides are compared field by field;
+// anything else that differs is replaced whole, with its value taken from
+// typed so BSON types match the Go model.
+func diffUpdate(before, after map[string]interface{}, typed bson.M, prefix string, u *Update) {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		path := prefix + k
+		bv, inBefore := before[k]
+		av, inAfter := after[k]
+		switch {
+		case !inAfter || av == nil:
+			if inBefore && bv != nil {
+				u.Unset = append(u.Unset, path)
+			}
+		case inBefore && jsonEqual(bv, av):
+		default:
+			bm, beforeIsObject := bv.(map[string]interface{})
+			am, afterIsObject := av.(map[string]interface{})
+			if beforeIsObject && afterIsObject {
+				diffUpdate(bm, am, typed, path+".", u)
+			} else {
+				u.Set[path] = lookupBSON(typed, path)
+			}
+		}
+	}
+}
+
+// mergePatch applies an RFC 7396 JSON merge patch to target.
+func mergePatch(target, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	tm, ok := target.(map[string]interface{})
+	if !ok {
+		tm = map[string]interface{}{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+		} else {
+			tm[k] = mergePatch(tm[k], v)
+		}
+	}
+	return tm
+}
+
+// patchOp is one RFC 6902 JSON Patch operation. Value stays raw so a
+// missing value can be told apart from null.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+func applyJSONPatch(doc interface{}, ops []patchOp) (interface{}, error) {
+	for i, op := range ops {
+		var err error
+		doc, err = applyPatchOp(doc, op)
+		if err != nil {
+			if apiErr, ok := err.(*Error); ok {
+				apiErr.Message = fmt.Sprintf("operation %d (%s %s): %s", i, op.Op, op.Path, apiErr.Message)
+				return nil, apiErr
+			}
+			return nil, invalidPatch("operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyPatchOp(doc interface{}, op patchOp) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("value is required")
+		}
+		if err := unmarshalJSON(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("value: %w", err)
+		}
+	}
+	switch op.Op {
+	case "add":
+		return addAt(doc, path, value)
+	case "remove":
+		doc, _, err := removeAt(doc, path)
+		return doc, err
+	case "replace":
+		if _, err := getAt(doc, path); err != nil {
+			return nil, err
+		}
+		doc, _, err := removeAt(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return addAt(doc, path, value)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		if op.Op == "move" && isPrefix(from, path) && len(from) < len(path) {
+			return nil, fmt.Errorf("cannot move a value into one of its own children")
+		}
+		v, err := getAt(doc, from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		if op.Op == "move" {
+			if doc, _, err = removeAt(doc, from); err != nil {
+				return nil, err
+			}
+		} else if v, err = deepCopy(v); err != nil {
+			return nil, err
+		}
+		return addAt(doc, path, v)
+	case "test":
+		v, err := getAt(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(v, value) {
+			return nil, &Error{Status: http.StatusConflict, Code: CodePatchTestFailed, Message: "test failed: value does not match"}
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into unescaped tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("path %q must start with /", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if i > length || (!allowEnd && i == length) {
+		return 0, fmt.Errorf("index %d is out of range", i)
+	}
+	return i, nil
+}
+
+func getAt(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%q does not exist", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into %q", token)
+		}
+	}
+	return doc, nil
+}
+
+// updateAt rewrites the container holding the last token of path with fn
+// and writes the result back through every ancestor, since inserting into or
+// removing from a slice produces a new slice.
+func updateAt(doc interface{}, path []string, fn func(container interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	child, err := getAt(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	newChild, err := updateAt(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = newChild
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(node), false)
+		node[i] = newChild
+	}
+	return doc, nil
+}
+
+func addAt(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateAt(doc, path, func(container interface{}, key string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			node[key] = value
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("cannot add %q to a scalar", key)
+	})
+}
+
+func removeAt(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	var removed interface{}
+	doc, err := updateAt(doc, path, func(container interface{}, key string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			v, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("%q does not exist", key)
+			}
+			removed = v
+			delete(node, key)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[i]
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove %q from a scalar", key)
+	})
+	return doc, removed, err
+}
+
+func deepCopy(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = unmarshalJSON(data, &out)
+	return out, err
+}
+
+// jsonEqual compares two generic JSON values, treating numbers by value.
+func jsonEqual(a, b interface{}) bool {
+	an, aIsNumber := a.(json.Number)
+	bn, bIsNumber := b.(json.Number)
+	if aIsNumber && bIsNumber {
+		af, aerr := an.Float64()
+		bf, berr := bn.Float64()
+		return aerr == nil && berr == nil && af == bf
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
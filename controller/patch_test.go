@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// describeUpdate renders u as "set a=1 b=[x y]; unset c" for comparison.
+func describeUpdate(u Update) string {
+	var set []string
+	for k, v := range u.Set {
+		set = append(set, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(set)
+	var parts []string
+	if len(set) > 0 {
+		parts = append(parts, "set "+strings.Join(set, " "))
+	}
+	if len(u.Unset) > 0 {
+		parts = append(parts, "unset "+strings.Join(u.Unset, " "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestBindPatch(t *testing.T) {
+	movie := Movie{ID: testID(1), Movie: "Heat", Year: 1995, Genres: []string{"crime", "drama"}, Director: "Michael Mann", Cast: []string{"Al Pacino", "Robert De Niro"}, Version: 3, Watched: true, WatchCount: 2}
+	movieImmutable := []string{"_id", "avgRating", "ratingCount", "enrichment", "deletedAt", "version", "watched", "watchCount", "lastWatchedAt"}
+	course := Course{ID: testID(2), CourseId: "go-101", CourseName: "Go", Price: 10, Author: &Author{Fullname: "Ann"}, Version: 1}
+	courseImmutable := []string{"_id", "courseid", "deletedAt", "version"}
+	const merge, jsonPatch = mergePatchContentType, jsonPatchContentType
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		course      bool
+		want        string
+		status      int
+		code        string
+		fields      []string
+	}{
+		{name: "merge patch sets a field", contentType: merge, body: `{"runtime":170}`, want: "set runtime=170"},
+		{name: "merge patch null deletes a field", contentType: merge, body: `{"director":null}`, want: "unset director"},
+		{name: "merge patch null deletes an array", contentType: merge, body: `{"genres":null,"cast":null}`, want: "unset cast genres"},
+		{name: "merge patch null on a missing field", contentType: merge, body: `{"posterUrl":null}`, want: ""},
+		{name: "merge patch replaces arrays whole", contentType: merge, body: `{"genres":["Thriller"]}`, want: "set genres=[thriller]"},
+		{name: "merge patch of a nested field", contentType: merge, body: `{"author":{"website":"https://ann.example"}}`, course: true, want: "set author.website=https://ann.example"},
+		{name: "merge patch null deletes a nested field", contentType: merge, body: `{"author":{"fullname":null}}`, course: true,
+			status: http.StatusBadRequest, code: CodeValidationFailed, fields: []string{"author.fullname:required"}},
+		{name: "merge patch must be an object", contentType: merge, body: `["movie"]`, status: http.StatusUnprocessableEntity, code: CodeInvalidPatch},
+
+		{name: "add at the end with -", contentType: jsonPatch, body: `[{"op":"add","path":"/genres/-","value":"Thriller"}]`, want: "set genres=[crime drama thriller]"},
+		{name: "add by index", contentType: jsonPatch, body: `[{"op":"add","path":"/genres/1","value":"heist"}]`, want: "set genres=[crime heist drama]"},
+		{name: "add at the length", contentType: jsonPatch, body: `[{"op":"add","path":"/genres/2","value":"heist"}]`, want: "set genres=[crime drama heist]"},
+		{name: "add past the end", contentType: jsonPatch, body: `[{"op":"add","path":"/genres/3","value":"heist"}]`, status: http.StatusUnprocessableEntity, code: CodeInvalidPatch},
+		{name: "add with a leading zero", contentType: jsonPatch, body: `[{"op":"add","path":"/genres/01","value":"heist"}]`, status: http.StatusUnprocessableEntity, code: CodeInvalidPatch},
+		{name: "remove by index", contentType: jsonPatch, body: `[{"op":"remove","path":"/cast/0"}]`, want: "set cast=[Robert De Niro]"},
+		{name: "remove with -", contentType: jsonPatch, body: `[{"op":"remove","path":"/cast/-"}]`, status: http.StatusUnprocessableEntity, code: CodeInvalidPatch},
+		{name: "removing every element unsets the array", contentType: jsonPatch, body: `[{"op":"remove","path":"/genres/1"},{"op":"remove","path":"/genres/0"}]`, want: "unset genres"},
+		{name: "move by index", contentType: jsonPatch, body: `[{"op":"move","from":"/cast/1","path":"/cast/0"}]`, want: "set cast=[Robert De Niro Al Pacino]"},
+		{name: "move to the end with -", contentType: jsonPatch, body: `[{"op":"move","from":"/cast/0","path":"/cast/-"}]`, want: "set cast=[Robert De Niro Al Pacino]"},
+		{name: "move between fields", contentType: jsonPatch, body: `[{"op":"move","from":"/cast/0","path":"/director"}]`, want: "set cast=[Robert De Niro] director=Al Pacino"},
+		{name: "move into its own child", contentType: jsonPatch, body: `[{"op":"move","from":"/cast","path":"/cast/0"}]`, status: http.StatusUnprocessableEntity, code: CodeInvalidPatch},
+		{name: "copy", contentType: jsonPatch, body: `[{"op":"copy","from":"/cast/1","path":"/cast/-"}]`, want: "set cast=[Al Pacino Robert De Niro Robert De Niro]"},
+		{name: "test then replace", contentType: jsonPatch, body: `[{"op":"test","path":"/year","value":1995.0},{"op":"replace","path":"/year","value":1996}]`, want: "set year=1996"},
+		{name: "test of an array element", contentType: jsonPatch, body: `[{"op":"test","path":"/genres/1","value":"drama"},{"op":"remove","path":"/genres/1"}]`, want: "set genres=[crime]"},
+		{name: "failed test", contentType: jsonPatch, body: `[{"op":"test","path":"/year","value":1996},{"op":"replace","path":"/year","value":1997}]`, status: http.StatusConflict, code: CodePatchTestFailed},
+		{name: "test of a missing value", contentType: jsonPatch, body: `[{"op":"test","path":"/runtime","value":0}]`, status: http.StatusUnprocessableEntity, code: CodeInvalidPatch},
+		{name: "replace a missing value", contentType: jsonPatch, body: `[{"op":"replace","path":"/runtime","value":170}]`, status: http.StatusUnprocessableEntity, code: CodeInvalidPatch},
+		{name: "unknown op", contentType: jsonPatch, body: `[{"op":"swap","path":"/year"}]`, status: http.StatusUnprocessableEntity, code: CodeInvalidPatch},
+
+		{name: "the _id is immutable", contentType: jsonPatch, body: fmt.Sprintf(`[{"op":"replace","path":"/_id","value":%q}]`, testID(9).Hex()),
+			status: http.StatusBadRequest, code: CodeValidationFailed, fields: []string{"_id:immutable"}},
+		{name: "the version is immutable", contentType: merge, body: `{"version":9}`, status: http.StatusBadRequest, code: CodeValidationFailed, fields: []string{"version:immutable"}},
+		{name: "the watch state is immutable", contentType: merge, body: `{"watched":false,"watchCount":0}`,
+			status: http.StatusBadRequest, code: CodeValidationFailed, fields: []string{"watched:immutable", "watchCount:immutable"}},
+		{name: "the ratings are immutable", contentType: jsonPatch, body: `[{"op":"replace","path":"/avgRating","value":5}]`,
+			status: http.StatusBadRequest, code: CodeValidationFailed, fields: []string{"avgRating:immutable"}},
+		{name: "immutable fields sent back unchanged", contentType: merge, body: `{"_id":"` + testID(1).Hex() + `","version":3,"watched":true,"watchCount":2,"runtime":170}`, want: "set runtime=170"},
+		{name: "the courseid is immutable", contentType: merge, body: `{"courseid":"go-102"}`, course: true,
+			status: http.StatusBadRequest, code: CodeValidationFailed, fields: []string{"courseid:immutable"}},
+		{name: "the courseid cannot be removed", contentType: jsonPatch, body: `[{"op":"remove","path":"/courseid"}]`, course: true,
+			status: http.StatusBadRequest, code: CodeValidationFailed, fields: []string{"courseid:immutable", "courseid:required"}},
+
+		{name: "plain JSON", contentType: "application/json", body: `{"runtime":170}`, status: http.StatusUnsupportedMediaType, code: CodeUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			var update Update
+			var err error
+			if tt.course {
+				var patched Course
+				update, err = bindPatch(httptest.NewRecorder(), r, course, &patched, courseImmutable...)
+			} else {
+				var patched Movie
+				update, err = bindPatch(httptest.NewRecorder(), r, movie, &patched, movieImmutable...)
+			}
+			if tt.code == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := describeUpdate(update); got != tt.want {
+					t.Errorf("update %q, want %q", got, tt.want)
+				}
+				return
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.Status != tt.status || apiErr.Code != tt.code {
+				t.Fatalf("error %v, want %d %s", err, tt.status, tt.code)
+			}
+			var fields []string
+			for _, f := range apiErr.Fields {
+				fields = append(fields, f.Field+":"+f.Code)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("fields %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
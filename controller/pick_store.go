@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Pick is one movie chosen for a user by the random picker, with the seed
+// that chose it.
+type Pick struct {
+	ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	Subject  string             `json:"subject" bson:"subject"`
+	MovieID  primitive.ObjectID `json:"movieId" bson:"movieId"`
+	Seed     int64              `json:"seed" bson:"seed"`
+	PickedAt time.Time          `json:"pickedAt" bson:"pickedAt"`
+}
+
+// PickStore is the persistence boundary for random picks.
+type PickStore interface {
+	Record(ctx context.Context, pick Pick) error
+	// Recent returns subject's last n picks made before the given time,
+	// newest first. A zero before means no bound.
+	Recent(ctx context.Context, subject string, n int, before time.Time) ([]Pick, error)
+	// BySeed returns subject's earliest pick made with seed, or ErrNotFound.
+	BySeed(ctx context.Context, subject string, seed int64) (Pick, error)
+	// MergeMovie turns every pick of from into a pick of into.
+	MergeMovie(ctx context.Context, from, into primitive.ObjectID) error
+	// DeleteMovie forgets every pick of movieID.
+	DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error
+}
+
+type MongoPickStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoPickStore(coll *mongo.Collection) *MongoPickStore {
+	return &MongoPickStore{coll: coll}
+}
+
+// EnsureIndexes creates the indexes that serve recent picks and lookups by
+// seed.
+func (s *MongoPickStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "subject", Value: 1}, {Key: "pickedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "subject", Value: 1}, {Key: "seed", Value: 1}, {Key: "pickedAt", Value: 1}}},
+	})
+	return err
+}
+
+func (s *MongoPickStore) Record(ctx context.Context, pick Pick) error {
+	_, err := s.coll.InsertOne(ctx, pick)
+	return err
+}
+
+func (s *MongoPickStore) Recent(ctx context.Context, subject string, n int, before time.Time) ([]Pick, error) {
+	filter := bson.M{"subject": subject}
+	if !before.IsZero() {
+		filter["pickedAt"] = bson.M{"$lt": before}
+	}
+	cursor, err := s.coll.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "pickedAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	picks := []Pick{}
+	if err := cursor.All(ctx, &picks); err != nil {
+		return nil, err
+	}
+	return picks, nil
+}
+
+func (s *MongoPickStore) BySeed(ctx context.Context, subject string, seed int64) (Pick, error) {
+	var pick Pick
+	err := s.coll.FindOne(ctx, bson.M{"subject": subject, "seed": seed},
+		options.FindOne().SetSort(bson.D{{Key: "pickedAt", Value: 1}}),
+	).Decode(&pick)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Pick{}, ErrNotFound
+	}
+	return pick, err
+}
+
+func (s *MongoPickStore) MergeMovie(ctx context.Context, from, into primitive.ObjectID) error {
+	_, err := s.coll.UpdateMany(ctx, bson.M{"movieId": from}, bson.M{"$set": bson.M{"movieId": into}})
+	return err
+}
+
+func (s *MongoPickStore) DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error {
+	_, err := s.coll.DeleteMany(ctx, bson.M{"movieId": movieID})
+	return err
+}
+
+// MemoryPickStore keeps picks in process memory and is safe for concurrent
+// use.
+type MemoryPickStore struct {
+	mu    sync.RWMutex
+	picks []Pick
+}
+
+func NewMemoryPickStore() *MemoryPickStore {
+	return &MemoryPickStore{}
+}
+
+func (s *MemoryPickStore) Record(ctx context.Context, pick Pick) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.picks = append(s.picks, pick)
+	return nil
+}
+
+func (s *MemoryPickStore) Recent(ctx context.Context, subject string, n int, before time.Time) ([]Pick, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	picks := []Pick{}
+	for _, pick := range s.picks {
+		if pick.Subject == subject && (before.IsZero() || pick.PickedAt.Before(before)) {
+			picks = append(picks, pick)
+		}
+	}
+	sort.SliceStable(picks, func(i, j int) bool {
+		if !picks[i].PickedAt.Equal(picks[j].PickedAt) {
+			return picks[i].PickedAt.After(picks[j].PickedAt)
+		}
+		return compareValues(picks[i].ID, picks[j].ID) > 0
+	})
+	if len(picks) > n {
+		picks = picks[:n]
+	}
+	return picks, nil
+}
+
+func (s *MemoryPickStore) BySeed(ctx context.Context, subject string, seed int64) (Pick, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found *Pick
+	for i, pick := range s.picks {
+		if pick.Subject == subject && pick.Seed == seed && (found == nil || pick.PickedAt.Before(found.PickedAt)) {
+			found = &s.picks[i]
+		}
+	}
+	if found == nil {
+		return Pick{}, ErrNotFound
+	}
+	return *found, nil
+}
+
+func (s *MemoryPickStore) MergeMovie(ctx context.Context, from, into primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.picks {
+		if s.picks[i].MovieID == from {
+			s.picks[i].MovieID = into
+		}
+	}
+	return nil
+}
+
+func (s *MemoryPickStore) DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	picks := s.picks[:0]
+	for _, pick := range s.picks {
+		if pick.MovieID != movieID {
+			picks = append(picks, pick)
+		}
+	}
+	s.picks = picks
+	return nil
+}
@@ -0,0 +1,548 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxPlaylistEntries caps the length of a playlist or queue. Entries whose
+// movie is in the trash do not count: they are kept, hidden, so that a
+// restore brings them back, and are removed only when the movie is purged,
+// which never happens when trash retention is off.
+const maxPlaylistEntries = 1000
+
+type playlistRequest struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=1000"`
+}
+
+type playlistEntryRequest struct {
+	MovieID string `json:"movieId" validate:"required"`
+	// Position is the zero-based index to insert at; omitted appends.
+	Position *int `json:"position"`
+}
+
+type playlistPositionRequest struct {
+	Position *int `json:"position" validate:"required"`
+}
+
+// playlistResolver finds the playlist a request acts on and checks that
+// the caller owns it.
+type playlistResolver func(ctx context.Context, r *http.Request, subject string) (Playlist, error)
+
+// playlistFromPath resolves the {id} route variable to one of the caller's
+// playlists. Other users' playlists are reported as not found.
+func (c *Controller) playlistFromPath(ctx context.Context, r *http.Request, subject string) (Playlist, error) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		return Playlist{}, badRequest(CodeInvalidID, "Invalid playlist ID")
+	}
+	playlist, err := c.Playlists.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && (playlist.Owner != subject || playlist.Kind != PlaylistKindPlaylist)) {
+		return Playlist{}, notFound("Playlist not found")
+	}
+	if err != nil {
+		return Playlist{}, internalError("Failed to fetch playlist", err)
+	}
+	return playlist, nil
+}
+
+// callerQueue resolves to the caller's watch-next queue.
+func (c *Controller) callerQueue(ctx context.Context, r *http.Request, subject string) (Playlist, error) {
+	queue, err := c.Playlists.Queue(ctx, subject, time.Now().UTC())
+	if err != nil {
+		return Playlist{}, internalError("Failed to fetch queue", err)
+	}
+	return queue, nil
+}
+
+// withEntryMovies fills in the movie of every entry, annotated with the
+// caller's watch state. Entries whose movie is in the trash are left out;
+// they come back if it is restored.
+func (c *Controller) withEntryMovies(ctx context.Context, playlist *Playlist) error {
+	if len(playlist.Entries) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, len(playlist.Entries))
+	for i, entry := range playlist.Entries {
+		ids[i] = entry.MovieID
+	}
+	movies, _, err := c.Movies.List(ctx, MovieFilter{IDs: ids}, ListOptions{})
+	if err != nil {
+		return err
+	}
+	wl, err := c.watchlist(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[primitive.ObjectID]*Movie, len(movies))
+	for i := range movies {
+		wl.apply(&movies[i])
+		byID[movies[i].ID] = &movies[i]
+	}
+	entries := playlist.Entries[:0]
+	for _, entry := range playlist.Entries {
+		if entry.Movie = byID[entry.MovieID]; entry.Movie != nil {
+			entries = append(entries, entry)
+		}
+	}
+	playlist.Entries = entries
+	return nil
+}
+
+// hiddenEntries returns the movies of a playlist's entries that are in the
+// trash, whose entries withEntryMovies leaves out.
+func (c *Controller) hiddenEntries(ctx context.Context, playlist Playlist) (map[primitive.ObjectID]bool, error) {
+	hidden := map[primitive.ObjectID]bool{}
+	if len(playlist.Entries) == 0 {
+		return hidden, nil
+	}
+	ids := make([]primitive.ObjectID, len(playlist.Entries))
+	for i, entry := range playlist.Entries {
+		ids[i] = entry.MovieID
+		hidden[entry.MovieID] = true
+	}
+	movies, _, err := c.Movies.List(ctx, MovieFilter{IDs: ids}, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, movie := range movies {
+		delete(hidden, movie.ID)
+	}
+	return hidden, nil
+}
+
+// visibleIndexes returns the index in entries of each entry that is not
+// hidden. Positions in requests count visible entries only and are
+// translated through it.
+func visibleIndexes(entries []PlaylistEntry, hidden map[primitive.ObjectID]bool) []int {
+	var indexes []int
+	for i, entry := range entries {
+		if !hidden[entry.MovieID] {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// storedIndex is where an entry goes in entries to appear at visible
+// position at, which must be at most len(visible).
+func storedIndex(entries []PlaylistEntry, visible []int, at int) int {
+	if at == len(visible) {
+		return len(entries)
+	}
+	return visible[at]
+}
+
+// entryIndex returns the position of movieID in entries, or -1.
+func entryIndex(entries []PlaylistEntry, movieID primitive.ObjectID) int {
+	for i, entry := range entries {
+		if entry.MovieID == movieID {
+			return i
+		}
+	}
+	return -1
+}
+
+func positionOutOfRange(max int) *Error {
+	return validationFailed([]FieldError{{Field: "position", Code: "range", Message: fmt.Sprintf("position must be between 0 and %d", max)}})
+}
+
+// editEntries applies edit to a playlist's entries, mapping store errors
+// to API errors.
+func (c *Controller) editEntries(ctx context.Context, id primitive.ObjectID, edit func([]PlaylistEntry) ([]PlaylistEntry, error)) (Playlist, error) {
+	playlist, err := c.Playlists.EditEntries(ctx, id, time.Now().UTC(), edit)
+	var apiErr *Error
+	switch {
+	case err == nil, errors.As(err, &apiErr):
+		return playlist, err
+	case errors.Is(err, ErrNotFound):
+		return Playlist{}, notFound("Playlist not found")
+	case errors.Is(err, ErrEditConflict):
+		return Playlist{}, &Error{Status: http.StatusConflict, Code: CodeConflict, Message: "The playlist is being changed by another request; try again"}
+	}
+	return Playlist{}, internalError("Failed to update playlist", err)
+}
+
+func (c *Controller) GetMyPlaylists(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	opts, err := parseListOptions(q, playlistSortFields)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	keyset := usesCursor(q)
+	fetch := opts
+	if keyset {
+		fetch.Limit++
+	}
+	playlists, total, err := c.Playlists.List(ctx, subject, fetch)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch playlists", err))
+		return
+	}
+	const message = "Playlists retrieved successfully"
+	if keyset {
+		page, next := cursorPage(playlists, opts.Limit, func(p Playlist) primitive.ObjectID { return p.ID })
+		json.NewEncoder(w).Encode(cursorResponse(r, message, page, total, opts, next))
+		return
+	}
+	json.NewEncoder(w).Encode(listResponse(r, message, playlists, total, opts))
+}
+
+func (c *Controller) CreatePlaylist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req playlistRequest
+	if err := bindJSON(w, r, &req, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	now := time.Now().UTC()
+	playlist := Playlist{
+		ID:          primitive.NewObjectID(),
+		Owner:       subject,
+		Kind:        PlaylistKindPlaylist,
+		Name:        req.Name,
+		Description: req.Description,
+		Entries:     []PlaylistEntry{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if err := c.Playlists.Create(ctx, playlist); err != nil {
+		writeError(w, r, internalError("Failed to create playlist", err))
+		return
+	}
+	c.auditChange(r, ResourcePlaylist, VerbCreate, playlist.ID.Hex(), nil, playlist)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Playlist created successfully",
+		"data":    playlist,
+	})
+}
+
+// GetPlaylist returns one of the caller's playlists with its movies.
+func (c *Controller) GetPlaylist(w http.ResponseWriter, r *http.Request) {
+	c.getPlaylist(w, r, c.playlistFromPath, "Playlist retrieved successfully")
+}
+
+// GetQueue returns the caller's watch-next queue with its movies.
+func (c *Controller) GetQueue(w http.ResponseWriter, r *http.Request) {
+	c.getPlaylist(w, r, c.callerQueue, "Queue retrieved successfully")
+}
+
+func (c *Controller) getPlaylist(w http.ResponseWriter, r *http.Request, resolve playlistResolver, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	playlist, err := resolve(ctx, r, subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := c.withEntryMovies(ctx, &playlist); err != nil {
+		writeError(w, r, internalError("Failed to fetch playlist movies", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"data":    playlist,
+	})
+}
+
+// UpdatePlaylist renames a playlist and replaces its description. Entries
+// are edited through the entry endpoints.
+func (c *Controller) UpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req playlistRequest
+	if err := bindJSON(w, r, &req, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	playlist, err := c.playlistFromPath(ctx, r, subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	renamed, err := c.Playlists.Rename(ctx, playlist.ID, req.Name, req.Description, time.Now().UTC())
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Playlist not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to update playlist", err))
+		return
+	}
+	c.auditChange(r, ResourcePlaylist, VerbUpdate, playlist.ID.Hex(), playlist, renamed)
+	playlist = renamed
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Playlist updated successfully",
+		"data":    playlist,
+	})
+}
+
+func (c *Controller) DeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	playlist, err := c.playlistFromPath(ctx, r, subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = c.Playlists.Delete(ctx, playlist.ID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Playlist not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to delete playlist", err))
+		return
+	}
+	c.auditChange(r, ResourcePlaylist, VerbDelete, playlist.ID.Hex(), playlist, nil)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Playlist deleted successfully",
+	})
+}
+
+func (c *Controller) AddPlaylistEntry(w http.ResponseWriter, r *http.Request) {
+	c.addEntry(w, r, c.playlistFromPath)
+}
+
+func (c *Controller) AddQueueEntry(w http.ResponseWriter, r *http.Request) {
+	c.addEntry(w, r, c.callerQueue)
+}
+
+// addEntry inserts a movie at the requested position, or at the end. A
+// movie appears at most once per playlist.
+func (c *Controller) addEntry(w http.ResponseWriter, r *http.Request, resolve playlistResolver) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req playlistEntryRequest
+	if err := bindJSON(w, r, &req, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	movieID, err := primitive.ObjectIDFromHex(req.MovieID)
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	playlist, err := resolve(ctx, r, subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := c.Movies.Get(ctx, movieID); errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	} else if err != nil {
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	hidden, err := c.hiddenEntries(ctx, playlist)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch playlist movies", err))
+		return
+	}
+	now := time.Now().UTC()
+	edited, err := c.editEntries(ctx, playlist.ID, func(entries []PlaylistEntry) ([]PlaylistEntry, error) {
+		if entryIndex(entries, movieID) >= 0 {
+			return nil, &Error{Status: http.StatusConflict, Code: CodeConflict, Message: "The movie is already in this playlist"}
+		}
+		if len(visibleIndexes(entries, hidden)) >= maxPlaylistEntries {
+			return nil, validationFailed([]FieldError{{Field: "movieId", Code: "max", Message: fmt.Sprintf("a playlist holds at most %d movies", maxPlaylistEntries)}})
+		}
+		at := len(entries)
+		if req.Position != nil {
+			visible := visibleIndexes(entries, hidden)
+			if *req.Position < 0 || *req.Position > len(visible) {
+				return nil, positionOutOfRange(len(visible))
+			}
+			at = storedIndex(entries, visible, *req.Position)
+		}
+		entries = append(entries, PlaylistEntry{})
+		copy(entries[at+1:], entries[at:])
+		entries[at] = PlaylistEntry{MovieID: movieID, AddedAt: now}
+		return entries, nil
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// A movie moved to the trash meanwhile keeps its entry, hidden until it
+	// is restored or purged like any other.
+	c.auditChange(r, ResourcePlaylist, VerbAddEntry, playlist.ID.Hex(), playlist, edited)
+	if err := c.withEntryMovies(ctx, &edited); err != nil {
+		writeError(w, r, internalError("Failed to fetch playlist movies", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Movie added successfully",
+		"data":    edited,
+	})
+}
+
+func (c *Controller) RemovePlaylistEntry(w http.ResponseWriter, r *http.Request) {
+	c.removeEntry(w, r, c.playlistFromPath)
+}
+
+func (c *Controller) RemoveQueueEntry(w http.ResponseWriter, r *http.Request) {
+	c.removeEntry(w, r, c.callerQueue)
+}
+
+func (c *Controller) removeEntry(w http.ResponseWriter, r *http.Request, resolve playlistResolver) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	movieID, err := primitive.ObjectIDFromHex(mux.Vars(r)["movieId"])
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	playlist, err := resolve(ctx, r, subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	edited, err := c.editEntries(ctx, playlist.ID, func(entries []PlaylistEntry) ([]PlaylistEntry, error) {
+		i := entryIndex(entries, movieID)
+		if i < 0 {
+			return nil, notFound("Movie is not in this playlist")
+		}
+		return append(entries[:i], entries[i+1:]...), nil
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	c.auditChange(r, ResourcePlaylist, VerbRemoveEntry, playlist.ID.Hex(), playlist, edited)
+	if err := c.withEntryMovies(ctx, &edited); err != nil {
+		writeError(w, r, internalError("Failed to fetch playlist movies", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Movie removed successfully",
+		"data":    edited,
+	})
+}
+
+func (c *Controller) MovePlaylistEntry(w http.ResponseWriter, r *http.Request) {
+	c.moveEntry(w, r, c.playlistFromPath)
+}
+
+func (c *Controller) MoveQueueEntry(w http.ResponseWriter, r *http.Request) {
+	c.moveEntry(w, r, c.callerQueue)
+}
+
+// moveEntry moves a movie to a new zero-based position among the visible
+// entries, shifting the entries in between.
+func (c *Controller) moveEntry(w http.ResponseWriter, r *http.Request, resolve playlistResolver) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	movieID, err := primitive.ObjectIDFromHex(mux.Vars(r)["movieId"])
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	var req playlistPositionRequest
+	if err := bindJSON(w, r, &req, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	playlist, err := resolve(ctx, r, subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	hidden, err := c.hiddenEntries(ctx, playlist)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch playlist movies", err))
+		return
+	}
+	edited, err := c.editEntries(ctx, playlist.ID, func(entries []PlaylistEntry) ([]PlaylistEntry, error) {
+		from := entryIndex(entries, movieID)
+		if from < 0 || hidden[movieID] {
+			return nil, notFound("Movie is not in this playlist")
+		}
+		visible := visibleIndexes(entries, hidden)
+		to := *req.Position
+		if to < 0 || to >= len(visible) {
+			return nil, positionOutOfRange(len(visible) - 1)
+		}
+		entry := entries[from]
+		entries = append(entries[:from], entries[from+1:]...)
+		at := storedIndex(entries, visibleIndexes(entries, hidden), to)
+		entries = append(entries, PlaylistEntry{})
+		copy(entries[at+1:], entries[at:])
+		entries[at] = entry
+		return entries, nil
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	c.auditChange(r, ResourcePlaylist, VerbMoveEntry, playlist.ID.Hex(), playlist, edited)
+	if err := c.withEntryMovies(ctx, &edited); err != nil {
+		writeError(w, r, internalError("Failed to fetch playlist movies", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Movie moved successfully",
+		"data":    edited,
+	})
+}
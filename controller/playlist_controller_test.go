@@ -0,0 +1,187 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// racingPlaylists lands race as another request's edit while the first
+// edit is in flight, the way a version check fails in Mongo: edit sees the
+// old entries, loses, and is called again with the new ones. With
+// conflict set every edit loses.
+type racingPlaylists struct {
+	controller.PlaylistStore
+	race     func([]controller.PlaylistEntry) []controller.PlaylistEntry
+	conflict bool
+}
+
+func (s *racingPlaylists) EditEntries(ctx context.Context, id primitive.ObjectID, now time.Time, edit func([]controller.PlaylistEntry) ([]controller.PlaylistEntry, error)) (controller.Playlist, error) {
+	if s.conflict {
+		return controller.Playlist{}, controller.ErrEditConflict
+	}
+	if race := s.race; race != nil {
+		s.race = nil
+		current, err := s.Get(ctx, id)
+		if err != nil {
+			return controller.Playlist{}, err
+		}
+		edit(current.Entries)
+		if _, err := s.PlaylistStore.EditEntries(ctx, id, now, func(entries []controller.PlaylistEntry) ([]controller.PlaylistEntry, error) {
+			return race(entries), nil
+		}); err != nil {
+			return controller.Playlist{}, err
+		}
+	}
+	return s.PlaylistStore.EditEntries(ctx, id, now, edit)
+}
+
+// entriesOf returns entries for the movies numbered ids.
+func entriesOf(ids ...int) []controller.PlaylistEntry {
+	entries := []controller.PlaylistEntry{}
+	for _, id := range ids {
+		entries = append(entries, controller.PlaylistEntry{MovieID: fixedID(id)})
+	}
+	return entries
+}
+
+// entryNumbers returns the fixedID numbers of entries' movies.
+func entryNumbers(entries []controller.PlaylistEntry) []int {
+	numbers := []int{}
+	for _, entry := range entries {
+		var n int
+		fmt.Sscanf(entry.MovieID.Hex(), "%x", &n)
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// seededPlaylist stores movies 1 to movies and a playlist of the bootstrap
+// admin, fixedID(100), holding entries.
+func seededPlaylist(t *testing.T, movies int, entries []controller.PlaylistEntry) controller.Stores {
+	t.Helper()
+	stores := memoryStores()
+	var seed []controller.Movie
+	for i := 1; i <= movies; i++ {
+		seed = append(seed, controller.Movie{ID: fixedID(i), Movie: fmt.Sprintf("Movie %d", i), Version: 1})
+	}
+	stores.Movies = controller.NewMemoryMovieStore(seed...)
+	playlist := controller.Playlist{ID: fixedID(100), Owner: "bootstrap", Kind: controller.PlaylistKindPlaylist, Name: "Heist", Entries: entries, Version: 1}
+	if err := stores.Playlists.Create(context.Background(), playlist); err != nil {
+		t.Fatal(err)
+	}
+	return stores
+}
+
+func TestPlaylistEditsUnderConflicts(t *testing.T) {
+	drop := func(id int) func([]controller.PlaylistEntry) []controller.PlaylistEntry {
+		return func(entries []controller.PlaylistEntry) []controller.PlaylistEntry {
+			kept := []controller.PlaylistEntry{}
+			for _, entry := range entries {
+				if entry.MovieID != fixedID(id) {
+					kept = append(kept, entry)
+				}
+			}
+			return kept
+		}
+	}
+	add := func(id int) func([]controller.PlaylistEntry) []controller.PlaylistEntry {
+		return func(entries []controller.PlaylistEntry) []controller.PlaylistEntry {
+			return append(entries, controller.PlaylistEntry{MovieID: fixedID(id)})
+		}
+	}
+	path := "/api/playlist/" + fixedID(100).Hex() + "/entries"
+	tests := []struct {
+		name     string
+		race     func([]controller.PlaylistEntry) []controller.PlaylistEntry
+		conflict bool
+		method   string
+		path     string
+		body     string
+		status   int
+		code     string
+		want     []int
+	}{
+		{name: "add at a position after an earlier entry is removed", race: drop(1),
+			method: "POST", path: path, body: fmt.Sprintf(`{"movieId":%q,"position":1}`, fixedID(4).Hex()),
+			status: http.StatusOK, want: []int{2, 4, 3}},
+		{name: "add a movie another request just added", race: add(4),
+			method: "POST", path: path, body: fmt.Sprintf(`{"movieId":%q}`, fixedID(4).Hex()),
+			status: http.StatusConflict, code: controller.CodeConflict, want: []int{1, 2, 3, 4}},
+		{name: "move after an entry is appended", race: add(4),
+			method: "PUT", path: path + "/" + fixedID(3).Hex() + "/position", body: `{"position":0}`,
+			status: http.StatusOK, want: []int{3, 1, 2, 4}},
+		{name: "move to a position that was removed", race: drop(1),
+			method: "PUT", path: path + "/" + fixedID(3).Hex() + "/position", body: `{"position":2}`,
+			status: http.StatusBadRequest, code: controller.CodeValidationFailed, want: []int{2, 3}},
+		{name: "remove a movie another request just removed", race: drop(2),
+			method: "DELETE", path: path + "/" + fixedID(2).Hex(),
+			status: http.StatusNotFound, code: controller.CodeNotFound, want: []int{1, 3}},
+		{name: "an edit that keeps losing", conflict: true,
+			method: "DELETE", path: path + "/" + fixedID(2).Hex(),
+			status: http.StatusConflict, code: controller.CodeConflict, want: []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := seededPlaylist(t, 4, entriesOf(1, 2, 3))
+			playlists := &racingPlaylists{PlaylistStore: stores.Playlists, race: tt.race, conflict: tt.conflict}
+			stores.Playlists = playlists
+			h := newTestAPI(stores)
+			rec := request(h, tt.method, tt.path, tt.body)
+			if tt.code != "" {
+				if p := decodeProblem(t, rec, tt.status); p.Code != tt.code {
+					t.Errorf("code %q, want %q", p.Code, tt.code)
+				}
+			} else if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			stored, err := playlists.Get(context.Background(), fixedID(100))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entryNumbers(stored.Entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaylistLimitSkipsTrashedMovies(t *testing.T) {
+	var ids []int
+	for i := 1; i <= 1000; i++ {
+		ids = append(ids, i)
+	}
+	stores := seededPlaylist(t, 1002, entriesOf(ids...))
+	h := newTestAPI(stores)
+	if rec := request(h, "DELETE", "/api/movie/"+fixedID(1).Hex(), ""); rec.Code != http.StatusOK {
+		t.Fatalf("deleting a movie: status %d: %s", rec.Code, rec.Body)
+	}
+	path := "/api/playlist/" + fixedID(100).Hex() + "/entries"
+	steps := []struct {
+		movie  int
+		status int
+	}{
+		{1001, http.StatusOK},
+		{1002, http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		rec := request(h, "POST", path, fmt.Sprintf(`{"movieId":%q}`, fixedID(step.movie).Hex()))
+		if rec.Code != step.status {
+			t.Errorf("adding movie %d: status %d, want %d: %s", step.movie, rec.Code, step.status, rec.Body)
+		}
+	}
+	if rec := request(h, "POST", "/api/trash/movie/"+fixedID(1).Hex()+"/restore", ""); rec.Code != http.StatusOK {
+		t.Fatalf("restoring a movie: status %d: %s", rec.Code, rec.Body)
+	}
+	var body struct{ Data controller.Playlist }
+	decode(t, request(h, "GET", "/api/playlist/"+fixedID(100).Hex(), ""), &body)
+	if got := entryNumbers(body.Data.Entries); len(got) != 1001 || got[0] != 1 {
+		t.Errorf("%d entries starting with %v after the restore, want 1001 starting with 1", len(got), got[:1])
+	}
+}
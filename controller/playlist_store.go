@@ -0,0 +1,427 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Playlist kinds. Each user has any number of named playlists and at most
+// one queue, the ordered list of what they want to watch next.
+const (
+	PlaylistKindPlaylist = "playlist"
+	PlaylistKindQueue    = "queue"
+)
+
+// Playlist is an ordered, user-owned list of movies. Version counts the
+// changes to the playlist; entry edits are only saved against the version
+// they were computed from, so concurrent edits never lose each other.
+type Playlist struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	Owner       string             `json:"owner" bson:"owner"`
+	Kind        string             `json:"kind" bson:"kind"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Entries     []PlaylistEntry    `json:"entries" bson:"entries"`
+	Version     int64              `json:"version" bson:"version"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// PlaylistEntry is one movie in a playlist. Movie is filled in for
+// responses and never stored.
+type PlaylistEntry struct {
+	MovieID primitive.ObjectID `json:"movieId" bson:"movieId"`
+	AddedAt time.Time          `json:"addedAt" bson:"addedAt"`
+	Movie   *Movie             `json:"movie,omitempty" bson:"-"`
+}
+
+// mergeEntries points the entry for from at into, or drops it when into is
+// already in entries.
+func mergeEntries(entries []PlaylistEntry, from, into primitive.ObjectID) []PlaylistEntry {
+	listed := false
+	for _, entry := range entries {
+		if entry.MovieID == into {
+			listed = true
+		}
+	}
+	merged := make([]PlaylistEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.MovieID == from {
+			if listed {
+				continue
+			}
+			entry.MovieID, listed = into, true
+		}
+		merged = append(merged, entry)
+	}
+	return merged
+}
+
+// ErrEditConflict is returned when an edit could not be applied because
+// the document kept changing underneath it.
+var ErrEditConflict = errors.New("edit conflict")
+
+// maxEditAttempts bounds how often a version-checked edit is retried.
+const maxEditAttempts = 10
+
+// PlaylistStore is the persistence boundary for playlists and queues.
+type PlaylistStore interface {
+	// List returns one page of owner's playlists, not including the queue.
+	List(ctx context.Context, owner string, opts ListOptions) ([]Playlist, int64, error)
+	Get(ctx context.Context, id primitive.ObjectID) (Playlist, error)
+	// Queue returns owner's queue, creating an empty one on first use.
+	Queue(ctx context.Context, owner string, now time.Time) (Playlist, error)
+	Create(ctx context.Context, playlist Playlist) error
+	// Rename sets the name and description and returns the playlist.
+	Rename(ctx context.Context, id primitive.ObjectID, name, description string, now time.Time) (Playlist, error)
+	// EditEntries replaces the entries with edit's result. edit is given
+	// the current entries and may be called again if another change lands
+	// first; an error from edit aborts the edit and is returned as is.
+	EditEntries(ctx context.Context, id primitive.ObjectID, now time.Time, edit func([]PlaylistEntry) ([]PlaylistEntry, error)) (Playlist, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// MergeMovie replaces from with into in every playlist and queue,
+	// keeping the entry's position, or drops it where into is already
+	// listed.
+	MergeMovie(ctx context.Context, from, into primitive.ObjectID, now time.Time) error
+	// RemoveMovie takes movieID out of every playlist and queue.
+	RemoveMovie(ctx context.Context, movieID primitive.ObjectID) error
+}
+
+type MongoPlaylistStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoPlaylistStore(coll *mongo.Collection) *MongoPlaylistStore {
+	return &MongoPlaylistStore{coll: coll}
+}
+
+// EnsureIndexes creates the index that serves listings by owner, the
+// unique index that keeps one queue per user and the index that finds the
+// playlists holding a movie.
+func (s *MongoPlaylistStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner", Value: 1}, {Key: "kind", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"kind": PlaylistKindQueue}),
+		},
+		{Keys: bson.D{{Key: "entries.movieId", Value: 1}}},
+	})
+	return err
+}
+
+func (s *MongoPlaylistStore) List(ctx context.Context, owner string, opts ListOptions) ([]Playlist, int64, error) {
+	doc := bson.M{"owner": owner, "kind": PlaylistKindPlaylist}
+	total, err := s.coll.CountDocuments(ctx, doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.coll.Find(ctx, pageFilter(doc, opts), findOptions(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+	playlists := []Playlist{}
+	if err := cursor.All(ctx, &playlists); err != nil {
+		return nil, 0, err
+	}
+	return playlists, total, nil
+}
+
+func (s *MongoPlaylistStore) Get(ctx context.Context, id primitive.ObjectID) (Playlist, error) {
+	var playlist Playlist
+	err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&playlist)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Playlist{}, ErrNotFound
+	}
+	return playlist, err
+}
+
+func (s *MongoPlaylistStore) Queue(ctx context.Context, owner string, now time.Time) (Playlist, error) {
+	var queue Playlist
+	err := s.coll.FindOneAndUpdate(ctx,
+		bson.M{"owner": owner, "kind": PlaylistKindQueue},
+		bson.M{"$setOnInsert": bson.M{
+			"_id":       primitive.NewObjectID(),
+			"name":      "Watch next",
+			"entries":   []PlaylistEntry{},
+			"version":   int64(0),
+			"createdAt": now,
+			"updatedAt": now,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&queue)
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent first use created it; read that one.
+		err = s.coll.FindOne(ctx, bson.M{"owner": owner, "kind": PlaylistKindQueue}).Decode(&queue)
+	}
+	return queue, err
+}
+
+func (s *MongoPlaylistStore) Create(ctx context.Context, playlist Playlist) error {
+	if playlist.Entries == nil {
+		playlist.Entries = []PlaylistEntry{}
+	}
+	_, err := s.coll.InsertOne(ctx, playlist)
+	return err
+}
+
+func (s *MongoPlaylistStore) Rename(ctx context.Context, id primitive.ObjectID, name, description string, now time.Time) (Playlist, error) {
+	set := bson.M{"name": name, "updatedAt": now}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if description == "" {
+		update["$unset"] = bson.M{"description": ""}
+	} else {
+		set["description"] = description
+	}
+	var playlist Playlist
+	err := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": id}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&playlist)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Playlist{}, ErrNotFound
+	}
+	return playlist, err
+}
+
+// EditEntries reads the playlist, applies edit and writes the result only
+// if the version is unchanged, retrying from a fresh read otherwise.
+func (s *MongoPlaylistStore) EditEntries(ctx context.Context, id primitive.ObjectID, now time.Time, edit func([]PlaylistEntry) ([]PlaylistEntry, error)) (Playlist, error) {
+	for attempt := 0; attempt < maxEditAttempts; attempt++ {
+		current, err := s.Get(ctx, id)
+		if err != nil {
+			return Playlist{}, err
+		}
+		entries, err := edit(current.Entries)
+		if err != nil {
+			return Playlist{}, err
+		}
+		var playlist Playlist
+		err = s.coll.FindOneAndUpdate(ctx,
+			bson.M{"_id": id, "version": current.Version},
+			bson.M{"$set": bson.M{"entries": entries, "updatedAt": now}, "$inc": bson.M{"version": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&playlist)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		return playlist, err
+	}
+	return Playlist{}, ErrEditConflict
+}
+
+func (s *MongoPlaylistStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MergeMovie edits each playlist that lists from with EditEntries, so it
+// cannot lose a concurrent edit. Playlists deleted meanwhile are skipped.
+func (s *MongoPlaylistStore) MergeMovie(ctx context.Context, from, into primitive.ObjectID, now time.Time) error {
+	cursor, err := s.coll.Find(ctx, bson.M{"entries.movieId": from}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var playlists []Playlist
+	if err := cursor.All(ctx, &playlists); err != nil {
+		return err
+	}
+	for _, playlist := range playlists {
+		_, err := s.EditEntries(ctx, playlist.ID, now, func(entries []PlaylistEntry) ([]PlaylistEntry, error) {
+			return mergeEntries(entries, from, into), nil
+		})
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveMovie pulls the movie atomically and bumps the version, so an
+// entry edit computed before the removal is retried rather than putting
+// the movie back.
+func (s *MongoPlaylistStore) RemoveMovie(ctx context.Context, movieID primitive.ObjectID) error {
+	_, err := s.coll.UpdateMany(ctx,
+		bson.M{"entries.movieId": movieID},
+		bson.M{"$pull": bson.M{"entries": bson.M{"movieId": movieID}}, "$inc": bson.M{"version": 1}},
+	)
+	return err
+}
+
+// MemoryPlaylistStore keeps playlists in process memory and is safe for
+// concurrent use.
+type MemoryPlaylistStore struct {
+	mu        sync.RWMutex
+	playlists []Playlist
+}
+
+func NewMemoryPlaylistStore() *MemoryPlaylistStore {
+	return &MemoryPlaylistStore{}
+}
+
+func playlistField(playlist Playlist, name string) interface{} {
+	switch name {
+	case "_id":
+		return playlist.ID
+	case "name":
+		return playlist.Name
+	case "createdAt":
+		return playlist.CreatedAt
+	case "updatedAt":
+		return playlist.UpdatedAt
+	}
+	return nil
+}
+
+// clone copies a playlist so callers never share its entries slice.
+func (p Playlist) clone() Playlist {
+	p.Entries = append([]PlaylistEntry{}, p.Entries...)
+	return p
+}
+
+func (s *MemoryPlaylistStore) indexOf(id primitive.ObjectID) int {
+	for i, playlist := range s.playlists {
+		if playlist.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *MemoryPlaylistStore) List(ctx context.Context, owner string, opts ListOptions) ([]Playlist, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	playlists := []Playlist{}
+	for _, playlist := range s.playlists {
+		if playlist.Owner == owner && playlist.Kind == PlaylistKindPlaylist {
+			playlists = append(playlists, playlist.clone())
+		}
+	}
+	total := int64(len(playlists))
+	return sortAndPage(playlists, opts, playlistField), total, nil
+}
+
+func (s *MemoryPlaylistStore) Get(ctx context.Context, id primitive.ObjectID) (Playlist, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.indexOf(id); i >= 0 {
+		return s.playlists[i].clone(), nil
+	}
+	return Playlist{}, ErrNotFound
+}
+
+func (s *MemoryPlaylistStore) Queue(ctx context.Context, owner string, now time.Time) (Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, playlist := range s.playlists {
+		if playlist.Owner == owner && playlist.Kind == PlaylistKindQueue {
+			return playlist.clone(), nil
+		}
+	}
+	queue := Playlist{
+		ID:        primitive.NewObjectID(),
+		Owner:     owner,
+		Kind:      PlaylistKindQueue,
+		Name:      "Watch next",
+		Entries:   []PlaylistEntry{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.playlists = append(s.playlists, queue)
+	return queue.clone(), nil
+}
+
+func (s *MemoryPlaylistStore) Create(ctx context.Context, playlist Playlist) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playlists = append(s.playlists, playlist.clone())
+	return nil
+}
+
+func (s *MemoryPlaylistStore) Rename(ctx context.Context, id primitive.ObjectID, name, description string, now time.Time) (Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return Playlist{}, ErrNotFound
+	}
+	p := &s.playlists[i]
+	p.Name, p.Description, p.UpdatedAt = name, description, now
+	p.Version++
+	return p.clone(), nil
+}
+
+// EditEntries holds the lock across the edit, so it never has to retry.
+func (s *MemoryPlaylistStore) EditEntries(ctx context.Context, id primitive.ObjectID, now time.Time, edit func([]PlaylistEntry) ([]PlaylistEntry, error)) (Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return Playlist{}, ErrNotFound
+	}
+	p := &s.playlists[i]
+	entries, err := edit(p.clone().Entries)
+	if err != nil {
+		return Playlist{}, err
+	}
+	p.Entries, p.UpdatedAt = append([]PlaylistEntry{}, entries...), now
+	p.Version++
+	return p.clone(), nil
+}
+
+func (s *MemoryPlaylistStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.playlists = append(s.playlists[:i], s.playlists[i+1:]...)
+	return nil
+}
+
+func (s *MemoryPlaylistStore) MergeMovie(ctx context.Context, from, into primitive.ObjectID, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.playlists {
+		p := &s.playlists[i]
+		if entryIndex(p.Entries, from) >= 0 {
+			p.Entries = mergeEntries(p.Entries, from, into)
+			p.Version++
+			p.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+func (s *MemoryPlaylistStore) RemoveMovie(ctx context.Context, movieID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.playlists {
+		p := &s.playlists[i]
+		entries := []PlaylistEntry{}
+		for _, entry := range p.Entries {
+			if entry.MovieID != movieID {
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) != len(p.Entries) {
+			p.Entries = entries
+			p.Version++
+		}
+	}
+	return nil
+}
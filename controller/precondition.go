@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag is the entity tag of a course or movie at version. It identifies the
+// stored document as edited, not the response: the caller-specific fields
+// of a movie, and its rating, which follows its reviews, can change without
+// it.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", etag(version))
+}
+
+func preconditionFailed() *Error {
+	return &Error{
+		Status:  http.StatusPreconditionFailed,
+		Code:    CodePreconditionFailed,
+		Message: "It has been changed since the version in If-Match; fetch it again and reapply your change",
+	}
+}
+
+// checkIfMatch enforces the If-Match header of a write to a document now at
+// version. The header lists entity tags, one of which must be the
+// document's, or is *. A write without one is let through unless
+// RequireIfMatch is set. When the check fails the current ETag is sent with
+// the error.
+func (c *Controller) checkIfMatch(w http.ResponseWriter, r *http.Request, version int64) error {
+	header := strings.Join(r.Header.Values("If-Match"), ",")
+	if strings.TrimSpace(header) == "" {
+		if !c.RequireIfMatch {
+			return nil
+		}
+		setETag(w, version)
+		return &Error{
+			Status:  http.StatusPreconditionRequired,
+			Code:    CodePreconditionRequired,
+			Message: "Send If-Match with the ETag of the version you are changing",
+		}
+	}
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == current {
+			return nil
+		}
+	}
+	setETag(w, version)
+	return preconditionFailed()
+}
+
+// versionConflict is the error for a write that found the document at
+// another version than the one it was checked against, because another
+// write got in between.
+func versionConflict(r *http.Request) *Error {
+	if r.Header.Get("If-Match") != "" {
+		return preconditionFailed()
+	}
+	return &Error{Status: http.StatusConflict, Code: CodeConflict, Message: "It is being changed by another request; try again"}
+}
@@ -0,0 +1,134 @@
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+type preconditionStep struct {
+	name    string
+	method  string
+	path    string
+	body    string
+	header  []string
+	status  int
+	etag    string
+	problem string
+}
+
+// runPreconditionSteps sends each step and checks its status, problem code
+// and ETag header.
+func runPreconditionSteps(t *testing.T, h http.Handler, steps []preconditionStep) {
+	t.Helper()
+	for _, step := range steps {
+		rec := request(h, step.method, step.path, step.body, step.header...)
+		if step.problem != "" {
+			if p := decodeProblem(t, rec, step.status); p.Code != step.problem {
+				t.Errorf("%s: code %q, want %q", step.name, p.Code, step.problem)
+			}
+		} else if rec.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		if etag := rec.Header().Get("ETag"); etag != step.etag {
+			t.Errorf("%s: ETag %q, want %q", step.name, etag, step.etag)
+		}
+	}
+}
+
+// seededDocuments stores the course go-101 and the movie fixedID(1), both
+// at version 1.
+func seededDocuments() controller.Stores {
+	stores := memoryStores()
+	stores.Courses = controller.NewMemoryCourseStore(controller.Course{ID: fixedID(1), CourseId: "go-101", CourseName: "Go", Price: 10, Author: &controller.Author{Fullname: "Ann"}, Version: 1})
+	stores.Movies = controller.NewMemoryMovieStore(controller.Movie{ID: fixedID(1), Movie: "Heat", Year: 1995, Version: 1})
+	return stores
+}
+
+func TestIfMatch(t *testing.T) {
+	course, movie := "/api/course/go-101", "/api/movie/"+fixedID(1).Hex()
+	body := `{"coursename":"Go","price":20,"author":{"fullname":"Ann"}}`
+	patch := func(ifMatch string) []string {
+		return []string{"Content-Type", "application/merge-patch+json", "If-Match", ifMatch}
+	}
+	runPreconditionSteps(t, newTestAPI(seededDocuments()), []preconditionStep{
+		{name: "get a course", method: "GET", path: course, status: http.StatusOK, etag: `"1"`},
+		{name: "replace at a stale version", method: "PUT", path: course, body: body, header: []string{"If-Match", `"2"`},
+			status: http.StatusPreconditionFailed, etag: `"1"`, problem: controller.CodePreconditionFailed},
+		{name: "patch at stale versions", method: "PATCH", path: course, body: `{"price":30}`, header: patch(`"0", "2"`),
+			status: http.StatusPreconditionFailed, etag: `"1"`, problem: controller.CodePreconditionFailed},
+		{name: "delete at a stale version", method: "DELETE", path: course, header: []string{"If-Match", `"2"`},
+			status: http.StatusPreconditionFailed, etag: `"1"`, problem: controller.CodePreconditionFailed},
+		{name: "replace when one tag matches", method: "PUT", path: course, body: body, header: []string{"If-Match", `"0", "1"`}, status: http.StatusOK, etag: `"2"`},
+		{name: "patch with *", method: "PATCH", path: course, body: `{"price":30}`, header: patch("*"), status: http.StatusOK, etag: `"3"`},
+		{name: "replace without If-Match", method: "PUT", path: course, body: body, status: http.StatusOK, etag: `"4"`},
+		{name: "delete at the current version", method: "DELETE", path: course, header: []string{"If-Match", `"4"`}, status: http.StatusOK},
+
+		{name: "get a movie", method: "GET", path: movie, status: http.StatusOK, etag: `"1"`},
+		{name: "replace a movie at a stale version", method: "PUT", path: movie, body: `{"movie":"Heat","year":1995}`, header: []string{"If-Match", `"2"`},
+			status: http.StatusPreconditionFailed, etag: `"1"`, problem: controller.CodePreconditionFailed},
+		{name: "patch a movie", method: "PATCH", path: movie, body: `{"runtime":170}`, header: patch(`"1"`), status: http.StatusOK, etag: `"2"`},
+		{name: "delete a movie with *", method: "DELETE", path: movie, header: []string{"If-Match", "*"}, status: http.StatusOK},
+	})
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	course, movie := "/api/course/go-101", "/api/movie/"+fixedID(1).Hex()
+	h := newTestAPIWith(seededDocuments(), controller.Options{RequireIfMatch: true})
+	runPreconditionSteps(t, h, []preconditionStep{
+		{name: "replace", method: "PUT", path: course, body: `{"coursename":"Go","price":20,"author":{"fullname":"Ann"}}`,
+			status: http.StatusPreconditionRequired, etag: `"1"`, problem: controller.CodePreconditionRequired},
+		{name: "patch", method: "PATCH", path: movie, body: `{"runtime":170}`, header: []string{"Content-Type", "application/merge-patch+json"},
+			status: http.StatusPreconditionRequired, etag: `"1"`, problem: controller.CodePreconditionRequired},
+		{name: "delete", method: "DELETE", path: movie, status: http.StatusPreconditionRequired, etag: `"1"`, problem: controller.CodePreconditionRequired},
+		{name: "get", method: "GET", path: course, status: http.StatusOK, etag: `"1"`},
+		{name: "create", method: "POST", path: "/api/course", body: `{"courseid":"go-102","coursename":"Go","author":{"fullname":"Ann"}}`, status: http.StatusOK, etag: `"1"`},
+		{name: "replace with If-Match", method: "PUT", path: course, body: `{"coursename":"Go","price":20,"author":{"fullname":"Ann"}}`,
+			header: []string{"If-Match", `"1"`}, status: http.StatusOK, etag: `"2"`},
+	})
+}
+
+func TestReviewKeepsMovieETag(t *testing.T) {
+	movie := "/api/movie/" + fixedID(1).Hex()
+	h := newTestAPI(seededDocuments())
+	runPreconditionSteps(t, h, []preconditionStep{
+		{name: "get", method: "GET", path: movie, status: http.StatusOK, etag: `"1"`},
+		{name: "review", method: "POST", path: movie + "/reviews", body: `{"rating":4}`, status: http.StatusOK},
+		{name: "get after the review", method: "GET", path: movie, status: http.StatusOK, etag: `"1"`},
+		{name: "replace at the version read before the review", method: "PUT", path: movie, body: `{"movie":"Heat","year":1995,"runtime":170}`,
+			header: []string{"If-Match", `"1"`}, status: http.StatusOK, etag: `"2"`},
+	})
+	var body struct{ Data controller.Movie }
+	decode(t, request(h, "GET", movie, ""), &body)
+	if body.Data.AvgRating != 4 || body.Data.RatingCount != 1 || body.Data.Runtime != 170 {
+		t.Errorf("movie %+v, want the review's rating and the replacement's runtime", body.Data)
+	}
+}
+
+func TestMovieRatingWrites(t *testing.T) {
+	ctx := context.Background()
+	store := controller.NewMemoryMovieStore(controller.Movie{ID: fixedID(1), Movie: "Heat", Version: 1})
+	read, err := store.Get(ctx, fixedID(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rated, err := store.SetRating(ctx, read, 4, 1)
+	if err != nil || rated.AvgRating != 4 || rated.RatingCount != 1 || rated.Version != 1 {
+		t.Fatalf("SetRating: %+v, %v, want rating 4 of 1 at version 1", rated, err)
+	}
+	if _, err := store.SetRating(ctx, read, 5, 1); !errors.Is(err, controller.ErrVersionMismatch) {
+		t.Errorf("SetRating from a stale rating: error %v, want ErrVersionMismatch", err)
+	}
+	// read still carries the rating from before SetRating.
+	read.Runtime = 170
+	replaced, err := store.Replace(ctx, read)
+	if err != nil || replaced.AvgRating != 4 || replaced.RatingCount != 1 || replaced.Runtime != 170 || replaced.Version != 2 {
+		t.Errorf("Replace: %+v, %v, want the stored rating kept at version 2", replaced, err)
+	}
+	if _, err := store.SetRating(ctx, controller.Movie{ID: fixedID(2)}, 4, 1); !errors.Is(err, controller.ErrNotFound) {
+		t.Errorf("SetRating of a missing movie: error %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+var courseSortFields = map[string]bool{
+	"_id":             true,
+	"courseid":        true,
+	"coursename":      true,
+	"price":           true,
+	"author.fullname": true,
+}
+
+var movieSortFields = map[string]bool{
+	"_id":         true,
+	"movie":       true,
+	"year":        true,
+	"runtime":     true,
+	"avgRating":   true,
+	"ratingCount": true,
+}
+
+var reviewSortFields = map[string]bool{
+	"_id":       true,
+	"rating":    true,
+	"createdAt": true,
+	"updatedAt": true,
+}
+
+var playlistSortFields = map[string]bool{
+	"_id":       true,
+	"name":      true,
+	"createdAt": true,
+	"updatedAt": true,
+}
+
+// parseListOptions reads ?limit=, ?offset=, ?sort=a,-b and ?cursor=. Only
+// fields in sortable may be sorted on. A cursor walks the listing in _id
+// order, so it cannot be combined with offset or sort.
+func parseListOptions(q url.Values, sortable map[string]bool) (ListOptions, error) {
+	opts := ListOptions{Limit: defaultPageLimit}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return opts, fmt.Errorf("limit must be an integer between 1 and %d", maxPageLimit)
+		}
+		opts.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("offset must be a non-negative integer")
+		}
+		opts.Offset = n
+	}
+	if v := q.Get("sort"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			if !sortable[field] {
+				return opts, fmt.Errorf("cannot sort by %q", field)
+			}
+			opts.Sort = append(opts.Sort, SortField{Field: field, Desc: desc})
+		}
+	}
+	if usesCursor(q) {
+		if opts.Offset != 0 || len(opts.Sort) > 0 {
+			return opts, fmt.Errorf("cursor cannot be combined with offset or sort")
+		}
+		if token := q.Get("cursor"); token != "" {
+			id, err := decodeCursor(token)
+			if err != nil {
+				return opts, err
+			}
+			opts.After = &id
+		}
+	}
+	return opts, nil
+}
+
+// usesCursor reports whether the request asked for keyset pagination. An
+// empty ?cursor= starts from the beginning.
+func usesCursor(q url.Values) bool {
+	return q.Has("cursor")
+}
+
+const cursorPrefix = "id:"
+
+func encodeCursor(id primitive.ObjectID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + id.Hex()))
+}
+
+func decodeCursor(token string) (primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil && strings.HasPrefix(string(raw), cursorPrefix) {
+		if id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(string(raw), cursorPrefix)); err == nil {
+			return id, nil
+		}
+	}
+	return primitive.NilObjectID, fmt.Errorf("cursor is invalid")
+}
+
+// cursorPage trims a page fetched with one extra item and returns the _id to
+// continue after, or nil on the last page.
+func cursorPage[T any](items []T, limit int, id func(T) primitive.ObjectID) ([]T, *primitive.ObjectID) {
+	if len(items) <= limit {
+		return items, nil
+	}
+	items = items[:limit]
+	next := id(items[limit-1])
+	return items, &next
+}
+
+func parseIntParam(q url.Values, name string) (*int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", name)
+	}
+	return &n, nil
+}
+
+func parseBoolParam(q url.Values, name string) (*bool, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+	return &b, nil
+}
+
+// parseTimeParam reads an RFC 3339 timestamp or a YYYY-MM-DD date (midnight
+// UTC).
+func parseTimeParam(q url.Values, name string) (*time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", name)
+}
+
+// parseTimeWindow reads ?since= and ?until=. until requires since and must
+// come after it.
+func parseTimeWindow(q url.Values) (since, until *time.Time, err error) {
+	if since, err = parseTimeParam(q, "since"); err != nil {
+		return nil, nil, err
+	}
+	if until, err = parseTimeParam(q, "until"); err != nil {
+		return nil, nil, err
+	}
+	if until != nil && since == nil {
+		return nil, nil, fmt.Errorf("until requires since")
+	}
+	if until != nil && !until.After(*since) {
+		return nil, nil, fmt.Errorf("until must be after since")
+	}
+	return since, until, nil
+}
+
+func parseCourseFilter(q url.Values) (CourseFilter, error) {
+	filter := CourseFilter{AuthorFullname: q.Get("author.fullname")}
+	var err error
+	if filter.PriceMin, err = parseIntParam(q, "price_min"); err != nil {
+		return filter, err
+	}
+	if filter.PriceMax, err = parseIntParam(q, "price_max"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parseGenreParam reads ?genre=, which may be repeated or comma separated,
+// as lower-case genres.
+func parseGenreParam(q url.Values) []string {
+	var genres []string
+	for _, v := range q["genre"] {
+		for _, genre := range strings.Split(v, ",") {
+			if genre = strings.ToLower(strings.TrimSpace(genre)); genre != "" {
+				genres = append(genres, genre)
+			}
+		}
+	}
+	return genres
+}
+
+// parseMovieFilter reads the movie filters. ?watched= refers to the
+// caller's own watchlist, given as wl. ?genre= takes a comma-separated list
+// and matches any of them; ?year= is shorthand for equal year_min and
+// year_max.
+func parseMovieFilter(q url.Values, wl watchlist) (MovieFilter, error) {
+	filter := MovieFilter{Genres: parseGenreParam(q)}
+	year, err := parseIntParam(q, "year")
+	if err != nil {
+		return filter, err
+	}
+	if filter.YearMin, err = parseIntParam(q, "year_min"); err != nil {
+		return filter, err
+	}
+	if filter.YearMax, err = parseIntParam(q, "year_max"); err != nil {
+		return filter, err
+	}
+	if year != nil {
+		if filter.YearMin != nil || filter.YearMax != nil {
+			return filter, fmt.Errorf("year cannot be combined with year_min or year_max")
+		}
+		filter.YearMin, filter.YearMax = year, year
+	}
+	only, err := parseBoolParam(q, "watched")
+	if err != nil {
+		return filter, err
+	}
+	if only != nil {
+		ids := wl.watchedIDs()
+		if *only {
+			filter.IDs = ids
+		} else {
+			filter.ExcludeIDs = ids
+		}
+	}
+	return filter, nil
+}
+
+// listResponse builds the JSON envelope for one page of a listing, with
+// links to the neighbouring pages that keep every other query parameter.
+func listResponse(r *http.Request, message string, data interface{}, total int64, opts ListOptions) map[string]interface{} {
+	links := map[string]interface{}{"next": nil, "prev": nil}
+	if int64(opts.Offset+opts.Limit) < total {
+		links["next"] = pageURL(r.URL, opts.Limit, opts.Offset+opts.Limit)
+	}
+	if opts.Offset > 0 {
+		prev := opts.Offset - opts.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		links["prev"] = pageURL(r.URL, opts.Limit, prev)
+	}
+	return map[string]interface{}{
+		"message": message,
+		"data":    data,
+		"total":   total,
+		"limit":   opts.Limit,
+		"offset":  opts.Offset,
+		"links":   links,
+	}
+}
+
+// cursorResponse is listResponse for keyset pagination: it carries an opaque
+// nextCursor instead of an offset.
+func cursorResponse(r *http.Request, message string, data interface{}, total int64, opts ListOptions, next *primitive.ObjectID) map[string]interface{} {
+	var nextCursor, nextLink interface{}
+	if next != nil {
+		token := encodeCursor(*next)
+		q := r.URL.Query()
+		q.Set("limit", strconv.Itoa(opts.Limit))
+		q.Set("cursor", token)
+		nextCursor = token
+		nextLink = r.URL.Path + "?" + q.Encode()
+	}
+	return map[string]interface{}{
+		"message":    message,
+		"data":       data,
+		"total":      total,
+		"limit":      opts.Limit,
+		"nextCursor": nextCursor,
+		"links":      map[string]interface{}{"next": nextLink},
+	}
+}
+
+func pageURL(u *url.URL, limit, offset int) string {
+	q := u.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	return u.Path + "?" + q.Encode()
+}
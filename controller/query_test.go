@@ -0,0 +1,201 @@
+package controller_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+// seededCourses stores courses c1 to c5 priced 50 down to 10.
+func seededCourses() controller.Stores {
+	stores := memoryStores()
+	var courses []controller.Course
+	for i := 1; i <= 5; i++ {
+		courses = append(courses, controller.Course{
+			ID:         fixedID(i),
+			CourseId:   "c" + string(rune('0'+i)),
+			CourseName: "Course",
+			Price:      60 - 10*i,
+			Author:     &controller.Author{Fullname: "Ann"},
+			Version:    1,
+		})
+	}
+	stores.Courses = controller.NewMemoryCourseStore(courses...)
+	return stores
+}
+
+type coursePage struct {
+	Data   []controller.Course
+	Total  int64
+	Limit  int
+	Offset int
+	Links  struct {
+		Next *string
+		Prev *string
+	}
+	NextCursor *string
+}
+
+func courseIDs(courses []controller.Course) []string {
+	ids := []string{}
+	for _, course := range courses {
+		ids = append(ids, course.CourseId)
+	}
+	return ids
+}
+
+func TestGetAllCoursesPaging(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		query string
+		ids   []string
+		next  *string
+		prev  *string
+	}{
+		{"", []string{"c1", "c2", "c3", "c4", "c5"}, nil, nil},
+		{"?limit=2", []string{"c1", "c2"}, str("/api/courses?limit=2&offset=2"), nil},
+		{"?limit=2&offset=2", []string{"c3", "c4"}, str("/api/courses?limit=2&offset=4"), str("/api/courses?limit=2&offset=0")},
+		{"?limit=2&offset=4", []string{"c5"}, nil, str("/api/courses?limit=2&offset=2")},
+		{"?offset=9", []string{}, nil, str("/api/courses?limit=50&offset=0")},
+		{"?sort=price&limit=3", []string{"c5", "c4", "c3"}, str("/api/courses?limit=3&offset=3&sort=price"), nil},
+		{"?sort=-courseid&limit=1&offset=1", []string{"c4"}, str("/api/courses?limit=1&offset=2&sort=-courseid"), str("/api/courses?limit=1&offset=0&sort=-courseid")},
+	}
+	h := newTestAPI(seededCourses())
+	for _, tt := range tests {
+		rec := request(h, "GET", "/api/courses"+tt.query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", tt.query, rec.Code, rec.Body)
+		}
+		var page coursePage
+		decode(t, rec, &page)
+		if got := courseIDs(page.Data); !reflect.DeepEqual(got, tt.ids) || page.Total != 5 {
+			t.Errorf("GET %s: %v of %d, want %v of 5", tt.query, got, page.Total, tt.ids)
+		}
+		if !reflect.DeepEqual(page.Links.Next, tt.next) || !reflect.DeepEqual(page.Links.Prev, tt.prev) {
+			t.Errorf("GET %s: links %s, %s, want %s, %s", tt.query, deref(page.Links.Next), deref(page.Links.Prev), deref(tt.next), deref(tt.prev))
+		}
+	}
+}
+
+func TestGetAllCoursesCursor(t *testing.T) {
+	h := newTestAPI(seededCourses())
+	var pages [][]string
+	path := "/api/courses?cursor=&limit=2"
+	for i := 0; path != "" && i < 5; i++ {
+		rec := request(h, "GET", path, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", path, rec.Code, rec.Body)
+		}
+		var page coursePage
+		decode(t, rec, &page)
+		pages = append(pages, courseIDs(page.Data))
+		if (page.NextCursor == nil) != (page.Links.Next == nil) {
+			t.Fatalf("GET %s: nextCursor %v but next link %v", path, page.NextCursor, page.Links.Next)
+		}
+		path = ""
+		if page.Links.Next != nil {
+			path = *page.Links.Next
+		}
+	}
+	want := [][]string{{"c1", "c2"}, {"c3", "c4"}, {"c5"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages %v, want %v", pages, want)
+	}
+}
+
+// seededMovies stores three movies, in _id order Heat, Ronin and Leon.
+func seededMovies() controller.Stores {
+	stores := memoryStores()
+	stores.Movies = controller.NewMemoryMovieStore(
+		controller.Movie{ID: fixedID(1), Movie: "Heat", Year: 1995, Version: 1},
+		controller.Movie{ID: fixedID(2), Movie: "Ronin", Year: 1998, Version: 1},
+		controller.Movie{ID: fixedID(3), Movie: "Leon", Year: 1994, Version: 1},
+	)
+	return stores
+}
+
+// movieTitles fetches a page of seededMovies and returns its titles and
+// next link.
+func movieTitles(t *testing.T, h http.Handler, path string) ([]string, *string) {
+	t.Helper()
+	rec := request(h, "GET", path, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", path, rec.Code, rec.Body)
+	}
+	var page struct {
+		Data  []controller.Movie
+		Total int64
+		Links struct{ Next *string }
+	}
+	decode(t, rec, &page)
+	if page.Total != 3 {
+		t.Errorf("GET %s: total %d, want 3", path, page.Total)
+	}
+	names := []string{}
+	for _, m := range page.Data {
+		names = append(names, m.Movie)
+	}
+	return names, page.Links.Next
+}
+
+func TestGetAllMoviesPaging(t *testing.T) {
+	h := newTestAPI(seededMovies())
+	tests := []struct {
+		path string
+		want []string
+		next string
+	}{
+		{"/api/movies", []string{"Heat", "Ronin", "Leon"}, "<nil>"},
+		{"/api/movies?limit=2", []string{"Heat", "Ronin"}, "/api/movies?limit=2&offset=2"},
+		{"/api/movies?sort=-year&limit=2&offset=1", []string{"Heat", "Leon"}, "<nil>"},
+		{"/api/movies?sort=movie", []string{"Heat", "Leon", "Ronin"}, "<nil>"},
+	}
+	for _, tt := range tests {
+		got, next := movieTitles(t, h, tt.path)
+		if !reflect.DeepEqual(got, tt.want) || deref(next) != tt.next {
+			t.Errorf("GET %s: %v next %s, want %v next %s", tt.path, got, deref(next), tt.want, tt.next)
+		}
+	}
+}
+
+func TestGetAllMoviesCursor(t *testing.T) {
+	h := newTestAPI(seededMovies())
+	var pages [][]string
+	for path := "/api/movies?cursor=&limit=2"; path != "" && len(pages) < 3; {
+		got, next := movieTitles(t, h, path)
+		pages = append(pages, got)
+		path = ""
+		if next != nil {
+			path = *next
+		}
+	}
+	if want := [][]string{{"Heat", "Ronin"}, {"Leon"}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("cursor pages %v, want %v", pages, want)
+	}
+}
+
+func TestListInvalidQuery(t *testing.T) {
+	tests := []string{
+		"/api/courses?limit=0",
+		"/api/courses?limit=1000",
+		"/api/courses?limit=ten",
+		"/api/courses?offset=-1",
+		"/api/courses?sort=author",
+		"/api/movies?sort=director",
+		"/api/movies?sort=year,-cast",
+		"/api/courses?cursor=&offset=2",
+		"/api/courses?cursor=&sort=price",
+		"/api/courses?cursor=not-a-cursor",
+		"/api/movies?cursor=aWQ6bm90LWFuLWlk",
+	}
+	h := newTestAPI(seededCourses())
+	for _, path := range tests {
+		p := decodeProblem(t, request(h, "GET", path, ""), http.StatusBadRequest)
+		if p.Code != controller.CodeInvalidQuery || p.Instance != strings.Split(path, "?")[0] {
+			t.Errorf("GET %s: %+v, want invalid_query", path, p)
+		}
+	}
+}
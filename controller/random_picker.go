@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// unratedPickWeight is the weight of a movie nobody has rated yet when
+	// picks are weighted by rating: the midpoint of the rating scale.
+	unratedPickWeight = 3.0
+	maxExcludeRecent  = 100
+	// maxPickSeed keeps generated seeds exactly representable as
+	// JavaScript numbers.
+	maxPickSeed = 1<<53 - 1
+)
+
+// RandomPick is the movie the picker chose and what it chose from.
+type RandomPick struct {
+	Movie Movie `json:"movie"`
+	// Seed reproduces the pick when passed back as ?seed=.
+	Seed       int64 `json:"seed"`
+	Candidates int   `json:"candidates"`
+	Weighted   bool  `json:"weighted"`
+	// Replay is true when the seed had been used before and the earlier
+	// pick was repeated rather than recorded again.
+	Replay bool `json:"replay"`
+}
+
+// pickWeight is a movie's average rating, or the scale midpoint when it
+// has no ratings, so unrated movies still get picked.
+func pickWeight(movie Movie) float64 {
+	if movie.RatingCount == 0 {
+		return unratedPickWeight
+	}
+	return movie.AvgRating
+}
+
+// pickMovie chooses one of candidates with rng, in proportion to rating
+// when weighted and uniformly otherwise. The same candidates in the same
+// order and the same rng state always give the same movie.
+func pickMovie(candidates []Movie, rng *rand.Rand, weighted bool) Movie {
+	if !weighted {
+		return candidates[rng.Intn(len(candidates))]
+	}
+	var total float64
+	for _, movie := range candidates {
+		total += pickWeight(movie)
+	}
+	target := rng.Float64() * total
+	for _, movie := range candidates {
+		target -= pickWeight(movie)
+		if target < 0 {
+			return movie
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// GetRandomMovie picks a movie the caller has never watched. Optional
+// constraints are ?genre=, ?max_runtime= (minutes), ?min_rating= and
+// ?exclude_recent=N, which skips the caller's last N picks. Picks are
+// weighted by rating unless ?weighted=false.
+//
+// Every pick is recorded with its seed. Passing a seed that was used
+// before replays that pick: the recent picks excluded are the ones made
+// before it, so the same movie comes back as long as the catalogue and
+// the caller's history have not changed. A new seed makes a fresh pick.
+func (c *Controller) GetRandomMovie(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	filter := MovieFilter{Genres: parseGenreParam(q)}
+	if filter.RuntimeMax, err = parseIntParam(q, "max_runtime"); err != nil || (filter.RuntimeMax != nil && *filter.RuntimeMax < 1) {
+		writeError(w, r, invalidQuery(errors.New("max_runtime must be a positive integer")))
+		return
+	}
+	if v := q.Get("min_rating"); v != "" {
+		rating, err := strconv.ParseFloat(v, 64)
+		if err != nil || rating < 0 || rating > 5 {
+			writeError(w, r, invalidQuery(errors.New("min_rating must be a number between 0 and 5")))
+			return
+		}
+		filter.RatingMin = &rating
+	}
+	excludeRecent, err := parseIntParam(q, "exclude_recent")
+	if err != nil || (excludeRecent != nil && (*excludeRecent < 0 || *excludeRecent > maxExcludeRecent)) {
+		writeError(w, r, invalidQuery(fmt.Errorf("exclude_recent must be an integer between 0 and %d", maxExcludeRecent)))
+		return
+	}
+	weighted, err := parseBoolParam(q, "weighted")
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	pick := RandomPick{Weighted: weighted == nil || *weighted, Seed: time.Now().UnixNano() & maxPickSeed}
+	seeded := q.Get("seed") != ""
+	if seeded {
+		if pick.Seed, err = strconv.ParseInt(q.Get("seed"), 10, 64); err != nil {
+			writeError(w, r, invalidQuery(errors.New("seed must be an integer")))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	// before bounds the recent picks to exclude: now, or the time of the
+	// pick being replayed.
+	var before time.Time
+	if seeded {
+		original, err := c.Picks.BySeed(ctx, subject, pick.Seed)
+		switch {
+		case err == nil:
+			pick.Replay, before = true, original.PickedAt
+		case !errors.Is(err, ErrNotFound):
+			writeError(w, r, internalError("Failed to fetch earlier picks", err))
+			return
+		}
+	}
+	wl, err := c.watchlist(ctx)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch watch state", err))
+		return
+	}
+	for id, state := range wl {
+		if hasWatched(state) {
+			filter.ExcludeIDs = append(filter.ExcludeIDs, id)
+		}
+	}
+	if excludeRecent != nil && *excludeRecent > 0 {
+		recent, err := c.Picks.Recent(ctx, subject, *excludeRecent, before)
+		if err != nil {
+			writeError(w, r, internalError("Failed to fetch recent picks", err))
+			return
+		}
+		for _, p := range recent {
+			filter.ExcludeIDs = append(filter.ExcludeIDs, p.MovieID)
+		}
+	}
+	var candidates []Movie
+	err = c.Movies.Stream(ctx, filter, ListOptions{Sort: []SortField{{Field: "_id"}}}, func(movie Movie) error {
+		candidates = append(candidates, movie)
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch movies", err))
+		return
+	}
+	if len(candidates) == 0 {
+		writeError(w, r, notFound("No unwatched movie matches the constraints"))
+		return
+	}
+	pick.Candidates = len(candidates)
+	pick.Movie = pickMovie(candidates, rand.New(rand.NewSource(pick.Seed)), pick.Weighted)
+	wl.apply(&pick.Movie)
+	if !pick.Replay {
+		err := c.Picks.Record(ctx, Pick{
+			ID:       primitive.NewObjectID(),
+			Subject:  subject,
+			MovieID:  pick.Movie.ID,
+			Seed:     pick.Seed,
+			PickedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			writeError(w, r, internalError("Failed to record pick", err))
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Movie picked successfully",
+		"data":    pick,
+	})
+}
@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Recommendations score every movie the caller has no history with
+// against every movie they have watched or rated (a seed). The score is
+// the sum over seeds of the seed's weight times its similarity to the
+// candidate, where similarity adds up shared genres, shared cast and
+// co-watching: how many of the seed's other viewers also watched the
+// candidate. Everything is computed from sorted inputs, so the ranking is
+// the same for the same data.
+const (
+	// genreWeight scales the Jaccard similarity of the genre sets.
+	genreWeight = 1.0
+	// castWeight is added per shared cast member, up to maxSharedCast.
+	castWeight    = 0.5
+	maxSharedCast = 3
+	// coWatchWeight scales the share of a seed's other viewers who also
+	// watched the candidate.
+	coWatchWeight = 2.0
+	// maxCoWatchers bounds how many other users' histories are read.
+	maxCoWatchers = 1000
+	// maxReasons is how many seeds a recommendation is explained by.
+	maxReasons = 3
+
+	defaultRecommendations = 10
+	maxRecommendations     = 50
+)
+
+// Recommendation is one suggested movie and why it was suggested.
+type Recommendation struct {
+	Movie       Movie   `json:"movie"`
+	Score       float64 `json:"score"`
+	Explanation string  `json:"explanation"`
+	// Because lists the caller's movies that contributed most to the
+	// score, strongest first.
+	Because []RecommendationReason `json:"because"`
+}
+
+// RecommendationReason is what a candidate has in common with one of the
+// caller's movies.
+type RecommendationReason struct {
+	MovieID      primitive.ObjectID `json:"movieId"`
+	Movie        string             `json:"movie"`
+	Rating       int                `json:"rating,omitempty"`
+	SharedGenres []string           `json:"sharedGenres,omitempty"`
+	SharedCast   []string           `json:"sharedCast,omitempty"`
+	CoWatchers   int                `json:"coWatchers,omitempty"`
+	Score        float64            `json:"score"`
+}
+
+// recommendationSeed is a movie the caller watched or rated, weighted by
+// how much they liked it.
+type recommendationSeed struct {
+	movie  Movie
+	rating int
+	weight float64
+	// viewers are the other users who watched it.
+	viewers map[string]bool
+}
+
+// seedWeight is 1 for a movie watched but not rated, and scales from 0
+// for one star to 2 for five stars.
+func seedWeight(rating int) float64 {
+	if rating == 0 {
+		return 1
+	}
+	return float64(rating-1) / 2
+}
+
+// roundScore keeps scores to a precision that floating point noise cannot
+// reorder.
+func roundScore(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
+
+// similarity compares a candidate with one seed.
+func similarity(seed recommendationSeed, candidate Movie, candidateViewers map[string]bool) RecommendationReason {
+	reason := RecommendationReason{MovieID: seed.movie.ID, Movie: seed.movie.Movie, Rating: seed.rating}
+	for _, genre := range candidate.Genres {
+		if contains(seed.movie.Genres, genre) {
+			reason.SharedGenres = append(reason.SharedGenres, genre)
+		}
+	}
+	union := len(seed.movie.Genres) + len(candidate.Genres) - len(reason.SharedGenres)
+	var score float64
+	if union > 0 {
+		score += genreWeight * float64(len(reason.SharedGenres)) / float64(union)
+	}
+	for _, name := range candidate.Cast {
+		if contains(seed.movie.Cast, name) {
+			reason.SharedCast = append(reason.SharedCast, name)
+		}
+	}
+	if shared := len(reason.SharedCast); shared > maxSharedCast {
+		score += castWeight * maxSharedCast
+	} else {
+		score += castWeight * float64(shared)
+	}
+	for subject := range seed.viewers {
+		if candidateViewers[subject] {
+			reason.CoWatchers++
+		}
+	}
+	if len(seed.viewers) > 0 {
+		score += coWatchWeight * float64(reason.CoWatchers) / float64(len(seed.viewers))
+	}
+	reason.Score = roundScore(score * seed.weight)
+	return reason
+}
+
+// explain turns the strongest reason into a sentence.
+func explain(movie Movie, reasons []RecommendationReason) string {
+	if len(reasons) == 0 {
+		if movie.RatingCount == 0 {
+			return "Not rated yet; you could be the first"
+		}
+		if movie.RatingCount == 1 {
+			return fmt.Sprintf("Rated %.1f/5 by 1 viewer", movie.AvgRating)
+		}
+		return fmt.Sprintf("Rated %.1f/5 by %d viewers", movie.AvgRating, movie.RatingCount)
+	}
+	top := reasons[0]
+	because := "Because you watched " + top.Movie
+	if top.Rating > 0 {
+		because = fmt.Sprintf("Because you rated %s %d/5", top.Movie, top.Rating)
+	}
+	var details []string
+	if len(top.SharedGenres) > 0 {
+		details = append(details, "same genres: "+strings.Join(top.SharedGenres, ", "))
+	}
+	if len(top.SharedCast) > 0 {
+		details = append(details, "also stars "+strings.Join(top.SharedCast, ", "))
+	}
+	if top.CoWatchers == 1 {
+		details = append(details, "1 other viewer of it watched this too")
+	} else if top.CoWatchers > 1 {
+		details = append(details, fmt.Sprintf("%d other viewers of it watched this too", top.CoWatchers))
+	}
+	return because + " (" + strings.Join(details, "; ") + ")"
+}
+
+// rankRecommendations scores candidates against seeds, best first. Ties
+// are broken by average rating, then number of ratings, then _id.
+func rankRecommendations(seeds []recommendationSeed, candidates []Movie, viewers map[primitive.ObjectID]map[string]bool) []Recommendation {
+	recs := make([]Recommendation, 0, len(candidates))
+	for _, candidate := range candidates {
+		rec := Recommendation{Movie: candidate, Because: []RecommendationReason{}}
+		for _, seed := range seeds {
+			reason := similarity(seed, candidate, viewers[candidate.ID])
+			if reason.Score > 0 {
+				rec.Score += reason.Score
+				rec.Because = append(rec.Because, reason)
+			}
+		}
+		rec.Score = roundScore(rec.Score)
+		sort.SliceStable(rec.Because, func(i, j int) bool { return rec.Because[i].Score > rec.Because[j].Score })
+		if len(rec.Because) > maxReasons {
+			rec.Because = rec.Because[:maxReasons]
+		}
+		rec.Explanation = explain(candidate, rec.Because)
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		a, b := recs[i], recs[j]
+		switch {
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case a.Movie.AvgRating != b.Movie.AvgRating:
+			return a.Movie.AvgRating > b.Movie.AvgRating
+		case a.Movie.RatingCount != b.Movie.RatingCount:
+			return a.Movie.RatingCount > b.Movie.RatingCount
+		}
+		return compareValues(a.Movie.ID, b.Movie.ID) < 0
+	})
+	return recs
+}
+
+// hasWatched reports whether a state records at least one viewing.
+func hasWatched(state WatchState) bool {
+	return state.Watched || state.WatchCount > 0
+}
+
+// GetRecommendations suggests movies the caller has not watched or rated,
+// ranked by similarity to the ones they have. ?limit= caps the number of
+// results (default 10). The whole catalogue is scored on each request.
+func (c *Controller) GetRecommendations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	limit := defaultRecommendations
+	if n, err := parseIntParam(r.URL.Query(), "limit"); err != nil || (n != nil && (*n < 1 || *n > maxRecommendations)) {
+		writeError(w, r, invalidQuery(fmt.Errorf("limit must be an integer between 1 and %d", maxRecommendations)))
+		return
+	} else if n != nil {
+		limit = *n
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	recs, err := c.recommend(ctx, subject)
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute recommendations", err))
+		return
+	}
+	if len(recs) > limit {
+		recs = recs[:limit]
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Recommendations retrieved successfully",
+		"data":    recs,
+	})
+}
+
+func (c *Controller) recommend(ctx context.Context, subject string) ([]Recommendation, error) {
+	states, err := c.Watches.States(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	reviews, err := c.Reviews.BySubject(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	ratings := map[primitive.ObjectID]int{}
+	for _, review := range reviews {
+		ratings[review.MovieID] = review.Rating
+	}
+	seen := map[primitive.ObjectID]bool{}
+	for _, state := range states {
+		seen[state.MovieID] = true
+	}
+	for id := range ratings {
+		seen[id] = true
+	}
+
+	var seeds []recommendationSeed
+	var candidates []Movie
+	err = c.Movies.Stream(ctx, MovieFilter{}, ListOptions{Sort: []SortField{{Field: "_id"}}}, func(movie Movie) error {
+		if !seen[movie.ID] {
+			candidates = append(candidates, movie)
+			return nil
+		}
+		rating := ratings[movie.ID]
+		if weight := seedWeight(rating); weight > 0 {
+			seeds = append(seeds, recommendationSeed{movie: movie, rating: rating, weight: weight, viewers: map[string]bool{}})
+		}
+		return nil
+	})
+	if err != nil || len(seeds) == 0 {
+		return rankRecommendations(nil, candidates, nil), err
+	}
+
+	// Co-watching: find the other users who watched a seed, then what
+	// else they watched.
+	seedIDs := make([]primitive.ObjectID, len(seeds))
+	for i, seed := range seeds {
+		seedIDs[i] = seed.movie.ID
+	}
+	seedStates, err := c.Watches.FindStates(ctx, WatchStateFilter{MovieIDs: seedIDs})
+	if err != nil {
+		return nil, err
+	}
+	var others []string
+	for _, state := range seedStates {
+		if state.Subject == subject || !hasWatched(state) {
+			continue
+		}
+		if len(others) == 0 || others[len(others)-1] != state.Subject {
+			if len(others) == maxCoWatchers {
+				break
+			}
+			others = append(others, state.Subject)
+		}
+		for i := range seeds {
+			if seeds[i].movie.ID == state.MovieID {
+				seeds[i].viewers[state.Subject] = true
+			}
+		}
+	}
+	viewers := map[primitive.ObjectID]map[string]bool{}
+	if len(others) > 0 {
+		otherStates, err := c.Watches.FindStates(ctx, WatchStateFilter{Subjects: others})
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range otherStates {
+			if !hasWatched(state) {
+				continue
+			}
+			if viewers[state.MovieID] == nil {
+				viewers[state.MovieID] = map[string]bool{}
+			}
+			viewers[state.MovieID][state.Subject] = true
+		}
+	}
+	return rankRecommendations(seeds, candidates, viewers), nil
+}
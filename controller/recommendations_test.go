@@ -0,0 +1,309 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testID returns a fixed ObjectID, so fixtures sort by _id predictably.
+func testID(n int) primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(fmt.Sprintf("%024x", n))
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// ranked is the part of a Recommendation the ranking tests compare.
+type ranked struct {
+	Movie       string
+	Score       float64
+	Explanation string
+	Because     []RecommendationReason
+}
+
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+}
+
+func rankedOf(recs []Recommendation) []ranked {
+	out := make([]ranked, len(recs))
+	for i, rec := range recs {
+		out[i] = ranked{Movie: rec.Movie.Movie, Score: rec.Score, Explanation: rec.Explanation, Because: rec.Because}
+	}
+	return out
+}
+
+func TestSeedWeight(t *testing.T) {
+	tests := []struct {
+		rating int
+		want   float64
+	}{
+		{0, 1},
+		{1, 0},
+		{2, 0.5},
+		{3, 1},
+		{4, 1.5},
+		{5, 2},
+	}
+	for _, tt := range tests {
+		if got := seedWeight(tt.rating); got != tt.want {
+			t.Errorf("seedWeight(%d) = %v, want %v", tt.rating, got, tt.want)
+		}
+	}
+}
+
+func TestRankRecommendations(t *testing.T) {
+	seed := func(n int, title string, rating int, genres, cast []string, viewers ...string) recommendationSeed {
+		s := recommendationSeed{
+			movie:   Movie{ID: testID(n), Movie: title, Genres: genres, Cast: cast},
+			rating:  rating,
+			weight:  seedWeight(rating),
+			viewers: map[string]bool{},
+		}
+		for _, v := range viewers {
+			s.viewers[v] = true
+		}
+		return s
+	}
+	tests := []struct {
+		name       string
+		seeds      []recommendationSeed
+		candidates []Movie
+		viewers    map[primitive.ObjectID]map[string]bool
+		want       []ranked
+	}{
+		{
+			name: "unscored ties break on rating, rating count, then id",
+			candidates: []Movie{
+				{ID: testID(3), Movie: "A", AvgRating: 4, RatingCount: 1},
+				{ID: testID(2), Movie: "B", AvgRating: 4, RatingCount: 3},
+				{ID: testID(5), Movie: "C"},
+				{ID: testID(4), Movie: "D"},
+			},
+			want: []ranked{
+				{Movie: "B", Explanation: "Rated 4.0/5 by 3 viewers", Because: []RecommendationReason{}},
+				{Movie: "A", Explanation: "Rated 4.0/5 by 1 viewer", Because: []RecommendationReason{}},
+				{Movie: "D", Explanation: "Not rated yet; you could be the first", Because: []RecommendationReason{}},
+				{Movie: "C", Explanation: "Not rated yet; you could be the first", Because: []RecommendationReason{}},
+			},
+		},
+		{
+			name: "reasons are kept strongest first up to the cap",
+			seeds: []recommendationSeed{
+				seed(14, "S4", 0, []string{"drama", "a", "b", "c"}, nil),
+				seed(13, "S3", 0, []string{"drama", "a", "b"}, nil),
+				seed(12, "S2", 0, []string{"drama", "crime"}, nil),
+				seed(11, "S1", 0, []string{"drama"}, nil),
+			},
+			candidates: []Movie{{ID: testID(1), Movie: "Drama", Genres: []string{"drama"}}},
+			want: []ranked{{
+				Movie:       "Drama",
+				Score:       2.083,
+				Explanation: "Because you watched S1 (same genres: drama)",
+				Because: []RecommendationReason{
+					{MovieID: testID(11), Movie: "S1", SharedGenres: []string{"drama"}, Score: 1},
+					{MovieID: testID(12), Movie: "S2", SharedGenres: []string{"drama"}, Score: 0.5},
+					{MovieID: testID(13), Movie: "S3", SharedGenres: []string{"drama"}, Score: 0.333},
+				},
+			}},
+		},
+		{
+			name:       "shared cast counts up to three members",
+			seeds:      []recommendationSeed{seed(11, "Ensemble", 3, nil, []string{"a", "b", "c", "d"})},
+			candidates: []Movie{{ID: testID(1), Movie: "Reunion", Cast: []string{"d", "c", "b", "a"}}},
+			want: []ranked{{
+				Movie:       "Reunion",
+				Score:       1.5,
+				Explanation: "Because you rated Ensemble 3/5 (also stars d, c, b, a)",
+				Because: []RecommendationReason{
+					{MovieID: testID(11), Movie: "Ensemble", Rating: 3, SharedCast: []string{"d", "c", "b", "a"}, Score: 1.5},
+				},
+			}},
+		},
+		{
+			name:       "co-watchers count as a share of the seed's viewers",
+			seeds:      []recommendationSeed{seed(11, "Seed", 5, nil, nil, "x", "y", "z")},
+			candidates: []Movie{{ID: testID(1), Movie: "Pair"}, {ID: testID(2), Movie: "Other"}},
+			viewers: map[primitive.ObjectID]map[string]bool{
+				testID(1): {"x": true, "y": true, "w": true},
+				testID(2): {"w": true},
+			},
+			want: []ranked{
+				{
+					Movie:       "Pair",
+					Score:       2.667,
+					Explanation: "Because you rated Seed 5/5 (2 other viewers of it watched this too)",
+					Because: []RecommendationReason{
+						{MovieID: testID(11), Movie: "Seed", Rating: 5, CoWatchers: 2, Score: 2.667},
+					},
+				},
+				{Movie: "Other", Explanation: "Not rated yet; you could be the first", Because: []RecommendationReason{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rankedOf(rankRecommendations(tt.seeds, tt.candidates, tt.viewers))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rankRecommendations() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// recommendationFixture is a small catalogue with the watch history and
+// reviews of three users: alice watched Heat and rated Ronin 5/5 and Cats
+// 1/5, bob watched Heat and The Godfather, carol watched Heat, Ronin and
+// Leon.
+func recommendationFixture(t *testing.T) *Controller {
+	t.Helper()
+	ctx := context.Background()
+	movies := NewMemoryMovieStore(
+		Movie{ID: testID(1), Movie: "Heat", Genres: []string{"crime", "thriller"}, Cast: []string{"Al Pacino", "Robert De Niro"}},
+		Movie{ID: testID(2), Movie: "Ronin", Genres: []string{"action", "thriller"}, Cast: []string{"Robert De Niro", "Jean Reno"}},
+		Movie{ID: testID(3), Movie: "The Godfather", Genres: []string{"crime", "drama"}, Cast: []string{"Al Pacino", "Marlon Brando"}},
+		Movie{ID: testID(4), Movie: "Leon", Genres: []string{"action", "crime"}, Cast: []string{"Jean Reno"}},
+		Movie{ID: testID(5), Movie: "Amelie", Genres: []string{"comedy", "romance"}, AvgRating: 4.5, RatingCount: 2},
+		Movie{ID: testID(6), Movie: "Notting Hill", Genres: []string{"comedy", "romance"}, AvgRating: 4.5, RatingCount: 1},
+		Movie{ID: testID(7), Movie: "Up", Genres: []string{"animation"}},
+		Movie{ID: testID(8), Movie: "Coco", Genres: []string{"animation"}},
+		Movie{ID: testID(9), Movie: "Cats", Genres: []string{"musical"}},
+	)
+	watches := NewMemoryWatchStore()
+	history := map[string][]int{
+		"alice": {1},
+		"bob":   {1, 3},
+		"carol": {1, 2, 4},
+	}
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for subject, ids := range history {
+		for _, n := range ids {
+			if _, err := watches.Record(ctx, subject, testID(n), at); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	reviews := NewMemoryReviewStore(
+		Review{ID: testID(101), MovieID: testID(2), Subject: "alice", Rating: 5},
+		Review{ID: testID(102), MovieID: testID(9), Subject: "alice", Rating: 1},
+	)
+	return New(Stores{Movies: movies, Watches: watches, Reviews: reviews}, Options{})
+}
+
+func TestRecommend(t *testing.T) {
+	unrated := func(title string) ranked {
+		return ranked{Movie: title, Explanation: "Not rated yet; you could be the first", Because: []RecommendationReason{}}
+	}
+	tests := []struct {
+		name    string
+		subject string
+		want    []ranked
+	}{
+		{
+			// Heat weighs 1 as watched, Ronin 2 as rated 5/5 and Cats
+			// nothing as rated 1/5. Heat's other viewers are bob and
+			// carol; Ronin's is carol.
+			name:    "scores unseen movies against watched and rated ones",
+			subject: "alice",
+			want: []ranked{
+				{
+					// Ronin: 1/3 genres + 0.5 cast + 2*1/1 co-watch, times 2;
+					// Heat: 1/3 genres + 2*1/2 co-watch.
+					Movie:       "Leon",
+					Score:       7,
+					Explanation: "Because you rated Ronin 5/5 (same genres: action; also stars Jean Reno; 1 other viewer of it watched this too)",
+					Because: []RecommendationReason{
+						{MovieID: testID(2), Movie: "Ronin", Rating: 5, SharedGenres: []string{"action"}, SharedCast: []string{"Jean Reno"}, CoWatchers: 1, Score: 5.667},
+						{MovieID: testID(1), Movie: "Heat", SharedGenres: []string{"crime"}, CoWatchers: 1, Score: 1.333},
+					},
+				},
+				{
+					// Heat: 1/3 genres + 0.5 cast + 2*1/2 co-watch; nothing
+					// in common with Ronin.
+					Movie:       "The Godfather",
+					Score:       1.833,
+					Explanation: "Because you watched Heat (same genres: crime; also stars Al Pacino; 1 other viewer of it watched this too)",
+					Because: []RecommendationReason{
+						{MovieID: testID(1), Movie: "Heat", SharedGenres: []string{"crime"}, SharedCast: []string{"Al Pacino"}, CoWatchers: 1, Score: 1.833},
+					},
+				},
+				{Movie: "Amelie", Explanation: "Rated 4.5/5 by 2 viewers", Because: []RecommendationReason{}},
+				{Movie: "Notting Hill", Explanation: "Rated 4.5/5 by 1 viewer", Because: []RecommendationReason{}},
+				unrated("Up"),
+				unrated("Coco"),
+			},
+		},
+		{
+			name:    "ranks the whole catalogue by rating without history",
+			subject: "dave",
+			want: []ranked{
+				{Movie: "Amelie", Explanation: "Rated 4.5/5 by 2 viewers", Because: []RecommendationReason{}},
+				{Movie: "Notting Hill", Explanation: "Rated 4.5/5 by 1 viewer", Because: []RecommendationReason{}},
+				unrated("Heat"),
+				unrated("Ronin"),
+				unrated("The Godfather"),
+				unrated("Leon"),
+				unrated("Up"),
+				unrated("Coco"),
+				unrated("Cats"),
+			},
+		},
+	}
+	c := recommendationFixture(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, err := c.recommend(context.Background(), tt.subject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rankedOf(recs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recommend(%q) =\n%+v\nwant\n%+v", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRecommendationsLimit(t *testing.T) {
+	tests := []struct {
+		query     string
+		status    int
+		wantCount int
+	}{
+		{"", http.StatusOK, 6},
+		{"?limit=2", http.StatusOK, 2},
+		{"?limit=0", http.StatusBadRequest, 0},
+		{"?limit=51", http.StatusBadRequest, 0},
+		{"?limit=two", http.StatusBadRequest, 0},
+	}
+	c := recommendationFixture(t)
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/recommendations"+tt.query, nil)
+		req = req.WithContext(WithPrincipal(req.Context(), Principal{Subject: "alice", Role: RoleViewer}))
+		rec := httptest.NewRecorder()
+		c.GetRecommendations(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var body struct{ Data []Recommendation }
+		decodeBody(t, rec, &body)
+		if len(body.Data) != tt.wantCount {
+			t.Errorf("GET %s: %d recommendations, want %d", tt.query, len(body.Data), tt.wantCount)
+		}
+	}
+}
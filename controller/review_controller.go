@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type reviewRequest struct {
+	Rating int    `json:"rating" validate:"required,min=1,max=5"`
+	Text   string `json:"text" validate:"max=5000"`
+}
+
+// ReviewMovie stores the caller's rating and review of a movie. A caller
+// has one review per movie: posting again replaces it, and the movie's
+// average rating is recomputed from its reviews.
+func (c *Controller) ReviewMovie(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := callerSubject(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(vars["id"])
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	var req reviewRequest
+	if err := bindJSON(w, r, &req, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Movies.Get(ctx, id); errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	} else if err != nil {
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	now := time.Now().UTC()
+	review := Review{
+		ID:        primitive.NewObjectID(),
+		MovieID:   id,
+		Subject:   subject,
+		Rating:    req.Rating,
+		Text:      req.Text,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	previous, err := c.Reviews.Upsert(ctx, review)
+	if err != nil {
+		writeError(w, r, internalError("Failed to save review", err))
+		return
+	}
+	message, verb := "Review created successfully", VerbCreate
+	if previous != nil {
+		review.ID, review.CreatedAt = previous.ID, previous.CreatedAt
+		message, verb = "Review updated successfully", VerbUpdate
+	}
+	movie, err := c.refreshRating(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		// The movie was moved to the trash while the review was being
+		// written. The review stays with it, is counted if the movie is
+		// restored and is purged along with it otherwise.
+		writeError(w, r, notFound("Movie not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to update movie rating", err))
+		return
+	}
+	c.auditChange(r, ResourceReview, verb, review.ID.Hex(), previous, review)
+	if movie, err = c.withWatched(ctx, movie); err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"data": map[string]interface{}{
+			"review": review,
+			"movie":  movie,
+		},
+	})
+}
+
+// refreshRating recomputes a movie's average rating and rating count from
+// its reviews and returns the movie. The movie is read before its reviews
+// and its rating is only replaced if it is still the one read, so a review
+// saved in between either is counted or makes the write retry; the rating
+// cannot drift from the reviews however requests interleave. The version is
+// left alone, so a review does not fail the If-Match of an edit to the
+// movie.
+func (c *Controller) refreshRating(ctx context.Context, id primitive.ObjectID) (Movie, error) {
+	for attempt := 0; attempt < maxEditAttempts; attempt++ {
+		movie, err := c.Movies.Get(ctx, id)
+		if err != nil {
+			return Movie{}, err
+		}
+		sum, count, err := c.Reviews.Rating(ctx, id)
+		if err != nil {
+			return Movie{}, err
+		}
+		avg := 0.0
+		if count > 0 {
+			avg = float64(sum) / float64(count)
+		}
+		if movie.AvgRating == avg && movie.RatingCount == count {
+			return movie, nil
+		}
+		movie, err = c.Movies.SetRating(ctx, movie, avg, count)
+		if !errors.Is(err, ErrVersionMismatch) {
+			return movie, err
+		}
+	}
+	return Movie{}, ErrEditConflict
+}
+
+// GetMovieReviews lists a movie's reviews with the usual offset or cursor
+// pagination.
+func (c *Controller) GetMovieReviews(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(vars["id"])
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	q := r.URL.Query()
+	opts, err := parseListOptions(q, reviewSortFields)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if _, err := c.Movies.Get(ctx, id); errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found"))
+		return
+	} else if err != nil {
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	keyset := usesCursor(q)
+	fetch := opts
+	if keyset {
+		fetch.Limit++
+	}
+	reviews, total, err := c.Reviews.List(ctx, id, fetch)
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch reviews", err))
+		return
+	}
+	const message = "Reviews retrieved successfully"
+	if keyset {
+		page, next := cursorPage(reviews, opts.Limit, func(rv Review) primitive.ObjectID { return rv.ID })
+		json.NewEncoder(w).Encode(cursorResponse(r, message, page, total, opts, next))
+		return
+	}
+	json.NewEncoder(w).Encode(listResponse(r, message, reviews, total, opts))
+}
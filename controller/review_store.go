@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Review is one user's rating of a movie, with optional text. Each user has
+// at most one review per movie.
+type Review struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	MovieID   primitive.ObjectID `json:"movieId" bson:"movieId"`
+	Subject   string             `json:"subject" bson:"subject"`
+	Rating    int                `json:"rating" bson:"rating"`
+	Text      string             `json:"text" bson:"text"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// ReviewStore is the persistence boundary for movie reviews.
+type ReviewStore interface {
+	// Upsert stores review as its subject's review of its movie, replacing
+	// the rating and text of any earlier one, and returns the review it
+	// replaced, or nil if there was none. The ID and CreatedAt of an
+	// existing review are kept.
+	Upsert(ctx context.Context, review Review) (*Review, error)
+	// List returns one page of a movie's reviews and the total number.
+	List(ctx context.Context, movieID primitive.ObjectID, opts ListOptions) ([]Review, int64, error)
+	// BySubject returns every review written by subject, oldest first.
+	BySubject(ctx context.Context, subject string) ([]Review, error)
+	// Rating returns the sum and the number of the ratings of movieID.
+	Rating(ctx context.Context, movieID primitive.ObjectID) (sum, count int, err error)
+	// MergeMovie moves the reviews of from onto into and returns the ones
+	// it moved. A user who reviewed both keeps their review of into; the
+	// other is deleted.
+	MergeMovie(ctx context.Context, from, into primitive.ObjectID) ([]Review, error)
+	// DeleteMovie removes every review of movieID.
+	DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error
+}
+
+type MongoReviewStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoReviewStore(coll *mongo.Collection) *MongoReviewStore {
+	return &MongoReviewStore{coll: coll}
+}
+
+// EnsureIndexes creates the unique index that keeps one review per user and
+// movie, which also serves listings by movie, and the index that finds a
+// user's reviews.
+func (s *MongoReviewStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "movieId", Value: 1}, {Key: "subject", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "subject", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	return err
+}
+
+func (s *MongoReviewStore) Upsert(ctx context.Context, review Review) (*Review, error) {
+	var previous Review
+	err := s.coll.FindOneAndUpdate(ctx,
+		bson.M{"movieId": review.MovieID, "subject": review.Subject},
+		bson.M{
+			"$setOnInsert": bson.M{"_id": review.ID, "createdAt": review.CreatedAt},
+			"$set":         bson.M{"rating": review.Rating, "text": review.Text, "updatedAt": review.UpdatedAt},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+	).Decode(&previous)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &previous, nil
+}
+
+func (s *MongoReviewStore) List(ctx context.Context, movieID primitive.ObjectID, opts ListOptions) ([]Review, int64, error) {
+	doc := bson.M{"movieId": movieID}
+	total, err := s.coll.CountDocuments(ctx, doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.coll.Find(ctx, pageFilter(doc, opts), findOptions(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+	reviews := []Review{}
+	if err := cursor.All(ctx, &reviews); err != nil {
+		return nil, 0, err
+	}
+	return reviews, total, nil
+}
+
+func (s *MongoReviewStore) BySubject(ctx context.Context, subject string) ([]Review, error) {
+	cursor, err := s.coll.Find(ctx, bson.M{"subject": subject}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	reviews := []Review{}
+	if err := cursor.All(ctx, &reviews); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+func (s *MongoReviewStore) Rating(ctx context.Context, movieID primitive.ObjectID) (int, int, error) {
+	cursor, err := s.coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"movieId": movieID}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "sum": bson.M{"$sum": "$rating"}, "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+	var totals []struct {
+		Sum   int `bson:"sum"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil || len(totals) == 0 {
+		return 0, 0, err
+	}
+	return totals[0].Sum, totals[0].Count, nil
+}
+
+// MergeMovie moves reviews one by one and lets the unique index decide
+// which users already reviewed into.
+func (s *MongoReviewStore) MergeMovie(ctx context.Context, from, into primitive.ObjectID) ([]Review, error) {
+	cursor, err := s.coll.Find(ctx, bson.M{"movieId": from})
+	if err != nil {
+		return nil, err
+	}
+	var reviews []Review
+	if err := cursor.All(ctx, &reviews); err != nil {
+		return nil, err
+	}
+	moved := []Review{}
+	for _, review := range reviews {
+		_, err := s.coll.UpdateOne(ctx, bson.M{"_id": review.ID}, bson.M{"$set": bson.M{"movieId": into}})
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+		review.MovieID = into
+		moved = append(moved, review)
+	}
+	_, err = s.coll.DeleteMany(ctx, bson.M{"movieId": from})
+	return moved, err
+}
+
+func (s *MongoReviewStore) DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error {
+	_, err := s.coll.DeleteMany(ctx, bson.M{"movieId": movieID})
+	return err
+}
+
+// MemoryReviewStore keeps reviews in process memory and is safe for
+// concurrent use.
+type MemoryReviewStore struct {
+	mu      sync.RWMutex
+	reviews []Review
+}
+
+func NewMemoryReviewStore(seed ...Review) *MemoryReviewStore {
+	return &MemoryReviewStore{reviews: append([]Review(nil), seed...)}
+}
+
+func reviewField(review Review, name string) interface{} {
+	switch name {
+	case "_id":
+		return review.ID
+	case "rating":
+		return review.Rating
+	case "createdAt":
+		return review.CreatedAt
+	case "updatedAt":
+		return review.UpdatedAt
+	}
+	return nil
+}
+
+func (s *MemoryReviewStore) Upsert(ctx context.Context, review Review) (*Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.reviews {
+		if existing.MovieID == review.MovieID && existing.Subject == review.Subject {
+			previous := existing
+			s.reviews[i].Rating = review.Rating
+			s.reviews[i].Text = review.Text
+			s.reviews[i].UpdatedAt = review.UpdatedAt
+			return &previous, nil
+		}
+	}
+	s.reviews = append(s.reviews, review)
+	return nil, nil
+}
+
+func (s *MemoryReviewStore) List(ctx context.Context, movieID primitive.ObjectID, opts ListOptions) ([]Review, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reviews := []Review{}
+	for _, review := range s.reviews {
+		if review.MovieID == movieID {
+			reviews = append(reviews, review)
+		}
+	}
+	total := int64(len(reviews))
+	return sortAndPage(reviews, opts, reviewField), total, nil
+}
+
+func (s *MemoryReviewStore) BySubject(ctx context.Context, subject string) ([]Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reviews := []Review{}
+	for _, review := range s.reviews {
+		if review.Subject == subject {
+			reviews = append(reviews, review)
+		}
+	}
+	return sortAndPage(reviews, ListOptions{}, reviewField), nil
+}
+
+func (s *MemoryReviewStore) Rating(ctx context.Context, movieID primitive.ObjectID) (int, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sum, count := 0, 0
+	for _, review := range s.reviews {
+		if review.MovieID == movieID {
+			sum += review.Rating
+			count++
+		}
+	}
+	return sum, count, nil
+}
+
+func (s *MemoryReviewStore) MergeMovie(ctx context.Context, from, into primitive.ObjectID) ([]Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reviewed := map[string]bool{}
+	for _, review := range s.reviews {
+		if review.MovieID == into {
+			reviewed[review.Subject] = true
+		}
+	}
+	moved := []Review{}
+	reviews := s.reviews[:0]
+	for _, review := range s.reviews {
+		if review.MovieID == from {
+			if reviewed[review.Subject] {
+				continue
+			}
+			review.MovieID = into
+			moved = append(moved, review)
+		}
+		reviews = append(reviews, review)
+	}
+	s.reviews = reviews
+	return moved, nil
+}
+
+func (s *MemoryReviewStore) DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reviews := s.reviews[:0]
+	for _, review := range s.reviews {
+		if review.MovieID != movieID {
+			reviews = append(reviews, review)
+		}
+	}
+	s.reviews = reviews
+	return nil
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned by stores when no document matches the lookup.
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned by stores when a write would give a document
+// the unique key of another that is not in the trash, such as a course's
+// courseid.
+var ErrDuplicate = errors.New("duplicate")
+
+// ErrVersionMismatch is returned by stores when a write made on condition
+// that a document is at a given version finds it at another, or, for
+// MovieStore.SetRating, with another rating.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// SortField orders a listing by one document field.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ListOptions selects one page of a listing. A zero Limit means no limit.
+// Results are always tie-broken by _id so pages are stable. When After is
+// set only documents with a greater _id are returned (keyset pagination).
+type ListOptions struct {
+	Limit  int
+	Offset int
+	Sort   []SortField
+	After  *primitive.ObjectID
+}
+
+// CourseFilter narrows a course listing. Zero values mean "no constraint".
+type CourseFilter struct {
+	// CourseIDs, when non-nil, restricts the listing to these courseids.
+	CourseIDs      []string
+	AuthorFullname string
+	PriceMin       *int
+	PriceMax       *int
+}
+
+// MovieFilter narrows a movie listing. Zero values mean "no constraint".
+type MovieFilter struct {
+	// IDs, when non-nil, restricts the listing to these movies; an empty
+	// non-nil slice matches nothing.
+	IDs []primitive.ObjectID
+	// ExcludeIDs drops these movies from the listing.
+	ExcludeIDs []primitive.ObjectID
+	// Genres keeps movies tagged with any of these (lower-case) genres.
+	Genres  []string
+	YearMin *int
+	YearMax *int
+	// RuntimeMax keeps movies of a known runtime up to this many minutes.
+	RuntimeMax *int
+	// RatingMin keeps movies whose average rating is at least this.
+	RatingMin *float64
+	// DuplicateKey, when set, keeps the movies that may be duplicates of a
+	// movie with this key and DuplicateYear: those whose keys start alike
+	// and, when DuplicateYear is set, those of that year.
+	DuplicateKey  string
+	DuplicateYear int
+}
+
+// Update is a partial, atomic modification of one document, expressed as
+// dotted BSON paths to set and to remove. Applying it moves the document to
+// its next version; when Version is set it is applied only to the document
+// at that version.
+type Update struct {
+	Set     map[string]interface{}
+	Unset   []string
+	Version *int64
+}
+
+func (u Update) IsEmpty() bool {
+	return len(u.Set) == 0 && len(u.Unset) == 0
+}
+
+// CourseStore is the persistence boundary for courses. Courses are addressed
+// by their public courseid rather than the Mongo _id.
+//
+// Deleting a course moves it to the trash by setting its DeletedAt. Every
+// method other than ListDeleted, Restore and Purge ignores trashed courses.
+//
+// Every write to a course, including moving it to the trash and back,
+// moves it to its next Version. Writes that name a version return
+// ErrVersionMismatch when the course is at another.
+type CourseStore interface {
+	// List returns the requested page and the total number of matches.
+	List(ctx context.Context, filter CourseFilter, opts ListOptions) ([]Course, int64, error)
+	// Stream calls fn for each matching course as it is read, without
+	// buffering the result set. Returning an error from fn stops the stream.
+	Stream(ctx context.Context, filter CourseFilter, opts ListOptions, fn func(Course) error) error
+	Get(ctx context.Context, courseID string) (Course, error)
+	// Create stores a new course, or returns ErrDuplicate when another
+	// course has its courseid.
+	Create(ctx context.Context, course Course) error
+	// InsertMany inserts courses in one round trip and returns how many
+	// were written, which may be fewer than len(courses) on error. It
+	// stops with ErrDuplicate at a course whose courseid is taken.
+	InsertMany(ctx context.Context, courses []Course) (int, error)
+	// Replace stores course in place of the one with courseID, provided
+	// that is still at course.Version, and returns it at its next version.
+	Replace(ctx context.Context, courseID string, course Course) (Course, error)
+	// Patch applies update atomically and returns the updated course.
+	Patch(ctx context.Context, courseID string, update Update) (Course, error)
+	// Delete moves the course to the trash, marking it deleted at the
+	// given time. When version is set the course must be at that version.
+	Delete(ctx context.Context, courseID string, at time.Time, version *int64) error
+	// ListDeleted returns one page of the trash and the number of courses
+	// in it.
+	ListDeleted(ctx context.Context, opts ListOptions) ([]Course, int64, error)
+	// GetDeleted returns the most recently deleted course with courseID,
+	// the one Restore takes out of the trash.
+	GetDeleted(ctx context.Context, courseID string) (Course, error)
+	// Restore takes the most recently deleted course with courseID out of
+	// the trash and returns it, or returns ErrDuplicate when another course
+	// now has that courseid. When version is set the course must be at
+	// that version.
+	Restore(ctx context.Context, courseID string, version *int64) (Course, error)
+	// Purge permanently removes the course with the given _id if it was
+	// deleted before the given time, or returns ErrNotFound.
+	Purge(ctx context.Context, id primitive.ObjectID, before time.Time) error
+}
+
+// MovieStore is the persistence boundary for movies. Like courses, deleted
+// movies go to the trash and are ignored by every method other than
+// ListDeleted, Restore and Purge, and movies are versioned the same way.
+type MovieStore interface {
+	List(ctx context.Context, filter MovieFilter, opts ListOptions) ([]Movie, int64, error)
+	Stream(ctx context.Context, filter MovieFilter, opts ListOptions, fn func(Movie) error) error
+	Get(ctx context.Context, id primitive.ObjectID) (Movie, error)
+	Create(ctx context.Context, movie Movie) error
+	InsertMany(ctx context.Context, movies []Movie) (int, error)
+	// Replace stores movie in place of the one with its _id, provided that
+	// is still at movie.Version, and returns it at its next version. The
+	// stored rating is kept: only SetRating changes it.
+	Replace(ctx context.Context, movie Movie) (Movie, error)
+	Patch(ctx context.Context, id primitive.ObjectID, update Update) (Movie, error)
+	// SetRating changes the rating of the movie was from was's to avg and
+	// count and returns the movie. It leaves the version alone, as the
+	// rating follows the movie's reviews rather than edits to it. It
+	// returns ErrVersionMismatch when the rating is no longer was's.
+	SetRating(ctx context.Context, was Movie, avg float64, count int) (Movie, error)
+	// ClaimEnrichment atomically takes the movie whose enrichment has been
+	// due longest (pending, or processing with an expired lease), marks it
+	// processing until now+lease and counts the attempt. It returns
+	// ErrNotFound when no enrichment is due.
+	ClaimEnrichment(ctx context.Context, now time.Time, lease time.Duration) (Movie, error)
+	// Delete moves the movie to the trash, marking it deleted at the given
+	// time. When version is set the movie must be at that version.
+	Delete(ctx context.Context, id primitive.ObjectID, at time.Time, version *int64) error
+	// DeleteAll moves every movie to the trash and returns how many it
+	// moved.
+	DeleteAll(ctx context.Context, at time.Time) (int64, error)
+	ListDeleted(ctx context.Context, opts ListOptions) ([]Movie, int64, error)
+	// GetDeleted returns the movie while it is in the trash.
+	GetDeleted(ctx context.Context, id primitive.ObjectID) (Movie, error)
+	// Restore takes the movie out of the trash and returns it. When version
+	// is set the movie must be at that version.
+	Restore(ctx context.Context, id primitive.ObjectID, version *int64) (Movie, error)
+	// Purge permanently removes the movie if it was deleted before the
+	// given time, or returns ErrNotFound.
+	Purge(ctx context.Context, id primitive.ObjectID, before time.Time) error
+}
+
+// Stores groups the persistence backends the controller depends on.
+type Stores struct {
+	Courses   CourseStore
+	Movies    MovieStore
+	Watches   WatchStore
+	APIKeys   APIKeyStore
+	Audit     AuditStore
+	Reviews   ReviewStore
+	Playlists PlaylistStore
+	Picks     PickStore
+	// Confirmations holds the tokens that confirm bulk actions.
+	Confirmations ConfirmationStore
+	// CourseRevisions holds the history of every course.
+	CourseRevisions CourseRevisionStore
+}
+
+// Options configures how the controller authenticates callers and which
+// background services it hands work to.
+type Options struct {
+	// JWT verifies bearer tokens; nil rejects them.
+	JWT *JWTVerifier
+	// BootstrapKeyHash is the HashAPIKey digest of an operator-configured
+	// key that is accepted without being stored, so the first stored key
+	// can be created. Empty disables it.
+	BootstrapKeyHash string
+	// AnonymousReads lets GET and HEAD requests through without credentials.
+	AnonymousReads bool
+	// Enricher fills in metadata for new movies; nil disables enrichment.
+	Enricher *Enricher
+	// TrashRetention is how long deleted movies and courses stay in the
+	// trash before they are purged; zero keeps them until restored.
+	TrashRetention time.Duration
+	// RequireIfMatch makes PUT, PATCH and DELETE of courses and movies
+	// fail with 428 unless they send If-Match.
+	RequireIfMatch bool
+}
+
+// Controller holds the HTTP handlers for the course and movie APIs together
+// with the stores they read from and write to.
+type Controller struct {
+	Stores
+	Options
+}
+
+func New(stores Stores, opts Options) *Controller {
+	return &Controller{Stores: stores, Options: opts}
+}
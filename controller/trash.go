@@ -0,0 +1,359 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	TrashMovie  = "movie"
+	TrashCourse = "course"
+)
+
+// TrashItem is a deleted movie or course as listed in the trash.
+type TrashItem struct {
+	Type string `json:"type"`
+	// ID is what restores the item: the movie's _id or the course's
+	// courseid.
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	DeletedAt time.Time `json:"deletedAt"`
+	// PurgeAt is when the item is removed for good; it is omitted when
+	// trashed items are kept until restored.
+	PurgeAt *time.Time  `json:"purgeAt,omitempty"`
+	Item    interface{} `json:"item"`
+}
+
+// pastPurge reports whether an item deleted at deletedAt has passed its
+// purge time. Such items can no longer be restored, so the purger may
+// remove what refers to them before removing them.
+func (c *Controller) pastPurge(deletedAt *time.Time) bool {
+	return c.TrashRetention > 0 && deletedAt != nil && !time.Now().UTC().Before(deletedAt.Add(c.TrashRetention))
+}
+
+func (c *Controller) trashItem(kind, id, title string, deletedAt *time.Time, item interface{}) TrashItem {
+	t := TrashItem{Type: kind, ID: id, Title: title, Item: item}
+	if deletedAt != nil {
+		t.DeletedAt = *deletedAt
+	}
+	if c.TrashRetention > 0 {
+		purgeAt := t.DeletedAt.Add(c.TrashRetention)
+		t.PurgeAt = &purgeAt
+	}
+	return t
+}
+
+// maxTrashOffset bounds ?offset= on the trash, as each page reads every
+// item before it from both kinds.
+const maxTrashOffset = 1000
+
+// GetTrash lists deleted movies and courses, most recently deleted first.
+// ?type=movie or ?type=course lists only one kind.
+func (c *Controller) GetTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	opts, err := parseListOptions(q, nil)
+	if err != nil {
+		writeError(w, r, invalidQuery(err))
+		return
+	}
+	if usesCursor(q) {
+		writeError(w, r, invalidQuery(fmt.Errorf("the trash is paged with offset, not cursor")))
+		return
+	}
+	if opts.Offset > maxTrashOffset {
+		writeError(w, r, invalidQuery(fmt.Errorf("offset must be at most %d in the trash", maxTrashOffset)))
+		return
+	}
+	kind := q.Get("type")
+	if kind != "" && kind != TrashMovie && kind != TrashCourse {
+		writeError(w, r, invalidQuery(fmt.Errorf("type must be %q or %q", TrashMovie, TrashCourse)))
+		return
+	}
+	// Each kind is paged separately, so take enough of both to fill the
+	// requested page of the merged list.
+	fetch := ListOptions{
+		Limit: opts.Offset + opts.Limit,
+		Sort:  []SortField{{Field: "deletedAt", Desc: true}, {Field: "_id", Desc: true}},
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	items := []TrashItem{}
+	var total int64
+	if kind != TrashCourse {
+		movies, n, err := c.Movies.ListDeleted(ctx, fetch)
+		if err != nil {
+			writeError(w, r, internalError("Failed to fetch deleted movies", err))
+			return
+		}
+		for _, movie := range movies {
+			items = append(items, c.trashItem(TrashMovie, movie.ID.Hex(), movie.Movie, movie.DeletedAt, movie))
+		}
+		total += n
+	}
+	if kind != TrashMovie {
+		courses, n, err := c.Courses.ListDeleted(ctx, fetch)
+		if err != nil {
+			writeError(w, r, internalError("Failed to fetch deleted courses", err))
+			return
+		}
+		for _, course := range courses {
+			items = append(items, c.trashItem(TrashCourse, course.CourseId, course.CourseName, course.DeletedAt, course))
+		}
+		total += n
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	page := []TrashItem{}
+	if opts.Offset < len(items) {
+		page = items[opts.Offset:]
+		if len(page) > opts.Limit {
+			page = page[:opts.Limit]
+		}
+	}
+	json.NewEncoder(w).Encode(listResponse(r, "Trash retrieved successfully", page, total, opts))
+}
+
+// RestoreMovie takes a movie out of the trash, along with its watch
+// history, reviews and playlist entries, until its purge time. If-Match is
+// checked against the version of the movie in the trash, as listed there.
+func (c *Controller) RestoreMovie(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, badRequest(CodeInvalidID, "Invalid movie ID"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	trashed, err := c.Movies.GetDeleted(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found in the trash"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch movie", err))
+		return
+	}
+	if c.pastPurge(trashed.DeletedAt) {
+		writeError(w, r, &Error{Status: http.StatusConflict, Code: CodeConflict, Message: "Movie has passed its purge time and can no longer be restored"})
+		return
+	}
+	if err := c.checkIfMatch(w, r, trashed.Version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	movie, err := c.Movies.Restore(ctx, id, &trashed.Version)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Movie not found in the trash"))
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeError(w, r, versionConflict(r))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to restore movie", err))
+		return
+	}
+	c.auditResource(r, ResourceMovie, VerbRestore, id.Hex(), "")
+	// Reviews saved while the movie was being trashed are counted now.
+	if movie, err = c.refreshRating(ctx, id); err != nil {
+		writeError(w, r, internalError("Failed to update movie rating", err))
+		return
+	}
+	setETag(w, movie.Version)
+	if movie, err = c.withWatched(ctx, movie); err != nil {
+		writeError(w, r, internalError("Failed to fetch watchlist", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Movie restored successfully",
+		"data":    movie,
+	})
+}
+
+// RestoreCourse takes the most recently deleted course with the given
+// courseid out of the trash. It is refused while another course uses that
+// courseid, and once the course has passed its purge time. If-Match is
+// checked against the version of the course in the trash.
+func (c *Controller) RestoreCourse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	trashed, err := c.Courses.GetDeleted(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Course not found in the trash"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to fetch course", err))
+		return
+	}
+	if c.pastPurge(trashed.DeletedAt) {
+		writeError(w, r, &Error{Status: http.StatusConflict, Code: CodeConflict, Message: "Course has passed its purge time and can no longer be restored"})
+		return
+	}
+	if err := c.checkIfMatch(w, r, trashed.Version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	course, err := c.Courses.Restore(ctx, id, &trashed.Version)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, notFound("Course not found in the trash"))
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writeError(w, r, versionConflict(r))
+		return
+	}
+	if errors.Is(err, ErrDuplicate) {
+		writeError(w, r, &Error{Status: http.StatusConflict, Code: CodeConflict, Message: "Another course already uses this courseid"})
+		return
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to restore course", err))
+		return
+	}
+	c.auditResource(r, ResourceCourse, VerbRestore, id, "")
+	setETag(w, course.Version)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Course restored successfully",
+		"data":    course,
+	})
+}
+
+// Purger permanently removes movies and courses that have been in the
+// trash longer than the retention period. What refers to a purged movie,
+// its watch history, reviews, playlist entries and picks, goes with it.
+type Purger struct {
+	stores    Stores
+	retention time.Duration
+	interval  time.Duration
+	now       func() time.Time
+}
+
+func NewPurger(stores Stores, retention, interval time.Duration) *Purger {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &Purger{
+		stores:    stores,
+		retention: retention,
+		interval:  interval,
+		now:       func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Run purges once straight away and then every interval until ctx is
+// cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.Purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeGrace delays purging past the retention period by longer than a
+// restore request can take, so a restore that began before an item
+// expired finishes before its watch history or revisions are removed.
+const purgeGrace = time.Minute
+
+// purgeBatchSize is how many expired items are read from the trash at a
+// time.
+const purgeBatchSize = 100
+
+// Purge removes everything deleted more than the retention period ago.
+// What refers to an item is removed first and the item itself only once
+// that succeeded, so an item whose references could not all be removed
+// stays in the trash and is retried on the next run.
+func (p *Purger) Purge(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	before := p.now().Add(-p.retention - purgeGrace)
+	movies := purgeExpired(ctx, before, p.stores.Movies.ListDeleted, func(m Movie) (primitive.ObjectID, *time.Time) { return m.ID, m.DeletedAt }, p.purgeMovie)
+	courses := purgeExpired(ctx, before, p.stores.Courses.ListDeleted, func(c Course) (primitive.ObjectID, *time.Time) { return c.ID, c.DeletedAt }, p.purgeCourse)
+	if movies > 0 || courses > 0 {
+		log.Printf("Purged %d movies and %d courses from the trash", movies, courses)
+	}
+}
+
+// purgeExpired walks one kind of trash oldest first, purging each item
+// deleted before the given time, and returns how many it purged. Items
+// that fail stay at the front of the trash, so they are skipped over.
+func purgeExpired[T any](ctx context.Context, before time.Time, list func(context.Context, ListOptions) ([]T, int64, error), key func(T) (primitive.ObjectID, *time.Time), purge func(context.Context, primitive.ObjectID, time.Time) error) int {
+	opts := ListOptions{Limit: purgeBatchSize, Sort: []SortField{{Field: "deletedAt"}, {Field: "_id"}}}
+	purged := 0
+	for {
+		items, _, err := list(ctx, opts)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to list the trash for purging: %v", err)
+			}
+			return purged
+		}
+		for _, item := range items {
+			id, deletedAt := key(item)
+			if deletedAt == nil || !deletedAt.Before(before) {
+				return purged
+			}
+			err := purge(ctx, id, before)
+			switch {
+			case err == nil:
+				purged++
+			case errors.Is(err, ErrNotFound):
+				// Purged by another instance meanwhile.
+			default:
+				if ctx.Err() != nil {
+					return purged
+				}
+				log.Printf("Failed to purge %s from the trash: %v", id.Hex(), err)
+				opts.Offset++
+			}
+		}
+		if len(items) < purgeBatchSize {
+			return purged
+		}
+	}
+}
+
+// purgeMovie removes a movie's watch history, reviews, playlist entries
+// and picks, and then the movie.
+func (p *Purger) purgeMovie(ctx context.Context, id primitive.ObjectID, before time.Time) error {
+	if err := p.stores.Watches.DeleteMovie(ctx, id); err != nil {
+		return fmt.Errorf("removing watch state: %w", err)
+	}
+	if err := p.stores.Reviews.DeleteMovie(ctx, id); err != nil {
+		return fmt.Errorf("removing reviews: %w", err)
+	}
+	if err := p.stores.Playlists.RemoveMovie(ctx, id); err != nil {
+		return fmt.Errorf("removing playlist entries: %w", err)
+	}
+	if err := p.stores.Picks.DeleteMovie(ctx, id); err != nil {
+		return fmt.Errorf("removing picks: %w", err)
+	}
+	return p.stores.Movies.Purge(ctx, id, before)
+}
+
+// purgeCourse removes a course's revisions, and then the course.
+func (p *Purger) purgeCourse(ctx context.Context, id primitive.ObjectID, before time.Time) error {
+	if err := p.stores.CourseRevisions.DeleteCourse(ctx, id); err != nil {
+		return fmt.Errorf("removing revisions: %w", err)
+	}
+	return p.stores.Courses.Purge(ctx, id, before)
+}
@@ -0,0 +1,101 @@
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// flakyReviews fails DeleteMovie while fail is set.
+type flakyReviews struct {
+	controller.ReviewStore
+	fail bool
+}
+
+func (s *flakyReviews) DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error {
+	if s.fail {
+		return errors.New("reviews unavailable")
+	}
+	return s.ReviewStore.DeleteMovie(ctx, movieID)
+}
+
+func TestPurgeKeepsItemsWhoseReferencesRemain(t *testing.T) {
+	ctx := context.Background()
+	deletedAt := time.Now().UTC().Add(-2 * time.Hour)
+	stores := memoryStores()
+	stores.Movies = controller.NewMemoryMovieStore(controller.Movie{ID: fixedID(1), Movie: "Heat", Version: 2, DeletedAt: &deletedAt})
+	reviews := &flakyReviews{ReviewStore: controller.NewMemoryReviewStore(controller.Review{ID: fixedID(2), MovieID: fixedID(1), Subject: "ann", Rating: 4}), fail: true}
+	stores.Reviews = reviews
+	purger := controller.NewPurger(stores, time.Hour, time.Hour)
+
+	steps := []struct {
+		name    string
+		fail    bool
+		trashed int64
+		reviews int
+	}{
+		{"references fail to go", true, 1, 1},
+		{"retried on the next run", false, 0, 0},
+	}
+	for _, step := range steps {
+		reviews.fail = step.fail
+		purger.Purge(ctx)
+		_, trashed, err := stores.Movies.ListDeleted(ctx, controller.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, left, err := reviews.Rating(ctx, fixedID(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trashed != step.trashed || left != step.reviews {
+			t.Errorf("%s: %d trashed and %d reviews left, want %d and %d", step.name, trashed, left, step.trashed, step.reviews)
+		}
+	}
+}
+
+func TestRestoreAfterPurgeTime(t *testing.T) {
+	expired := time.Now().UTC().Add(-2 * time.Hour)
+	recent := time.Now().UTC().Add(-time.Minute)
+	stores := memoryStores()
+	stores.Movies = controller.NewMemoryMovieStore(
+		controller.Movie{ID: fixedID(1), Movie: "Heat", Version: 2, DeletedAt: &expired},
+		controller.Movie{ID: fixedID(2), Movie: "Ronin", Version: 2, DeletedAt: &recent},
+	)
+	h := newTestAPIWith(stores, controller.Options{TrashRetention: time.Hour})
+	tests := []struct {
+		id     primitive.ObjectID
+		status int
+	}{
+		{fixedID(1), http.StatusConflict},
+		{fixedID(2), http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := request(h, "POST", "/api/trash/movie/"+tt.id.Hex()+"/restore", "")
+		if rec.Code != tt.status {
+			t.Errorf("restoring %s: status %d, want %d: %s", tt.id.Hex(), rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestGetTrashOffsetLimit(t *testing.T) {
+	h := newTestAPI(memoryStores())
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"?offset=1000", http.StatusOK},
+		{"?offset=1001", http.StatusBadRequest},
+		{"?cursor=", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := request(h, "GET", "/api/trash"+tt.query, ""); rec.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d: %s", tt.query, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
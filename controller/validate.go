@@ -0,0 +1,327 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Request bodies are validated from `validate` struct tags. Rules are
+// comma separated:
+//
+//	required    the value must not be the zero value (nil, "", 0, empty slice)
+//	min=N       minimum length for strings and slices, minimum value for numbers
+//	max=N       maximum length for strings and slices, maximum value for numbers
+//	url         an absolute http or https URL
+//	oneof=a b   the string must be one of the space separated values
+//	dive        apply the remaining rules to every element of a slice
+//
+// Rules other than required are skipped for zero values, so optional fields
+// only need to be valid when present. Nested structs are always validated.
+
+const maxBodyBytes = 1 << 20
+
+// bindJSON decodes the request body into v, lets fixup fill in
+// server-controlled fields (it may be nil), then validates v. Unknown
+// fields, type mismatches and rule violations are reported together.
+func bindJSON(w http.ResponseWriter, r *http.Request, v interface{}, fixup func()) error {
+	body, err := readBody(w, r)
+	if err != nil {
+		return err
+	}
+	fields, err := decodeStrict(body, v)
+	if err != nil {
+		return err
+	}
+	if fixup != nil {
+		fixup()
+	}
+	normalize(v)
+	fields = append(fields, validateStruct(v)...)
+	if len(fields) > 0 {
+		return validationFailed(fields)
+	}
+	return nil
+}
+
+// normalizer is implemented by documents that canonicalise some of their
+// fields (trimming, case folding) before they are validated and stored.
+type normalizer interface {
+	normalize()
+}
+
+// normalize canonicalises v, a pointer to a document, if it supports it.
+func normalize(v interface{}) {
+	if n, ok := v.(normalizer); ok {
+		n.normalize()
+	}
+}
+
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &Error{Status: http.StatusRequestEntityTooLarge, Code: CodeBodyTooLarge, Message: fmt.Sprintf("Request body must not exceed %d bytes", maxBodyBytes)}
+		}
+		return nil, invalidJSON(err)
+	}
+	return body, nil
+}
+
+// decodeStrict decodes one JSON document into v. Unknown fields and type
+// mismatches come back as field errors so they can be reported alongside
+// validation failures; malformed JSON is an error.
+func decodeStrict(body []byte, v interface{}) ([]FieldError, error) {
+	fields := strictFields(body, reflect.TypeOf(v), "")
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, invalidJSON(err)
+		}
+		// The decoder stops reporting at the first mismatch; strictFields
+		// has already listed them all, so this one is only a fallback.
+		if !hasCode(fields, "type") {
+			fields = append(fields, typeField(typeErr.Field, typeErr))
+		}
+	}
+	if dec.More() {
+		return nil, badRequest(CodeInvalidJSON, "Error decoding JSON: unexpected data after the JSON body")
+	}
+	return fields, nil
+}
+
+// validate checks v against its struct tags and returns a validation error
+// listing every violation, or nil.
+func validate(v interface{}) error {
+	if fields := validateStruct(v); len(fields) > 0 {
+		return validationFailed(fields)
+	}
+	return nil
+}
+
+func validateStruct(v interface{}) []FieldError {
+	var fields []FieldError
+	validateValue(reflect.ValueOf(v), "", &fields)
+	return fields
+}
+
+func validateValue(rv reflect.Value, prefix string, out *[]FieldError) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name, ok := jsonName(f)
+		if !ok {
+			continue
+		}
+		path := prefix + name
+		fv := rv.Field(i)
+		rules := strings.Split(f.Tag.Get("validate"), ",")
+		if applyRules(fv, path, rules, out) && isNested(f.Type) {
+			validateValue(fv, path+".", out)
+		}
+	}
+}
+
+// applyRules checks one value and reports whether it is present, so the
+// caller knows whether to descend into it.
+func applyRules(fv reflect.Value, path string, rules []string, out *[]FieldError) bool {
+	fail := func(code, format string, args ...interface{}) {
+		*out = append(*out, FieldError{Field: path, Code: code, Message: path + " " + fmt.Sprintf(format, args...)})
+	}
+	if isEmpty(fv) {
+		for _, rule := range rules {
+			if rule == "dive" {
+				// The remaining rules apply to the elements.
+				break
+			}
+			if rule == "required" {
+				fail("required", "is required")
+			}
+		}
+		return false
+	}
+	for i, rule := range rules {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "", "required":
+		case "min", "max":
+			limit, _ := strconv.ParseFloat(arg, 64)
+			n, unit := measure(fv)
+			if name == "min" && n < limit {
+				fail(name, "must be at least %s%s", arg, unit)
+			}
+			if name == "max" && n > limit {
+				fail(name, "must be at most %s%s", arg, unit)
+			}
+		case "url":
+			if u, err := url.Parse(fv.String()); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail(name, "must be an absolute http or https URL")
+			}
+		case "oneof":
+			if !contains(strings.Fields(arg), fv.String()) {
+				fail(name, "must be one of: %s", strings.Join(strings.Fields(arg), ", "))
+			}
+		case "dive":
+			for j := 0; j < fv.Len(); j++ {
+				elemPath := fmt.Sprintf("%s[%d]", path, j)
+				if applyRules(fv.Index(j), elemPath, rules[i+1:], out) && isNested(fv.Type().Elem()) {
+					validateValue(fv.Index(j), elemPath+".", out)
+				}
+			}
+			return false
+		default:
+			panic("controller: unknown validation rule " + strconv.Quote(rule) + " on " + path)
+		}
+	}
+	return true
+}
+
+// measure returns the quantity min and max compare against.
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	}
+	return 0, ""
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil() || (v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface && v.Len() == 0)
+	}
+	return v.IsZero()
+}
+
+// isNested reports whether values of t are our own structs to descend into.
+// Library types such as time.Time and ObjectID are leaves.
+func isNested(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t.PkgPath() == reflect.TypeOf(Course{}).PkgPath()
+}
+
+func jsonName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, true
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// strictFields lists object keys in raw that t does not declare and values
+// that do not decode into their field's type. Like encoding/json, keys
+// match field names case-insensitively.
+func strictFields(raw []byte, t reflect.Type, prefix string) []FieldError {
+	leaf := t
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Slice && isNested(t.Elem()):
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			return typeMismatch(raw, t, strings.TrimSuffix(prefix, "."))
+		}
+		var fields []FieldError
+		for i, item := range items {
+			fields = append(fields, strictFields(item, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(prefix, "."), i))...)
+		}
+		return fields
+	case !isNested(t):
+		return typeMismatch(raw, leaf, strings.TrimSuffix(prefix, "."))
+	}
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(raw, &obj) != nil {
+		return typeMismatch(raw, t, strings.TrimSuffix(prefix, "."))
+	}
+	known := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := jsonName(t.Field(i)); ok {
+			known[strings.ToLower(name)] = t.Field(i).Type
+		}
+	}
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var fields []FieldError
+	for _, key := range keys {
+		ft, ok := known[strings.ToLower(key)]
+		if !ok {
+			fields = append(fields, FieldError{Field: prefix + key, Code: "unknown_field", Message: prefix + key + " is not a recognised field"})
+			continue
+		}
+		fields = append(fields, strictFields(obj[key], ft, prefix+key+".")...)
+	}
+	return fields
+}
+
+// typeMismatch reports field if raw is a JSON value of the wrong kind for
+// t. Other decoding errors are left to the decoder.
+func typeMismatch(raw []byte, t reflect.Type, field string) []FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(json.Unmarshal(raw, reflect.New(t).Interface()), &typeErr) {
+		return nil
+	}
+	return []FieldError{typeField(field, typeErr)}
+}
+
+func typeField(field string, err *json.UnmarshalTypeError) FieldError {
+	return FieldError{Field: field, Code: "type", Message: fmt.Sprintf("%s must be of type %s", field, err.Type)}
+}
+
+func hasCode(fields []FieldError, code string) bool {
+	for _, f := range fields {
+		if f.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package controller_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+// fieldNames lists the fields and codes of a problem's errors.
+func fieldNames(fields []controller.FieldError) []string {
+	names := []string{}
+	for _, f := range fields {
+		names = append(names, f.Field+":"+f.Code)
+	}
+	return names
+}
+
+func TestCreateCourseValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		code   string
+		fields []string
+	}{
+		{"malformed JSON", `{"courseid":`, controller.CodeInvalidJSON, []string{}},
+		{"trailing data", courseBody + `{}`, controller.CodeInvalidJSON, []string{}},
+		{"missing fields", `{}`, controller.CodeValidationFailed, []string{"courseid:required", "coursename:required", "author:required"}},
+		{"wrong types", `{"courseid":"c","coursename":"C","price":"free","author":{"fullname":"A"}}`, controller.CodeValidationFailed, []string{"price:type"}},
+		{"several wrong types", `{"courseid":"c","coursename":7,"price":"free","author":{"fullname":"A","website":false},"teacher":"B"}`, controller.CodeValidationFailed, []string{"author.website:type", "coursename:type", "price:type", "teacher:unknown_field", "coursename:required"}},
+		{"out of range", `{"courseid":"c","coursename":"C","price":-1,"author":{"fullname":"A","website":"nope"}}`, controller.CodeValidationFailed, []string{"price:min", "author.website:url"}},
+		{"unknown field", `{"courseid":"c","coursename":"C","author":{"fullname":"A"},"teacher":"B"}`, controller.CodeValidationFailed, []string{"teacher:unknown_field"}},
+	}
+	h := newTestAPI(memoryStores())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := decodeProblem(t, request(h, "POST", "/api/course", tt.body), http.StatusBadRequest)
+			if p.Code != tt.code {
+				t.Errorf("code %q, want %q", p.Code, tt.code)
+			}
+			if p.Instance != "/api/course" {
+				t.Errorf("instance %q, want /api/course", p.Instance)
+			}
+			if got := fieldNames(p.Errors); !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("errors %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestCreateMovieValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		code   string
+		fields []string
+	}{
+		{"malformed JSON", "/api/movie", `{"movie":`, controller.CodeInvalidJSON, []string{}},
+		{"not an object", "/api/movie", `["Heat"]`, controller.CodeValidationFailed, []string{":type", "movie:required"}},
+		{"wrong types", "/api/movie", `{"movie":"Heat","year":"1995","genres":["crime",7],"cast":"Al Pacino"}`, controller.CodeValidationFailed, []string{"cast:type", "genres:type", "year:type", "genres[1]:required"}},
+		{"missing title", "/api/movie", `{"year":1995}`, controller.CodeValidationFailed, []string{"movie:required"}},
+		{"out of range", "/api/movie", `{"movie":"Heat","year":1800,"runtime":2000,"posterUrl":"poster"}`, controller.CodeValidationFailed, []string{"year:min", "runtime:max", "posterUrl:url"}},
+		{"blank genre", "/api/movie", `{"movie":"Heat","genres":["crime",""]}`, controller.CodeValidationFailed, []string{"genres[1]:required"}},
+		{"invalid force", "/api/movie?force=maybe", `{"movie":"Heat"}`, controller.CodeInvalidQuery, []string{}},
+	}
+	h := newTestAPI(memoryStores())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := decodeProblem(t, request(h, "POST", tt.path, tt.body), http.StatusBadRequest)
+			if p.Code != tt.code {
+				t.Errorf("code %q, want %q", p.Code, tt.code)
+			}
+			if got := fieldNames(p.Errors); !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("errors %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,613 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WatchEvent records one viewing of a movie by one user. Users are
+// identified by their principal subject. Events are append-only.
+type WatchEvent struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	Subject   string             `json:"subject" bson:"subject"`
+	MovieID   primitive.ObjectID `json:"movieId" bson:"movieId"`
+	WatchedAt time.Time          `json:"watchedAt" bson:"watchedAt"`
+	// Source names the history an imported event came from, such as
+	// "netflix"; it is empty for events recorded through the API.
+	Source string `json:"source,omitempty" bson:"source,omitempty"`
+	// Season and Episode identify what was watched when the title is a
+	// series rather than a movie.
+	Season  string `json:"season,omitempty" bson:"season,omitempty"`
+	Episode string `json:"episode,omitempty" bson:"episode,omitempty"`
+}
+
+// sameImport reports whether two imported events describe the same viewing.
+func (e WatchEvent) sameImport(o WatchEvent) bool {
+	return e.Source != "" && e.Source == o.Source && e.Subject == o.Subject && e.MovieID == o.MovieID &&
+		e.WatchedAt.Equal(o.WatchedAt) && e.Season == o.Season && e.Episode == o.Episode
+}
+
+// WatchState summarises one user's history with one movie. Watched is
+// whether the movie is on their watchlist; unmarking clears it but keeps
+// the count and the time of the last viewing.
+type WatchState struct {
+	ID            primitive.ObjectID `json:"_id" bson:"_id"`
+	Subject       string             `json:"subject" bson:"subject"`
+	MovieID       primitive.ObjectID `json:"movieId" bson:"movieId"`
+	Watched       bool               `json:"watched" bson:"watched"`
+	WatchCount    int                `json:"watchCount" bson:"watchCount"`
+	LastWatchedAt time.Time          `json:"lastWatchedAt" bson:"lastWatchedAt"`
+}
+
+// WatchStateFilter selects watch states across users. Zero fields mean no
+// constraint.
+type WatchStateFilter struct {
+	Subjects []string
+	MovieIDs []primitive.ObjectID
+}
+
+func (f WatchStateFilter) matches(state WatchState) bool {
+	return (f.Subjects == nil || contains(f.Subjects, state.Subject)) &&
+		(f.MovieIDs == nil || containsID(f.MovieIDs, state.MovieID))
+}
+
+// WatchStore is the persistence boundary for per-user watch history.
+type WatchStore interface {
+	// Record logs that subject watched movieID at the given time and puts
+	// the movie on their watchlist. Recording it again counts a rewatch.
+	Record(ctx context.Context, subject string, movieID primitive.ObjectID, at time.Time) (WatchState, error)
+	// Import records events taken from an external viewing history, each of
+	// which must have a Source. Events already imported (same source,
+	// subject, movie, time, season and episode, or the same _id) are
+	// skipped, so importing the same history twice is harmless. It returns
+	// how many were new, even when it fails part way.
+	Import(ctx context.Context, events []WatchEvent) (int, error)
+	// Unmark takes movieID off subject's watchlist, keeping its history.
+	// It returns ErrNotFound if the movie is not on the watchlist.
+	Unmark(ctx context.Context, subject string, movieID primitive.ObjectID) error
+	// States returns every movie subject has history with, watched or not.
+	States(ctx context.Context, subject string) ([]WatchState, error)
+	// FindStates returns the states of any user that match filter, ordered
+	// by subject and then movie.
+	FindStates(ctx context.Context, filter WatchStateFilter) ([]WatchState, error)
+	// WatchedBetween returns the ids of the movies subject watched at or
+	// after since and before until. A zero until means no upper bound.
+	WatchedBetween(ctx context.Context, subject string, since, until time.Time) ([]primitive.ObjectID, error)
+	// MergeMovie moves every user's history with from onto into. Counts
+	// are added up and the latest viewing wins; an imported event that was
+	// also imported for into is the same viewing and is dropped.
+	MergeMovie(ctx context.Context, from, into primitive.ObjectID) error
+	// DeleteMovie forgets every user's history with movieID.
+	DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error
+}
+
+type MongoWatchStore struct {
+	states *mongo.Collection
+	events *mongo.Collection
+}
+
+func NewMongoWatchStore(states, events *mongo.Collection) *MongoWatchStore {
+	return &MongoWatchStore{states: states, events: events}
+}
+
+// EnsureIndexes creates the unique index that keeps one state per user and
+// movie, the index that finds everyone who watched a movie, the index that
+// serves time-window queries on events, and the unique index that makes
+// imports idempotent.
+func (s *MongoWatchStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.states.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "subject", Value: 1}, {Key: "movieId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "movieId", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.events.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "subject", Value: 1}, {Key: "watchedAt", Value: 1}}},
+		{
+			Keys: bson.D{
+				{Key: "subject", Value: 1}, {Key: "movieId", Value: 1}, {Key: "watchedAt", Value: 1},
+				{Key: "source", Value: 1}, {Key: "season", Value: 1}, {Key: "episode", Value: 1},
+			},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"source": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
+
+func (s *MongoWatchStore) Record(ctx context.Context, subject string, movieID primitive.ObjectID, at time.Time) (WatchState, error) {
+	event := WatchEvent{ID: primitive.NewObjectID(), Subject: subject, MovieID: movieID, WatchedAt: at}
+	if _, err := s.events.InsertOne(ctx, event); err != nil {
+		return WatchState{}, err
+	}
+	var state WatchState
+	err := s.states.FindOneAndUpdate(ctx,
+		bson.M{"subject": subject, "movieId": movieID},
+		bson.M{
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+			"$set":         bson.M{"watched": true},
+			"$inc":         bson.M{"watchCount": 1},
+			"$max":         bson.M{"lastWatchedAt": at},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&state)
+	return state, err
+}
+
+// Import inserts the events unordered and treats duplicate key errors as
+// already imported; only the events actually inserted update the states.
+// When some inserts fail for another reason the rest are still recorded,
+// and their number is returned with the error.
+func (s *MongoWatchStore) Import(ctx context.Context, events []WatchEvent) (int, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+	docs := make([]interface{}, len(events))
+	for i := range events {
+		if events[i].ID.IsZero() {
+			events[i].ID = primitive.NewObjectID()
+		}
+		docs[i] = events[i]
+	}
+	skipped := map[int]bool{}
+	_, err := s.events.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		// An unordered insert attempts every event, so those without a
+		// write error were inserted.
+		failed := bulkErr.WriteConcernError != nil
+		for _, we := range bulkErr.WriteErrors {
+			skipped[we.Index] = true
+			if !mongo.IsDuplicateKeyError(we) {
+				failed = true
+			}
+		}
+		if !failed {
+			err = nil
+		}
+	} else if err != nil {
+		return 0, err
+	}
+	var models []mongo.WriteModel
+	for _, state := range importedStates(events, skipped) {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"subject": state.Subject, "movieId": state.MovieID}).
+			SetUpdate(bson.M{
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+				"$set":         bson.M{"watched": true},
+				"$inc":         bson.M{"watchCount": state.WatchCount},
+				"$max":         bson.M{"lastWatchedAt": state.LastWatchedAt},
+			}).
+			SetUpsert(true))
+	}
+	if len(models) > 0 {
+		if _, stateErr := s.states.BulkWrite(ctx, models); stateErr != nil {
+			return len(events) - len(skipped), stateErr
+		}
+	}
+	return len(events) - len(skipped), err
+}
+
+// importedStates sums the events not skipped into one state change per
+// user and movie, in first-seen order.
+func importedStates(events []WatchEvent, skipped map[int]bool) []WatchState {
+	var states []WatchState
+	index := map[[2]string]int{}
+	for i, event := range events {
+		if skipped[i] {
+			continue
+		}
+		key := [2]string{event.Subject, event.MovieID.Hex()}
+		j, ok := index[key]
+		if !ok {
+			j = len(states)
+			index[key] = j
+			states = append(states, WatchState{Subject: event.Subject, MovieID: event.MovieID})
+		}
+		states[j].WatchCount++
+		if event.WatchedAt.After(states[j].LastWatchedAt) {
+			states[j].LastWatchedAt = event.WatchedAt
+		}
+	}
+	return states
+}
+
+func (s *MongoWatchStore) Unmark(ctx context.Context, subject string, movieID primitive.ObjectID) error {
+	result, err := s.states.UpdateOne(ctx,
+		bson.M{"subject": subject, "movieId": movieID, "watched": true},
+		bson.M{"$set": bson.M{"watched": false}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MongoWatchStore) States(ctx context.Context, subject string) ([]WatchState, error) {
+	cursor, err := s.states.Find(ctx, bson.M{"subject": subject})
+	if err != nil {
+		return nil, err
+	}
+	states := []WatchState{}
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+func (s *MongoWatchStore) FindStates(ctx context.Context, filter WatchStateFilter) ([]WatchState, error) {
+	doc := bson.M{}
+	if filter.Subjects != nil {
+		doc["subject"] = bson.M{"$in": filter.Subjects}
+	}
+	if filter.MovieIDs != nil {
+		doc["movieId"] = bson.M{"$in": filter.MovieIDs}
+	}
+	cursor, err := s.states.Find(ctx, doc, options.Find().SetSort(bson.D{{Key: "subject", Value: 1}, {Key: "movieId", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	states := []WatchState{}
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+func (s *MongoWatchStore) WatchedBetween(ctx context.Context, subject string, since, until time.Time) ([]primitive.ObjectID, error) {
+	window := bson.M{"$gte": since}
+	if !until.IsZero() {
+		window["$lt"] = until
+	}
+	values, err := s.events.Distinct(ctx, "movieId", bson.M{"subject": subject, "watchedAt": window})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, v := range values {
+		id, ok := v.(primitive.ObjectID)
+		if !ok {
+			return nil, errors.New("watch event has a non-ObjectID movieId")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// MergeMovie moves events recorded through the API in one write. Imported
+// events are moved one by one, because the unique import index rejects
+// those already imported for into; such events are deleted and left out of
+// the merged count. States are then folded into into's with upserts.
+func (s *MongoWatchStore) MergeMovie(ctx context.Context, from, into primitive.ObjectID) error {
+	_, err := s.events.UpdateMany(ctx,
+		bson.M{"movieId": from, "source": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"movieId": into}},
+	)
+	if err != nil {
+		return err
+	}
+	cursor, err := s.events.Find(ctx, bson.M{"movieId": from})
+	if err != nil {
+		return err
+	}
+	var imported []WatchEvent
+	if err := cursor.All(ctx, &imported); err != nil {
+		return err
+	}
+	dropped := map[string]int{}
+	for _, event := range imported {
+		_, err := s.events.UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": bson.M{"movieId": into}})
+		if mongo.IsDuplicateKeyError(err) {
+			if _, err := s.events.DeleteOne(ctx, bson.M{"_id": event.ID}); err != nil {
+				return err
+			}
+			dropped[event.Subject]++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	cursor, err = s.states.Find(ctx, bson.M{"movieId": from})
+	if err != nil {
+		return err
+	}
+	var states []WatchState
+	if err := cursor.All(ctx, &states); err != nil {
+		return err
+	}
+	var models []mongo.WriteModel
+	for _, state := range states {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"subject": state.Subject, "movieId": into}).
+			SetUpdate(bson.M{
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+				// false sorts before true, so $max keeps the movie on the
+				// watchlist if either state had it there.
+				"$max": bson.M{"watched": state.Watched, "lastWatchedAt": state.LastWatchedAt},
+				"$inc": bson.M{"watchCount": state.WatchCount - dropped[state.Subject]},
+			}).
+			SetUpsert(true))
+	}
+	if len(models) > 0 {
+		if _, err := s.states.BulkWrite(ctx, models); err != nil {
+			return err
+		}
+	}
+	_, err = s.states.DeleteMany(ctx, bson.M{"movieId": from})
+	return err
+}
+
+func (s *MongoWatchStore) DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error {
+	if _, err := s.events.DeleteMany(ctx, bson.M{"movieId": movieID}); err != nil {
+		return err
+	}
+	_, err := s.states.DeleteMany(ctx, bson.M{"movieId": movieID})
+	return err
+}
+
+// CountWatchedFlags counts movie documents still carrying the global
+// watched flag that predates per-user watch state.
+func CountWatchedFlags(ctx context.Context, movies *mongo.Collection) (int64, error) {
+	return movies.CountDocuments(ctx, bson.M{"watched": true})
+}
+
+// migrationSource is the Source of the events MigrateWatchedFlags records.
+const migrationSource = "migration"
+
+// MigrateWatchedFlags moves the legacy global watched flag into owner's
+// watchlist and removes the flag from every movie document. The flag
+// carried no time, so each migrated movie is recorded as watched at. Each
+// movie's event is imported with the movie's own _id, so a run interrupted
+// before the flags are removed records nothing twice when repeated. It
+// returns the number of movies newly migrated.
+func MigrateWatchedFlags(ctx context.Context, movies *mongo.Collection, watches WatchStore, owner string, at time.Time) (int, error) {
+	cursor, err := movies.Find(ctx, bson.M{"watched": true}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	n := 0
+	events := make([]WatchEvent, 0, importBatchSize)
+	flush := func() error {
+		imported, err := watches.Import(ctx, events)
+		n += imported
+		events = events[:0]
+		return err
+	}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return n, err
+		}
+		events = append(events, WatchEvent{ID: doc.ID, Subject: owner, MovieID: doc.ID, WatchedAt: at, Source: migrationSource})
+		if len(events) == importBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return n, err
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	_, err = movies.UpdateMany(ctx, bson.M{"watched": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"watched": ""}})
+	return n, err
+}
+
+// MemoryWatchStore keeps watch history in process memory and is safe for
+// concurrent use.
+type MemoryWatchStore struct {
+	mu     sync.RWMutex
+	states []WatchState
+	events []WatchEvent
+}
+
+func NewMemoryWatchStore() *MemoryWatchStore {
+	return &MemoryWatchStore{}
+}
+
+func (s *MemoryWatchStore) indexOf(subject string, movieID primitive.ObjectID) int {
+	for i, state := range s.states {
+		if state.Subject == subject && state.MovieID == movieID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *MemoryWatchStore) Record(ctx context.Context, subject string, movieID primitive.ObjectID, at time.Time) (WatchState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, WatchEvent{ID: primitive.NewObjectID(), Subject: subject, MovieID: movieID, WatchedAt: at})
+	i := s.indexOf(subject, movieID)
+	if i < 0 {
+		s.states = append(s.states, WatchState{ID: primitive.NewObjectID(), Subject: subject, MovieID: movieID})
+		i = len(s.states) - 1
+	}
+	state := &s.states[i]
+	state.Watched = true
+	state.WatchCount++
+	if at.After(state.LastWatchedAt) {
+		state.LastWatchedAt = at
+	}
+	return *state, nil
+}
+
+func (s *MemoryWatchStore) Import(ctx context.Context, events []WatchEvent) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	skipped := map[int]bool{}
+	for i, event := range events {
+		for _, existing := range s.events {
+			if existing.sameImport(event) || (!event.ID.IsZero() && existing.ID == event.ID) {
+				skipped[i] = true
+				break
+			}
+		}
+		if skipped[i] {
+			continue
+		}
+		if event.ID.IsZero() {
+			event.ID = primitive.NewObjectID()
+		}
+		s.events = append(s.events, event)
+	}
+	for _, change := range importedStates(events, skipped) {
+		i := s.indexOf(change.Subject, change.MovieID)
+		if i < 0 {
+			s.states = append(s.states, WatchState{ID: primitive.NewObjectID(), Subject: change.Subject, MovieID: change.MovieID})
+			i = len(s.states) - 1
+		}
+		state := &s.states[i]
+		state.Watched = true
+		state.WatchCount += change.WatchCount
+		if change.LastWatchedAt.After(state.LastWatchedAt) {
+			state.LastWatchedAt = change.LastWatchedAt
+		}
+	}
+	return len(events) - len(skipped), nil
+}
+
+func (s *MemoryWatchStore) Unmark(ctx context.Context, subject string, movieID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(subject, movieID)
+	if i < 0 || !s.states[i].Watched {
+		return ErrNotFound
+	}
+	s.states[i].Watched = false
+	return nil
+}
+
+func (s *MemoryWatchStore) States(ctx context.Context, subject string) ([]WatchState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := []WatchState{}
+	for _, state := range s.states {
+		if state.Subject == subject {
+			states = append(states, state)
+		}
+	}
+	return states, nil
+}
+
+func (s *MemoryWatchStore) FindStates(ctx context.Context, filter WatchStateFilter) ([]WatchState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := []WatchState{}
+	for _, state := range s.states {
+		if filter.matches(state) {
+			states = append(states, state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Subject != states[j].Subject {
+			return states[i].Subject < states[j].Subject
+		}
+		return compareValues(states[i].MovieID, states[j].MovieID) < 0
+	})
+	return states, nil
+}
+
+func (s *MemoryWatchStore) WatchedBetween(ctx context.Context, subject string, since, until time.Time) ([]primitive.ObjectID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := []primitive.ObjectID{}
+	for _, event := range s.events {
+		if event.Subject != subject || event.WatchedAt.Before(since) || (!until.IsZero() && !event.WatchedAt.Before(until)) {
+			continue
+		}
+		if !containsID(ids, event.MovieID) {
+			ids = append(ids, event.MovieID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *MemoryWatchStore) MergeMovie(ctx context.Context, from, into primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropped := map[string]int{}
+	events := make([]WatchEvent, 0, len(s.events))
+	for _, event := range s.events {
+		if event.MovieID == from {
+			event.MovieID = into
+			duplicate := false
+			for _, existing := range s.events {
+				if existing.MovieID == into && existing.sameImport(event) {
+					duplicate = true
+					break
+				}
+			}
+			if duplicate {
+				dropped[event.Subject]++
+				continue
+			}
+		}
+		events = append(events, event)
+	}
+	s.events = events
+	states := s.states[:0]
+	var moved []WatchState
+	for _, state := range s.states {
+		if state.MovieID == from {
+			moved = append(moved, state)
+		} else {
+			states = append(states, state)
+		}
+	}
+	s.states = states
+	for _, change := range moved {
+		i := s.indexOf(change.Subject, into)
+		if i < 0 {
+			s.states = append(s.states, WatchState{ID: primitive.NewObjectID(), Subject: change.Subject, MovieID: into})
+			i = len(s.states) - 1
+		}
+		state := &s.states[i]
+		state.Watched = state.Watched || change.Watched
+		state.WatchCount += change.WatchCount - dropped[change.Subject]
+		if change.LastWatchedAt.After(state.LastWatchedAt) {
+			state.LastWatchedAt = change.LastWatchedAt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryWatchStore) DeleteMovie(ctx context.Context, movieID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := s.states[:0]
+	for _, state := range s.states {
+		if state.MovieID != movieID {
+			states = append(states, state)
+		}
+	}
+	s.states = states
+	events := s.events[:0]
+	for _, event := range s.events {
+		if event.MovieID != movieID {
+			events = append(events, event)
+		}
+	}
+	s.events = events
+	return nil
+}
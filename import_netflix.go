@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hiteshchoudhary/mongodb/app"
+	"github.com/hiteshchoudhary/mongodb/config"
+	"github.com/hiteshchoudhary/mongodb/controller"
+)
+
+const importNetflixUsage = `Usage: mongodb import-netflix -subject USER [options] ViewingActivity.csv [server flags]
+
+Records a Netflix "Viewing Activity" CSV as USER's watch history, creating
+movies for titles the catalogue does not have yet. Server flags, such as
+-config or -mongo-uri, select the database as they do for the server.
+
+`
+
+// importNetflix runs the import-netflix command and returns the process
+// exit code.
+func importNetflix(args []string) int {
+	fs := flag.NewFlagSet("import-netflix", flag.ContinueOnError)
+	subject := fs.String("subject", "", "the user whose watch history the file is (required)")
+	dayFirst := fs.Bool("day-first", false, "read slash dates as D/M/Y instead of M/D/Y")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without writing")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), importNetflixUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *subject == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cfg, err := config.Load(fs.Args()[1:])
+	if config.IsHelp(err) {
+		return 0
+	}
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer f.Close()
+
+	a, err := app.New(cfg)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer a.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	result, err := a.API.ImportViewingActivity(ctx, *subject, f, controller.ViewingImportOptions{DayFirst: *dayFirst, DryRun: *dryRun})
+	if err != nil {
+		log.Printf("Import failed after recording %d watch events: %v", result.Recorded, err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
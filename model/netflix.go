@@ -6,6 +6,13 @@ type Netflix struct {
     ID      primitive.ObjectID `json:"_id" bson:"_id"`
     Movie   string             `json:"movie" bson:"movie"`
     Watched bool              `json:"watched" bson:"watched"`
+
+    Year      int      `json:"year,omitempty" bson:"year,omitempty"`
+    Genres    []string `json:"genres,omitempty" bson:"genres,omitempty"`
+    Runtime   int      `json:"runtime,omitempty" bson:"runtime,omitempty"` // minutes
+    Director  string   `json:"director,omitempty" bson:"director,omitempty"`
+    Cast      []string `json:"cast,omitempty" bson:"cast,omitempty"`
+    PosterURL string   `json:"posterUrl,omitempty" bson:"posterUrl,omitempty"`
 }
 
 func (n Netflix) IsEmpty() bool {
@@ -1,24 +1,92 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/gorilla/mux"
 	"github.com/hiteshchoudhary/mongodb/controller" // Ensure this path is correct
 )
 
-func Router() *mux.Router {
+// route is one API endpoint and the least role allowed to call it.
+type route struct {
+	method  string
+	path    string
+	role    controller.Role
+	handler http.HandlerFunc
+}
+
+func Router(c *controller.Controller) *mux.Router {
 	router := mux.NewRouter()
-	router.HandleFunc("/api/courses", controller.GetAllCourses).Methods("GET")
-	router.HandleFunc("/api/course/{id}", controller.GetOneCourse).Methods("GET")
-	router.HandleFunc("/api/course", controller.CreateCourse).Methods("POST")
-	router.HandleFunc("/api/course/{id}", controller.UpdateCourse).Methods("PUT")
-	router.HandleFunc("/api/course/{id}", controller.DeleteCourse).Methods("DELETE")
-	router.HandleFunc("/api/movies", controller.GetAllMovies).Methods("GET")
-	router.HandleFunc("/api/movie/{id}", controller.GetOneMovie).Methods("GET")
-	router.HandleFunc("/api/movie", controller.CreateMovie).Methods("POST")
-	router.HandleFunc("/api/movie/{id}", controller.UpdateMovie).Methods("PUT")
-	router.HandleFunc("/api/movie/{id}", controller.DeleteAMovie).Methods("DELETE")
-	router.HandleFunc("/api/my-movies", controller.GetMyAllMovies).Methods("GET")
-	router.HandleFunc("/api/movie/{id}/watched", controller.MarkAsWatched).Methods("PUT")
-	router.HandleFunc("/api/deleteallmovie", controller.DeleteAllMoviesHandler).Methods("DELETE")
+	router.NotFoundHandler = controller.RequestID(http.HandlerFunc(controller.NotFoundHandler))
+	router.MethodNotAllowedHandler = controller.RequestID(http.HandlerFunc(controller.MethodNotAllowedHandler))
+
+	const (
+		viewer = controller.RoleViewer
+		editor = controller.RoleEditor
+		admin  = controller.RoleAdmin
+	)
+	// The policy table: every API route, and who may call it. Deleting a
+	// course is admin-only, as courses are curated; an editor may delete the
+	// movies they catalogue. Restoring from the trash needs the same role as
+	// deleting, so each kind can only be undone by those who could do it.
+	routes := []route{
+		{"GET", "/api/courses", viewer, c.GetAllCourses},
+		{"POST", "/api/courses/import", editor, c.ImportCourses},
+		{"GET", "/api/courses/export", viewer, c.ExportCourses},
+		{"GET", "/api/course/{id}", viewer, c.GetOneCourse},
+		{"POST", "/api/course", editor, c.CreateCourse},
+		{"PUT", "/api/course/{id}", editor, c.UpdateCourse},
+		{"PATCH", "/api/course/{id}", editor, c.PatchCourse},
+		{"DELETE", "/api/course/{id}", admin, c.DeleteCourse},
+		{"GET", "/api/course/{id}/revisions", viewer, c.GetCourseRevisions},
+		{"GET", "/api/course/{id}/revisions/diff", viewer, c.DiffCourseRevisions},
+		{"GET", "/api/course/{id}/revisions/{n:[0-9]+}", viewer, c.GetCourseRevision},
+		{"POST", "/api/course/{id}/revisions/{n:[0-9]+}/restore", editor, c.RestoreCourseRevision},
+		{"GET", "/api/movies", viewer, c.GetAllMovies},
+		{"POST", "/api/movies/import", editor, c.ImportMovies},
+		{"GET", "/api/movies/export", viewer, c.ExportMovies},
+		{"GET", "/api/movies/recommendations", viewer, c.GetRecommendations},
+		{"GET", "/api/movies/random", viewer, c.GetRandomMovie},
+		{"POST", "/api/movies/import/netflix", editor, c.ImportNetflixActivity},
+		{"GET", "/api/movie/{id}", viewer, c.GetOneMovie},
+		{"POST", "/api/movie", editor, c.CreateMovie},
+		{"PUT", "/api/movie/{id}", editor, c.UpdateMovie},
+		{"PATCH", "/api/movie/{id}", editor, c.PatchMovie},
+		{"DELETE", "/api/movie/{id}", editor, c.DeleteAMovie},
+		{"POST", "/api/movie/{id}/enrich", editor, c.EnrichMovie},
+		{"GET", "/api/my-movies", viewer, c.GetMyAllMovies},
+		{"PUT", "/api/movie/{id}/watched", viewer, c.MarkAsWatched},
+		{"DELETE", "/api/movie/{id}/watched", viewer, c.UnmarkAsWatched},
+		{"GET", "/api/movie/{id}/reviews", viewer, c.GetMovieReviews},
+		{"POST", "/api/movie/{id}/reviews", viewer, c.ReviewMovie},
+		{"GET", "/api/playlists", viewer, c.GetMyPlaylists},
+		{"POST", "/api/playlist", viewer, c.CreatePlaylist},
+		{"GET", "/api/playlist/{id}", viewer, c.GetPlaylist},
+		{"PUT", "/api/playlist/{id}", viewer, c.UpdatePlaylist},
+		{"DELETE", "/api/playlist/{id}", viewer, c.DeletePlaylist},
+		{"POST", "/api/playlist/{id}/entries", viewer, c.AddPlaylistEntry},
+		{"DELETE", "/api/playlist/{id}/entries/{movieId}", viewer, c.RemovePlaylistEntry},
+		{"PUT", "/api/playlist/{id}/entries/{movieId}/position", viewer, c.MovePlaylistEntry},
+		{"GET", "/api/queue", viewer, c.GetQueue},
+		{"POST", "/api/queue/entries", viewer, c.AddQueueEntry},
+		{"DELETE", "/api/queue/entries/{movieId}", viewer, c.RemoveQueueEntry},
+		{"PUT", "/api/queue/entries/{movieId}/position", viewer, c.MoveQueueEntry},
+		{"DELETE", "/api/deleteallmovie", admin, c.DeleteAllMoviesHandler},
+		{"POST", "/api/movies/merge", admin, c.MergeMovies},
+		{"GET", "/api/trash", editor, c.GetTrash},
+		{"POST", "/api/trash/movie/{id}/restore", editor, c.RestoreMovie},
+		{"POST", "/api/trash/course/{id}/restore", admin, c.RestoreCourse},
+		{"GET", "/api/keys", admin, c.GetAllAPIKeys},
+		{"POST", "/api/key", admin, c.CreateAPIKey},
+		{"DELETE", "/api/key/{id}", admin, c.RevokeAPIKey},
+		{"GET", "/api/audit", admin, c.GetAuditLog},
+	}
+	// Routes are registered one by one rather than on an /api subrouter,
+	// which would turn method mismatches into 404s. Other routes added to
+	// the returned router (the web pages) are not authenticated. Every API
+	// request gets an ID first, so even denials are traceable.
+	for _, rt := range routes {
+		router.Handle(rt.path, controller.RequestID(c.Authenticate(c.Authorize(rt.role, rt.handler)))).Methods(rt.method)
+	}
 	return router
-}
\ No newline at end of file
+}